@@ -0,0 +1,284 @@
+package gobflib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// BenchProgram is a BF program to benchmark. Like for a conformance test,
+// the input of prog.b is in prog.in, and is empty if there is none.
+type BenchProgram struct {
+	// Name is the path of the program file
+	Name   string
+	Source []byte
+	Input  []byte
+}
+
+// LoadBenchPrograms loads the given program files, and the program files
+// in the given directories, which are searched recursively.
+func LoadBenchPrograms(paths ...string) ([]BenchProgram, error) {
+	var prgms []BenchProgram
+	err := walkPrograms(paths, func(fname string, named bool) error {
+		p := BenchProgram{Name: fname}
+		var err error
+		if p.Source, err = ioutil.ReadFile(fname); err != nil {
+			return err
+		}
+		p.Input, err = ioutil.ReadFile(conformanceFile(fname, ".in"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		prgms = append(prgms, p)
+		return nil
+	})
+	return prgms, err
+}
+
+// IL returns the IL tree of the program, optimized with level.
+func (p *BenchProgram) IL(level OptimizeLevel) (*il.ILBlock, error) {
+	return optimizedIL(p.Source, level)
+}
+
+// BenchResult holds the measurements of one program, optimized with one
+// level and built with one backend, for each repetition of a benchmark.
+type BenchResult struct {
+	Program string `json:"program"`
+	Level   string `json:"level"`
+	Backend string `json:"backend"`
+	// Blocks is the number of blocks in the optimized IL tree
+	Blocks int `json:"blocks"`
+	// Size is the size in bytes of the binary, of the generated source
+	// for backends that are interpreted, or of the machine code of the JIT
+	Size int64 `json:"size"`
+	// CompileTimes are the seconds from reading the program to a
+	// runnable binary, one per repetition
+	CompileTimes []float64 `json:"compile_times"`
+	// RunTimes are the seconds the program ran, one per repetition
+	RunTimes []float64 `json:"run_times"`
+}
+
+// Key identifies the program, level and backend of r, which a benchmark
+// measures once.
+func (r *BenchResult) Key() string {
+	return r.Program + " " + r.Level + " " + r.Backend
+}
+
+// BenchReport is the outcome of a benchmark, with the host it ran on, so
+// that it can be saved and compared with later runs.
+type BenchReport struct {
+	GoVersion string `json:"go_version"`
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	NumCPU    int    `json:"num_cpu"`
+	// Reps is the number of times each program was built and run
+	Reps    int           `json:"reps"`
+	Results []BenchResult `json:"results"`
+}
+
+// ReadBenchReport reads a report written by WriteJSON.
+func ReadBenchReport(in io.Reader) (*BenchReport, error) {
+	report := new(BenchReport)
+	if err := json.NewDecoder(in).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (report *BenchReport) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// WriteCSV writes the report as CSV with a header, and one record per
+// repetition of each result.
+func (report *BenchReport) WriteCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"program", "level", "backend", "rep", "blocks", "size", "compile_seconds", "run_seconds"})
+	for _, r := range report.Results {
+		for i := range r.RunTimes {
+			w.Write([]string{
+				r.Program, r.Level, r.Backend,
+				strconv.Itoa(i + 1),
+				strconv.Itoa(r.Blocks),
+				strconv.FormatInt(r.Size, 10),
+				strconv.FormatFloat(r.CompileTimes[i], 'g', -1, 64),
+				strconv.FormatFloat(r.RunTimes[i], 'g', -1, 64),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// BenchComparison compares a measurement of a result with the same
+// measurement of the baseline.
+type BenchComparison struct {
+	// Key is the key of both results
+	Key string
+	// Metric is "compile" or "run"
+	Metric string
+	// Base and Current are the mean seconds of the baseline and the
+	// current result
+	Base, Current float64
+	// P is the two-sided p-value of Welch's t-test on the repetitions
+	P float64
+	// Significant is true if P is below the significance level
+	Significant bool
+}
+
+// Change returns the relative change from the baseline to the current mean.
+// It returns false if the baseline mean is zero, when there is none.
+func (c *BenchComparison) Change() (float64, bool) {
+	if c.Base == 0 {
+		return 0, false
+	}
+	return (c.Current - c.Base) / c.Base, true
+}
+
+// CompareBench compares the compile and run times of each result of
+// report with the result of base with the same key, using Welch's t-test
+// with significance level alpha. Results missing from base are skipped.
+func CompareBench(base, report *BenchReport, alpha float64) []BenchComparison {
+	baseResults := make(map[string]*BenchResult)
+	for i := range base.Results {
+		baseResults[base.Results[i].Key()] = &base.Results[i]
+	}
+
+	var comps []BenchComparison
+	for i := range report.Results {
+		r := &report.Results[i]
+		b, ok := baseResults[r.Key()]
+		if !ok {
+			continue
+		}
+		for _, m := range []struct {
+			metric        string
+			base, current []float64
+		}{
+			{"compile", b.CompileTimes, r.CompileTimes},
+			{"run", b.RunTimes, r.RunTimes},
+		} {
+			c := BenchComparison{Key: r.Key(), Metric: m.metric}
+			c.Base, _ = meanVariance(m.base)
+			c.Current, _ = meanVariance(m.current)
+			_, c.P = WelchTTest(m.base, m.current)
+			c.Significant = c.P < alpha
+			comps = append(comps, c)
+		}
+	}
+	return comps
+}
+
+// meanVariance returns the mean and the unbiased sample variance of xs.
+func meanVariance(xs []float64) (mean, variance float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	if len(xs) < 2 {
+		return mean, 0
+	}
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean)
+	}
+	return mean, variance / float64(len(xs)-1)
+}
+
+// MeanStdDev returns the mean and the sample standard deviation of xs.
+func MeanStdDev(xs []float64) (mean, stddev float64) {
+	mean, variance := meanVariance(xs)
+	return mean, math.Sqrt(variance)
+}
+
+// WelchTTest returns the t statistic of Welch's t-test for the means of
+// the samples a and b, which may have different variances, and its
+// two-sided p-value. The p-value is 1 if a sample has fewer than two
+// values, or if neither varies and their means are equal.
+func WelchTTest(a, b []float64) (t, p float64) {
+	if len(a) < 2 || len(b) < 2 {
+		return 0, 1
+	}
+	ma, va := meanVariance(a)
+	mb, vb := meanVariance(b)
+	sa, sb := va/float64(len(a)), vb/float64(len(b))
+	if sa+sb == 0 {
+		if ma == mb {
+			return 0, 1
+		}
+		return math.Copysign(math.Inf(1), ma-mb), 0
+	}
+	t = (ma - mb) / math.Sqrt(sa+sb)
+	df := (sa + sb) * (sa + sb) / (sa*sa/float64(len(a)-1) + sb*sb/float64(len(b)-1))
+	return t, incompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// incompleteBeta returns the regularized incomplete beta function
+// I_x(a, b), from its continued fraction.
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lab, _ := math.Lgamma(a + b)
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly below the mean, so above
+	// it use the symmetry I_x(a, b) = 1 - I_(1-x)(b, a)
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with the modified Lentz method.
+func betaFraction(a, b, x float64) float64 {
+	const (
+		epsilon = 1e-15
+		tiny    = 1e-300
+	)
+	clamp := func(v float64) float64 {
+		if math.Abs(v) < tiny {
+			return tiny
+		}
+		return v
+	}
+
+	c := 1.0
+	d := 1 / clamp(1-(a+b)*x/(a+1))
+	h := d
+	for m := 1.0; m <= 300; m++ {
+		// The even step
+		aa := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 / clamp(1+aa*d)
+		c = clamp(1 + aa/c)
+		h *= d * c
+		// The odd step
+		aa = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 / clamp(1+aa*d)
+		c = clamp(1 + aa/c)
+		h *= d * c
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,121 @@
+package gobflib
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWelchTTest(t *testing.T) {
+	tests := []struct {
+		a, b []float64
+		t, p float64
+	}{
+		// The first example of Welch's t-test on Wikipedia
+		{
+			[]float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4},
+			[]float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4},
+			-2.46, 0.021,
+		},
+		// The second example, with unequal sizes and variances
+		{
+			[]float64{17.2, 20.9, 22.6, 18.1, 21.7, 21.4, 23.5, 24.2, 14.7, 21.8},
+			[]float64{21.5, 22.8, 21.0, 23.0, 21.6, 23.6, 22.5, 20.7, 23.4, 21.8, 20.7, 21.7, 21.5, 22.5, 23.6, 21.5, 22.5, 23.5, 21.5, 21.8},
+			-1.57, 0.149,
+		},
+		{[]float64{1, 2, 3}, []float64{1, 2, 3}, 0, 1},
+		{[]float64{1, 1}, []float64{1, 1}, 0, 1},
+		{[]float64{1, 1}, []float64{2, 2}, math.Inf(-1), 0},
+		{[]float64{1}, []float64{2, 3}, 0, 1},
+	}
+	for _, test := range tests {
+		tstat, p := WelchTTest(test.a, test.b)
+		if math.Abs(tstat-test.t) > 0.005 && !(math.IsInf(test.t, 0) && tstat == test.t) {
+			t.Errorf("WelchTTest(%v, %v) t = %v, expected %v", test.a, test.b, tstat, test.t)
+		}
+		if math.Abs(p-test.p) > 0.0005 {
+			t.Errorf("WelchTTest(%v, %v) p = %v, expected %v", test.a, test.b, p, test.p)
+		}
+	}
+}
+
+func TestIncompleteBeta(t *testing.T) {
+	// I_x(1, 1) = x and I_x(a, 1) = x^a
+	for _, x := range []float64{0, 0.1, 0.5, 0.9, 1} {
+		if got := incompleteBeta(1, 1, x); math.Abs(got-x) > 1e-12 {
+			t.Errorf("incompleteBeta(1, 1, %v) = %v", x, got)
+		}
+		if got := incompleteBeta(3, 1, x); math.Abs(got-x*x*x) > 1e-12 {
+			t.Errorf("incompleteBeta(3, 1, %v) = %v", x, got)
+		}
+	}
+}
+
+func TestBenchReport(t *testing.T) {
+	report := &BenchReport{
+		GOOS:   "linux",
+		GOARCH: "amd64",
+		Reps:   2,
+		Results: []BenchResult{
+			{"a.b", "all", "jit", 5, 120, []float64{0.001, 0.002}, []float64{0.5, 0.25}},
+			{"b.b", "none", "c", 9, 16000, []float64{0.75, 0.5}, []float64{0.125, 1}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBenchReport(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, report) {
+		t.Fatalf("Read %+v, expected %+v", read, report)
+	}
+
+	out.Reset()
+	if err := report.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"program,level,backend,rep,blocks,size,compile_seconds,run_seconds",
+		"a.b,all,jit,1,5,120,0.001,0.5",
+		"a.b,all,jit,2,5,120,0.002,0.25",
+		"b.b,none,c,1,9,16000,0.75,0.125",
+		"b.b,none,c,2,9,16000,0.5,1",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Fatalf("CSV is\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
+
+func TestCompareBench(t *testing.T) {
+	base := &BenchReport{Results: []BenchResult{
+		{Program: "a.b", Level: "all", Backend: "jit", CompileTimes: []float64{1, 1.1, 0.9}, RunTimes: []float64{2, 2.1, 1.9}},
+		{Program: "b.b", Level: "all", Backend: "jit", CompileTimes: []float64{1, 1}, RunTimes: []float64{1, 1}},
+	}}
+	report := &BenchReport{Results: []BenchResult{
+		{Program: "a.b", Level: "all", Backend: "jit", CompileTimes: []float64{1.1, 0.9, 1}, RunTimes: []float64{4, 4.1, 3.9}},
+		{Program: "c.b", Level: "all", Backend: "jit", CompileTimes: []float64{1, 1}, RunTimes: []float64{1, 1}},
+	}}
+
+	comps := CompareBench(base, report, 0.05)
+	if len(comps) != 2 {
+		t.Fatalf("Got %d comparisons, expected 2 for the only common result", len(comps))
+	}
+	compile, run := comps[0], comps[1]
+	if compile.Metric != "compile" || compile.Significant || compile.P != 1 {
+		t.Errorf("Compile times of the same samples compared as %+v", compile)
+	}
+	if change, ok := run.Change(); run.Metric != "run" || !run.Significant || !ok || math.Abs(change-1) > 1e-9 {
+		t.Errorf("Run times twice as long compared as %+v", run)
+	}
+	zero := BenchComparison{Current: 1}
+	if change, ok := zero.Change(); ok {
+		t.Errorf("Change from a zero baseline is %v", change)
+	}
+}
@@ -20,16 +20,24 @@ var ErrJumpLocationExceedsCommands = errors.New("Error: Jump location exceeds co
 var ErrDataPtr = errors.New("Error: Data pointer moved out of bounds (off the beginning)")
 var ErrReadError = errors.New("Error: Received read error during runtime")
 var ErrWriteError = errors.New("Error: Received write error during runtime")
+var ErrUnbalancedLoopEnd = errors.New("Error: Found ] without a matching [")
 
 // BFProgram represents an active program state for a BF program using the
 // the native and unoptimized BF commands.
+//
+// Reading at the end of input leaves the current cell unchanged, like the
+// JIT and the generated programs. Before the conformance tests, it failed
+// with ErrReadError, which SetEOFError brings back.
 type BFProgram struct {
 	cmdptr   uint64
 	dataptr  uint64
 	commands []lang.BFCmd
+	cmdpos   []il.Pos
 	data     []byte
 	input    io.Reader
 	output   io.Writer
+	outbuf   *bufio.Writer // when not nil, output is written through it
+	eofError bool          // when true, reading at the end of input fails
 
 	jumpstack    []uint64
 	fwdjump      map[uint64]uint64
@@ -60,6 +68,7 @@ func NewIOBFProgram(initialcommandssize, initialdatasize uint64, input io.Reader
 	}
 	p := new(BFProgram)
 	p.commands = make([]lang.BFCmd, 0, initialcommandssize)
+	p.cmdpos = make([]il.Pos, 0, initialcommandssize)
 	p.data = make([]byte, initialdatasize)
 	p.jumpstack = make([]uint64, 0, defaultJumpStackSize)
 	p.fwdjump = make(map[uint64]uint64)
@@ -73,7 +82,9 @@ func (p *BFProgram) Clone() *BFProgram {
 	pnew := new(BFProgram)
 	pnew.commands = make([]lang.BFCmd, 0, len(p.commands))
 	pnew.commands = append(pnew.commands, p.commands...)
-	pnew.data = make([]byte, len(p.data))
+	pnew.cmdpos = make([]il.Pos, 0, len(p.cmdpos))
+	pnew.cmdpos = append(pnew.cmdpos, p.cmdpos...)
+	pnew.data = make([]byte, 0, len(p.data))
 	pnew.data = append(pnew.data, p.data...)
 	pnew.jumpstack = make([]uint64, 0, len(p.jumpstack))
 	pnew.jumpstack = append(pnew.jumpstack, p.jumpstack...)
@@ -90,20 +101,62 @@ func (p *BFProgram) Clone() *BFProgram {
 	pnew.dataptr = p.dataptr
 	pnew.input = p.input
 	pnew.output = p.output
+	pnew.eofError = p.eofError
+	if p.outbuf != nil {
+		pnew.SetBuffered(true)
+	}
 	return pnew
 }
 
-func (p *BFProgram) AppendCommand(cmd rune) {
+// SetBuffered sets whether program output is buffered. Buffered output is
+// flushed when the program reads input, finishes, or fails, so prompts
+// are still shown before waiting for input.
+func (p *BFProgram) SetBuffered(buffered bool) {
+	p.Flush()
+	if buffered {
+		p.outbuf = bufio.NewWriter(p.output)
+	} else {
+		p.outbuf = nil
+	}
+}
+
+// SetEOFError sets whether reading at the end of input fails with
+// ErrReadError, instead of leaving the current cell unchanged.
+func (p *BFProgram) SetEOFError(eofError bool) {
+	p.eofError = eofError
+}
+
+// Flush writes any buffered program output.
+func (p *BFProgram) Flush() error {
+	if p.outbuf == nil {
+		return nil
+	}
+	if err := p.outbuf.Flush(); err != nil {
+		return ErrWriteError
+	}
+	return nil
+}
+
+// AppendCommand appends cmd to the program. It returns
+// ErrUnbalancedLoopEnd, and leaves the program unchanged, for a ] without
+// a matching [.
+func (p *BFProgram) AppendCommand(cmd rune) error {
+	return p.appendCommandAt(cmd, il.Pos{})
+}
+
+// appendCommandAt appends cmd and records the source position
+// it was read from.
+func (p *BFProgram) appendCommandAt(cmd rune, pos il.Pos) error {
 	c := lang.NewBFCmd(cmd)
 	if c == lang.BFCmdUnknown {
-		return
+		return nil
 	}
 	if c == lang.BFCmdLoopStart {
 		p.jumppush(p.appendcmdptr)
 	}
 	if c == lang.BFCmdLoopEnd {
 		if p.jumplen() == 0 {
-			panic("Unbalanced [ ]")
+			return ErrUnbalancedLoopEnd
 		}
 		openptr := p.jumppop()
 		closedptr := p.appendcmdptr
@@ -111,48 +164,65 @@ func (p *BFProgram) AppendCommand(cmd rune) {
 		p.revjump[closedptr] = openptr
 	}
 	p.commands = append(p.commands, c)
+	p.cmdpos = append(p.cmdpos, pos)
 	p.appendcmdptr++
+	return nil
 }
 
-func (p *BFProgram) AppendCommands(cmds ...rune) {
+// AppendCommands appends cmds to the program, and returns the first error
+// of AppendCommand.
+func (p *BFProgram) AppendCommands(cmds ...rune) error {
 	for _, c := range cmds {
-		p.AppendCommand(c)
+		if err := p.AppendCommand(c); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (p *BFProgram) ReadCommands(in io.Reader) {
+// ReadCommands appends the commands of the BF source read from in, which
+// may have comments starting with #. It returns a read error, or an error
+// wrapping ErrUnbalancedLoopEnd with the position of the ], after which
+// the commands before it are kept.
+func (p *BFProgram) ReadCommands(in io.Reader) error {
 	cmdstream := bufio.NewReader(in)
 	var ignoreLine = false
 	var sameLine = false
+	var pos = il.Pos{Line: 1, Col: 1}
 	for {
 		line, isPrefix, err := cmdstream.ReadLine()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while reading file: %v\n", err)
-			os.Exit(1)
-		}
-
-		if sameLine && ignoreLine {
-			sameLine = isPrefix
-			continue
+			return fmt.Errorf("error while reading the program: %w", err)
 		}
 
 		if !sameLine {
 			ignoreLine = false
 		}
 
-		for _, c := range line {
-			if c == byte('#') {
-				ignoreLine = true
-				break
+		if !ignoreLine {
+			for i, c := range line {
+				if c == byte('#') {
+					ignoreLine = true
+					break
+				}
+				// this will ignore anything but BF characters
+				cpos := il.Pos{Line: pos.Line, Col: pos.Col + i}
+				if err := p.appendCommandAt(rune(c), cpos); err != nil {
+					return fmt.Errorf("%w at %v", err, cpos)
+				}
 			}
-			// this will ignore anything but BF characters
-			p.AppendCommand(rune(c))
 		}
 
 		sameLine = isPrefix
+		if sameLine {
+			pos.Col += len(line)
+		} else {
+			pos.Line++
+			pos.Col = 1
+		}
 	}
 }
 
@@ -197,19 +267,21 @@ func (p *BFProgram) RunStep() (bool, error) {
 	case lang.BFCmdDataDecrement:
 		p.data[p.dataptr]--
 	case lang.BFCmdInputByte:
-		var b [1]byte
-		for {
-			n, err := p.input.Read(b[:])
-			if err != nil {
-				return false, ErrReadError
-			}
-			if n > 0 {
-				break
-			}
+		if err := p.Flush(); err != nil {
+			return false, err
+		}
+		_, err := io.ReadFull(p.input, p.data[p.dataptr:p.dataptr+1])
+		if err != nil && (err != io.EOF || p.eofError) {
+			return false, ErrReadError
 		}
-		p.data[p.dataptr] = b[0]
 
 	case lang.BFCmdOutputByte:
+		if p.outbuf != nil {
+			if err := p.outbuf.WriteByte(p.data[p.dataptr]); err != nil {
+				return false, ErrWriteError
+			}
+			break
+		}
 		n, err := p.output.Write(p.data[p.dataptr : p.dataptr+1])
 		if err != nil {
 			return false, ErrWriteError
@@ -237,23 +309,25 @@ func (p *BFProgram) RunStep() (bool, error) {
 func (p *BFProgram) Run() error {
 	for finished, err := p.RunStep(); !finished; finished, err = p.RunStep() {
 		if err != nil {
+			p.Flush()
 			return err
 		}
 	}
-	return nil
+	return p.Flush()
 }
 
 func (p *BFProgram) CreateILTree() *il.ILBlock {
 	s := il.NewILBlockStack()
 	ib := il.NewILBlock(il.ILList)
 	var cur = ib
-	for _, c := range p.commands {
+	for i, c := range p.commands {
 		if c == lang.BFCmdLoopEnd {
 			cur = s.Pop()
 			continue
 		}
 
 		b := c.ToILBlock()
+		b.SetPos(p.cmdpos[i])
 		cur.Append(b)
 
 		if c == lang.BFCmdLoopStart {
@@ -0,0 +1,157 @@
+package gobflib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// ConformanceTest is a BF program with the output every execution path
+// must give for its input.
+//
+// A test is a program file prog.b or prog.bf next to prog.out, which holds
+// the expected output. The optional prog.in holds the input, which is
+// empty otherwise. If prog.err exists, the program must fail after
+// writing the expected output, like when the data pointer moves below
+// zero. Programs without a .out file are not tests.
+type ConformanceTest struct {
+	// Name is the path of the program file
+	Name   string
+	Source []byte
+	Input  []byte
+	Output []byte
+	// Fail is true if the program must end with an error
+	Fail bool
+}
+
+// LoadConformanceTests loads the tests in the given program files and
+// directories, which are searched recursively.
+func LoadConformanceTests(paths ...string) ([]ConformanceTest, error) {
+	var tests []ConformanceTest
+	err := walkPrograms(paths, func(fname string, named bool) error {
+		t, ok, err := loadConformanceTest(fname)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if named {
+				return fmt.Errorf("%s has no expected output file %s", fname, conformanceFile(fname, ".out"))
+			}
+			return nil
+		}
+		tests = append(tests, t)
+		return nil
+	})
+	return tests, err
+}
+
+// walkPrograms calls fn with each of the given program files, where named
+// is true, and each program file with extension .b or .bf in the given
+// directories, which are searched recursively.
+func walkPrograms(paths []string, fn func(fname string, named bool) error) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := fn(path, true); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.Walk(path, func(fname string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			if ext := filepath.Ext(fname); ext != ".b" && ext != ".bf" {
+				return nil
+			}
+			return fn(fname, false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conformanceFile returns the name of the file with extension ext that
+// goes with the program file fname.
+func conformanceFile(fname, ext string) string {
+	return strings.TrimSuffix(fname, filepath.Ext(fname)) + ext
+}
+
+// loadConformanceTest loads the test for the program file fname. It
+// returns false if the program has no expected output.
+func loadConformanceTest(fname string) (ConformanceTest, bool, error) {
+	t := ConformanceTest{Name: fname}
+	var err error
+	t.Output, err = ioutil.ReadFile(conformanceFile(fname, ".out"))
+	if os.IsNotExist(err) {
+		return t, false, nil
+	}
+	if err != nil {
+		return t, false, err
+	}
+	if t.Source, err = ioutil.ReadFile(fname); err != nil {
+		return t, false, err
+	}
+	t.Input, err = ioutil.ReadFile(conformanceFile(fname, ".in"))
+	if err != nil && !os.IsNotExist(err) {
+		return t, false, err
+	}
+	if _, err := os.Stat(conformanceFile(fname, ".err")); err == nil {
+		t.Fail = true
+	}
+	return t, true, nil
+}
+
+// Check returns an error describing how the output and error of a run of
+// the program differ from the expected ones, or nil if they match.
+func (t *ConformanceTest) Check(output []byte, err error) error {
+	if err == nil && t.Fail {
+		return fmt.Errorf("the program did not fail")
+	}
+	if err != nil && !t.Fail {
+		return fmt.Errorf("the program failed: %v", err)
+	}
+	if !bytes.Equal(output, t.Output) {
+		return fmt.Errorf("the output is %q, expected %q", output, t.Output)
+	}
+	return nil
+}
+
+// Run runs the test program with the interpreter, and returns its output
+// and error.
+func (t *ConformanceTest) Run() ([]byte, error) {
+	var out bytes.Buffer
+	prgm := NewIOBFProgram(uint64(len(t.Source)), 0, bytes.NewReader(t.Input), &out)
+	if err := prgm.ReadCommands(bytes.NewReader(t.Source)); err != nil {
+		return nil, err
+	}
+	err := prgm.Run()
+	return out.Bytes(), err
+}
+
+// IL returns the IL tree of the test program, optimized with level.
+func (t *ConformanceTest) IL(level OptimizeLevel) (*il.ILBlock, error) {
+	return optimizedIL(t.Source, level)
+}
+
+// optimizedIL returns the IL tree of the BF program src, optimized with
+// level, or the error of reading it.
+func optimizedIL(src []byte, level OptimizeLevel) (*il.ILBlock, error) {
+	prgm := NewBFProgram(uint64(len(src)), 0)
+	if err := prgm.ReadCommands(bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	b := prgm.CreateILTree()
+	level.Optimize(b)
+	return b, nil
+}
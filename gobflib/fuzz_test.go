@@ -0,0 +1,136 @@
+package gobflib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fuzzMaxSteps bounds the steps of the interpreter for a fuzzed program.
+// Programs that run longer are skipped, since they may never end.
+const fuzzMaxSteps = 10000
+
+// fuzzPasses are the optimization passes that the passes argument of the
+// fuzz targets picks from, one byte per pass.
+var fuzzPasses = []OptimizePass{PassCompress, PassPrune, PassVectorize, PassBalance, PassLinVector, PassZero,
+	PassHoist, PassCollapse, PassUnroll}
+
+// fuzzIdioms are common BF snippets that the program generator mixes in,
+// so that the pattern optimizations have something to match.
+var fuzzIdioms = []string{"[-]", "[+]", "[->+<]", "[->>++<<]", "[-<+>>+<]", "[>]", "[<]", "+[-->+<]"}
+
+// balanceBF keeps the BF commands of src, drops each ] without a matching
+// [, and closes the loops left open.
+func balanceBF(src []byte) []byte {
+	var out []byte
+	var depth int
+	for _, c := range src {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				continue
+			}
+			depth--
+		case '+', '-', '<', '>', '.', ',':
+		default:
+			continue
+		}
+		out = append(out, c)
+	}
+	return append(out, bytes.Repeat([]byte("]"), depth)...)
+}
+
+// generateBF returns a random balanced BF program of about size commands.
+func generateBF(seed int64, size int) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	var out []byte
+	var depth int
+	for len(out) < size {
+		switch n := rnd.Intn(20); {
+		case n < 2:
+			out = append(out, '[')
+			depth++
+		case n < 4 && depth > 0:
+			out = append(out, ']')
+			depth--
+		case n < 6:
+			out = append(out, fuzzIdioms[rnd.Intn(len(fuzzIdioms))]...)
+		default:
+			out = append(out, "+-<>+->.,"[rnd.Intn(9)])
+		}
+	}
+	return append(out, bytes.Repeat([]byte("]"), depth)...)
+}
+
+// checkOptimized checks that the IL tree of the BF program src gives the
+// same output and final tape as the interpreter with each optimization
+// level, and with the passes picked by passes, see Diverges.
+func checkOptimized(t *testing.T, src, input, passes []byte) {
+	levels := append([]OptimizeLevel(nil), OptimizeLevels...)
+	if len(passes) > 0 {
+		var custom OptimizeLevel
+		var names []string
+		for _, p := range passes {
+			pass := fuzzPasses[int(p)%len(fuzzPasses)]
+			custom.Passes = append(custom.Passes, pass)
+			names = append(names, pass.Name)
+		}
+		custom.Name = strings.Join(names, ",")
+		levels = append(levels, custom)
+	}
+
+	ct := ConformanceTest{Source: src}
+	for i, level := range levels {
+		if err := Diverges(src, input, level, fuzzMaxSteps); err != nil {
+			t.Fatalf("%q %v", src, err)
+		}
+		// The levels that compress end with a compressed tree, which
+		// prepareIL double checks
+		if i > 0 && i < len(OptimizeLevels) {
+			b, err := ct.IL(level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := b.Compress(); count > 0 {
+				t.Fatalf("%q with level %s needed %d more compresses", src, level.Name, count)
+			}
+		}
+	}
+}
+
+// FuzzOptimize mutates BF programs, which are balanced before they run,
+// and checks that the optimizations don't change what they do.
+func FuzzOptimize(f *testing.F) {
+	for _, tpair := range tests {
+		f.Add([]byte(tpair.cmds), tpair.input, []byte{4, 5, 0, 1})
+	}
+	files, _ := filepath.Glob("../testprograms/conformance/*.b")
+	for _, fname := range files {
+		src, err := ioutil.ReadFile(fname)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src, []byte("input"), []byte{2, 3, 4, 0, 1})
+	}
+	f.Add([]byte("+++[>++[>+<-]<-]>>."), []byte{}, []byte{2, 4, 1, 0})
+
+	f.Fuzz(func(t *testing.T, src, input, passes []byte) {
+		checkOptimized(t, balanceBF(src), input, passes)
+	})
+}
+
+// FuzzGenerate checks the optimizations with random balanced BF programs.
+func FuzzGenerate(f *testing.F) {
+	for seed := int64(0); seed < 8; seed++ {
+		f.Add(seed, uint8(32), []byte("abc"), []byte{2, 3, 4, 5, 0, 1})
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64, size uint8, input, passes []byte) {
+		checkOptimized(t, generateBF(seed, int(size)), input, passes)
+	})
+}
@@ -2,6 +2,8 @@ package gobflib
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -10,7 +12,9 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/linux4life798/gobf/gobflib/il"
 	"github.com/linux4life798/gobf/gobflib/lang"
 )
 
@@ -120,6 +124,37 @@ func RunTableTest(t *testing.T, tpair *testanspair) {
 	}
 }
 
+// RunMinifyTest is designed to run sub-tests that check a minified
+// program still produces the expected output
+func RunMinifyTest(t *testing.T, tpair *testanspair) {
+	prgm := NewIOBFProgram(0, 0, nil, nil)
+	prgm.ReadCommands(strings.NewReader(tpair.cmds))
+
+	minified := bytes.NewBuffer([]byte{})
+	if err := prgm.Minify(minified); err != nil {
+		t.Fatal(err)
+	}
+	if minified.Len() > len(prgm.commands) {
+		t.Log("minified:", minified.String())
+		t.Fatal("Minified program is longer than the original")
+	}
+
+	input := bytes.NewReader(tpair.input)
+	output := bytes.NewBuffer([]byte{})
+	mprgm := NewIOBFProgram(0, 0, input, output)
+	mprgm.ReadCommands(bytes.NewReader(minified.Bytes()))
+	if err := mprgm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(output.Bytes(), tpair.output) != 0 {
+		t.Log("minified:", minified.String())
+		t.Log("answer bytes:", tpair.output, string(tpair.output))
+		t.Log("output bytes:", output.Bytes(), string(output.Bytes()))
+		t.Fatal("Minified program output does not match expected output")
+	}
+}
+
 // RunBenchCompile is designed to run sub-benchmarks of the compilation
 // process
 func RunBenchCompile(b *testing.B, tpair *testanspair, vectorize bool) {
@@ -141,14 +176,15 @@ func RunBenchCompile(b *testing.B, tpair *testanspair, vectorize bool) {
 			ilb.Compress()
 			ilb.Prune()
 		}
-		if err, _ := lang.CompileIL(ilb, "/dev/null", false, false); err != nil {
+		if err, _ := lang.CompileIL(ilb, "/dev/null", lang.CompileOptions{}, lang.GenOptions{}); err != nil {
 			b.Fatal(err)
 		}
 	}
 }
 
 // RunBenchExecute is designed to run sub-benchmarks of the
-func RunBenchExecute(b *testing.B, tpair *testanspair, vectorize bool) {
+// The cache keeps the binary for the next b.N, which runs it again.
+func RunBenchExecute(b *testing.B, cache *lang.Cache, tpair *testanspair, vectorize bool) {
 	const outbin = "/tmp/gobflib_bench"
 	bfcmds := strings.NewReader(tpair.cmds)
 	input := bytes.NewReader(tpair.input)
@@ -167,7 +203,7 @@ func RunBenchExecute(b *testing.B, tpair *testanspair, vectorize bool) {
 		ilb.Compress()
 		ilb.Prune()
 	}
-	if err, _ := lang.CompileIL(ilb, outbin, false, false); err != nil {
+	if err, _ := lang.CompileIL(ilb, outbin, lang.CompileOptions{Cache: cache}, lang.GenOptions{}); err != nil {
 		b.Fatal(err)
 	}
 
@@ -189,6 +225,14 @@ func TestTable(t *testing.T) {
 	}
 }
 
+func TestMinifyTable(t *testing.T) {
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			RunMinifyTest(t, &tests[i])
+		})
+	}
+}
+
 func BenchmarkCompileTable(b *testing.B) {
 	for i := range tests {
 		b.Run(tests[i].name, func(b *testing.B) {
@@ -198,9 +242,10 @@ func BenchmarkCompileTable(b *testing.B) {
 }
 
 func BenchmarkExecuteTable(b *testing.B) {
+	cache := &lang.Cache{Dir: b.TempDir()}
 	for i := range tests {
 		b.Run(tests[i].name, func(b *testing.B) {
-			RunBenchExecute(b, &tests[i], vec)
+			RunBenchExecute(b, cache, &tests[i], vec)
 		})
 	}
 }
@@ -222,6 +267,7 @@ func BenchmarkCompileFiles(b *testing.B) {
 }
 
 func BenchmarkExecuteFiles(b *testing.B) {
+	cache := &lang.Cache{Dir: b.TempDir()}
 	for _, fname := range testFiles {
 		cmds, err := ioutil.ReadFile(fname)
 		if err != nil {
@@ -232,11 +278,549 @@ func BenchmarkExecuteFiles(b *testing.B) {
 		t.cmds = string(cmds)
 		t.input = []byte{}
 		b.Run(t.name, func(b *testing.B) {
-			RunBenchExecute(b, &t, vec)
+			RunBenchExecute(b, cache, &t, vec)
+		})
+	}
+}
+
+// promptReader records how much output was written before each read
+type promptReader struct {
+	input  io.Reader
+	output *bytes.Buffer
+	seen   []int
+}
+
+func (r *promptReader) Read(p []byte) (int, error) {
+	r.seen = append(r.seen, r.output.Len())
+	return r.input.Read(p)
+}
+
+func TestBufferedOutput(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	input := &promptReader{input: strings.NewReader("ab"), output: output}
+
+	// Print a prompt, read a byte, echo it, then do it again
+	prgm := NewIOBFProgram(0, 0, input, output)
+	prgm.ReadCommands(strings.NewReader("++++++++[>++++++++<-]>--.>,.<.>,."))
+	prgm.SetBuffered(true)
+
+	if err := prgm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); got != ">a>b" {
+		t.Fatalf("Output is %q, expected %q", got, ">a>b")
+	}
+	// The prompt must be visible before each read
+	if len(input.seen) != 2 || input.seen[0] != 1 || input.seen[1] != 3 {
+		t.Fatalf("Output lengths seen by reads are %v, expected [1 3]", input.seen)
+	}
+}
+
+func TestClone(t *testing.T) {
+	prgm := NewIOBFProgram(0, 4, nil, ioutil.Discard)
+	prgm.ReadCommands(strings.NewReader("+>++"))
+	if err := prgm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	clone := prgm.Clone()
+	if !bytes.Equal(clone.data, prgm.data) {
+		t.Fatalf("Clone has data %v, expected %v", clone.data, prgm.data)
+	}
+	clone.AppendCommands('+')
+	if err := clone.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if clone.data[1] != 3 || prgm.data[1] != 2 {
+		t.Fatalf("Running the clone changed the original, or not the clone")
+	}
+}
+
+func TestAppendUnbalanced(t *testing.T) {
+	prgm := NewBFProgram(0, 0)
+	if err := prgm.AppendCommands('[', ']', ']'); err != ErrUnbalancedLoopEnd {
+		t.Fatalf("AppendCommands returned %v, expected %v", err, ErrUnbalancedLoopEnd)
+	}
+	if len(prgm.commands) != 2 {
+		t.Fatalf("AppendCommands kept %d commands, expected 2", len(prgm.commands))
+	}
+}
+
+func TestReadCommandsErrors(t *testing.T) {
+	prgm := NewBFProgram(0, 0)
+	err := prgm.ReadCommands(strings.NewReader("+# ]\n+]"))
+	if !errors.Is(err, ErrUnbalancedLoopEnd) || !strings.HasSuffix(err.Error(), " at 2:2") {
+		t.Fatalf("ReadCommands returned %v, expected %v at 2:2", err, ErrUnbalancedLoopEnd)
+	}
+	if len(prgm.commands) != 2 {
+		t.Fatalf("ReadCommands kept %d commands, expected 2", len(prgm.commands))
+	}
+
+	readErr := errors.New("read failed")
+	if err := NewBFProgram(0, 0).ReadCommands(iotest.ErrReader(readErr)); !errors.Is(err, readErr) {
+		t.Fatalf("ReadCommands returned %v, expected %v", err, readErr)
+	}
+}
+
+func TestInputEOF(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		src      string
+		input    string
+		eofError bool
+		output   string
+		err      error
+	}{
+		{"input", "+,.", "a", false, "a", nil},
+		{"unchanged", "+,.", "", false, "\x01", nil},
+		{"unchanged after input", "+,.,.", "a", false, "aa", nil},
+		{"error", "+,.", "", true, "", ErrReadError},
+		{"error after input", "+,.,.", "a", true, "a", ErrReadError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			prgm := NewIOBFProgram(0, 0, strings.NewReader(test.input), &out)
+			prgm.SetEOFError(test.eofError)
+			if err := prgm.ReadCommands(strings.NewReader(test.src)); err != nil {
+				t.Fatal(err)
+			}
+			if err := prgm.Run(); err != test.err {
+				t.Errorf("Run returned %v, expected %v", err, test.err)
+			}
+			if out.String() != test.output {
+				t.Errorf("Output is %q, expected %q", out.String(), test.output)
+			}
 		})
 	}
 }
 
+// TestGenFunc embeds every table program as a function in one package
+// and runs them concurrently with go test.
+func TestGenFunc(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not available")
+	}
+	if testing.Short() {
+		t.Skip("skipping build of generated functions in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "gobfgenfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module bfprog\n\ngo 1.18\n")
+
+	gen := func(cmds, name string, unbuffered bool) {
+		prgm := NewIOBFProgram(0, 0, nil, nil)
+		prgm.ReadCommands(strings.NewReader(cmds))
+		ilb := prgm.CreateILTree()
+		ilb.Compress()
+		ilb.Prune()
+		ilb.Vectorize()
+		ilb.VectorBalance()
+
+		var out bytes.Buffer
+		opts := lang.GenOptions{Package: "bfprog", Func: name, Unbuffered: unbuffered}
+		if err := lang.ILBlockToGo(ilb, &out, opts); err != nil {
+			t.Fatal(err)
+		}
+		write(name+".go", out.String())
+	}
+
+	var cases strings.Builder
+	for i := range tests {
+		gen(tests[i].cmds, fmt.Sprintf("Run%d", i), i%2 == 1)
+		fmt.Fprintf(&cases, "\t{%q, Run%d, %q, %q},\n", tests[i].name, i, tests[i].input, tests[i].output)
+	}
+	gen("+.<", "RunBelowZero", false)
+
+	write("prog_test.go", `package bfprog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var cases = []struct {
+	name          string
+	run           func(io.Reader, io.Writer) error
+	input, output string
+}{
+`+cases.String()+`}
+
+func TestPrograms(t *testing.T) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			if err := c.run(bytes.NewBufferString(c.input), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != c.output {
+				t.Fatalf("output is %q, expected %q", out.String(), c.output)
+			}
+		})
+	}
+}
+
+func TestBelowZero(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunBelowZero(nil, &out); err == nil {
+		t.Fatal("moving below zero did not return an error")
+	}
+	if out.String() != "\x01" {
+		t.Fatalf("output before the error is %q, expected %q", out.String(), "\x01")
+	}
+}
+`)
+
+	gotest := exec.Command("go", "test", ".")
+	gotest.Dir = dir
+	if out, err := gotest.CombinedOutput(); err != nil {
+		t.Fatalf("go test of generated functions failed: %v\n%s", err, out)
+	}
+}
+
+// RunBackendTest compiles a program with the given backend and checks
+// its output
+func RunBackendTest(t *testing.T, tpair *testanspair, outbin string,
+	compile func(*il.ILBlock, string, lang.CompileOptions, lang.GenOptions) (error, string)) {
+	prgm := NewIOBFProgram(0, 0, nil, nil)
+	prgm.ReadCommands(strings.NewReader(tpair.cmds))
+
+	ilb := prgm.CreateILTree()
+	ilb.Compress()
+	ilb.Prune()
+	ilb.Vectorize()
+	ilb.VectorBalance()
+	ilb.PatternReplace(il.PatternReplaceLinearVector)
+	ilb.PatternReplace(il.PatternReplaceZero)
+	if err, tempdir := compile(ilb, outbin, lang.CompileOptions{}, lang.GenOptions{}); err != nil {
+		os.RemoveAll(tempdir)
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(outbin)
+	cmd.Stdin = bytes.NewReader(tpair.input)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(output, tpair.output) != 0 {
+		t.Log("answer bytes:", tpair.output, string(tpair.output))
+		t.Log("output bytes:", output, string(output))
+		t.Fatal("Output does not match expected output")
+	}
+}
+
+// forBackendTable runs test for each of the table tests and test files.
+// The expected output of the test files is given by the interpreter.
+func forBackendTable(t *testing.T, test func(t *testing.T, tpair *testanspair)) {
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			test(t, &tests[i])
+		})
+	}
+	for _, fname := range testFiles {
+		cmds, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tpair testanspair
+		tpair.name = filepath.Base(fname)
+		tpair.cmds = string(cmds)
+
+		// The interpreter gives the expected output
+		var output bytes.Buffer
+		prgm := NewIOBFProgram(0, 0, bytes.NewReader(nil), &output)
+		prgm.ReadCommands(bytes.NewReader(cmds))
+		if err := prgm.Run(); err != nil {
+			t.Fatal(err)
+		}
+		tpair.output = output.Bytes()
+
+		t.Run(tpair.name, func(t *testing.T) {
+			test(t, &tpair)
+		})
+	}
+	conformance, err := LoadConformanceTests("../testprograms/conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range conformance {
+		// Failing programs are checked by TestConformance
+		if ct.Fail {
+			continue
+		}
+		tpair := testanspair{
+			name:   filepath.Base(ct.Name),
+			cmds:   string(ct.Source),
+			input:  ct.Input,
+			output: ct.Output,
+		}
+		t.Run(tpair.name, func(t *testing.T) {
+			test(t, &tpair)
+		})
+	}
+}
+
+// runBackendTable runs the table tests and test files with a backend
+func runBackendTable(t *testing.T, compile func(*il.ILBlock, string, lang.CompileOptions, lang.GenOptions) (error, string)) {
+	dir, err := ioutil.TempDir("", "gobfbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	forBackendTable(t, func(t *testing.T, tpair *testanspair) {
+		RunBackendTest(t, tpair, filepath.Join(dir, "prog"), compile)
+	})
+}
+
+// runScriptTable runs the table tests and test files with a backend that
+// generates source for the interpreter command interp, like node.
+func runScriptTable(t *testing.T, gen func(*il.ILBlock, io.Writer, lang.GenOptions) error, interp string) {
+	if _, err := exec.LookPath(interp); err != nil {
+		t.Skip(interp, " is not available")
+	}
+	dir, err := ioutil.TempDir("", "gobfscript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	forBackendTable(t, func(t *testing.T, tpair *testanspair) {
+		prgm := NewIOBFProgram(0, 0, nil, nil)
+		prgm.ReadCommands(strings.NewReader(tpair.cmds))
+		ilb := prgm.CreateILTree()
+		ilb.Compress()
+		ilb.Prune()
+		ilb.Vectorize()
+		ilb.VectorBalance()
+		ilb.PatternReplace(il.PatternReplaceLinearVector)
+		ilb.PatternReplace(il.PatternReplaceZero)
+
+		var src bytes.Buffer
+		if err := gen(ilb, &src, lang.GenOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		script := filepath.Join(dir, "prog")
+		if err := ioutil.WriteFile(script, src.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(interp, script)
+		cmd.Stdin = bytes.NewReader(tpair.input)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, tpair.output) {
+			t.Log("answer bytes:", tpair.output, string(tpair.output))
+			t.Log("output bytes:", output, string(output))
+			t.Fatal("Output does not match expected output")
+		}
+	})
+}
+
+func TestCTable(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc is not available")
+	}
+	runBackendTable(t, lang.CompileILC)
+}
+
+func TestAsmTable(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("the assembly backend only runs on linux/amd64")
+	}
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool, " is not available")
+		}
+	}
+	runBackendTable(t, lang.CompileILAsm)
+}
+
+func TestLLVMTable(t *testing.T) {
+	_, clang := exec.LookPath("clang")
+	_, llc := exec.LookPath("llc")
+	if clang != nil && llc != nil {
+		t.Skip("neither clang nor llc is available")
+	}
+	runBackendTable(t, lang.CompileILLLVM)
+}
+
+func TestJSTable(t *testing.T) {
+	runScriptTable(t, lang.ILBlockToJS, "node")
+}
+
+func TestPythonTable(t *testing.T) {
+	runScriptTable(t, lang.ILBlockToPython, "python3")
+}
+
+func TestGenFuncErrors(t *testing.T) {
+	prgm := NewIOBFProgram(0, 0, nil, nil)
+	prgm.ReadCommands(strings.NewReader("+[<+]"))
+	ilb := prgm.CreateILTree()
+	var out bytes.Buffer
+	err := lang.ILBlockToGo(ilb, &out, lang.GenOptions{Package: "foo", Profile: true})
+	if err != lang.ErrFuncProfile {
+		t.Fatalf("Profiling a function gave %v, expected %v", err, lang.ErrFuncProfile)
+	}
+	if err := lang.ILBlockToGo(ilb, &out, lang.GenOptions{Func: "not valid"}); err == nil {
+		t.Fatal("Generating an invalid function name did not fail")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	tests, err := LoadConformanceTests("../testprograms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tests) == 0 {
+		t.Fatal("no conformance tests found")
+	}
+	for i := range tests {
+		ct := &tests[i]
+		t.Run(ct.Name, func(t *testing.T) {
+			if err := ct.Check(ct.Run()); err != nil {
+				t.Errorf("interpreter: %v", err)
+			}
+			for _, level := range OptimizeLevels {
+				b, err := ct.IL(level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var out bytes.Buffer
+				err = b.Run(bytes.NewReader(ct.Input), &out)
+				if err := ct.Check(out.Bytes(), err); err != nil {
+					t.Errorf("IL with level %s: %v", level.Name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeFlagsPasses(t *testing.T) {
+	names := func(passes []OptimizePass) string {
+		var names []string
+		for _, pass := range passes {
+			names = append(names, pass.Name)
+		}
+		return strings.Join(names, " ")
+	}
+	for _, test := range []struct {
+		flags    OptimizeFlags
+		expected string
+	}{
+		{OptimizeFlags{}, ""},
+		{OptimizeFlags{Prune: true, Optimizations: []string{"unknown"}}, "prune"},
+		{OptimizeFlags{Compress: true, Vectorize: true}, "compress vectorize balance prune compress prune"},
+		{OptimizeFlags{FullVectorize: true, Optimizations: []string{"zero", "lvec"}},
+			"vectorize prune compress prune vectorize prune compress prune lvec compress prune zero compress prune"},
+	} {
+		if passes := names(test.flags.Passes()); passes != test.expected {
+			t.Errorf("Passes of %+v are %q, expected %q", test.flags, passes, test.expected)
+		}
+	}
+}
+
+func TestOptimizeLevelWithCosts(t *testing.T) {
+	// A model where setting a cell costs more than a loop that counts it
+	// down from a few
+	m := il.DefaultCostModel
+	m.LoopIterations = 2
+	m.DataSet = 100
+
+	level, ok := LookupOptimizeLevel("all")
+	if !ok {
+		t.Fatal("no level all")
+	}
+	zero := func() *il.ILBlock {
+		b, err := optimizedIL([]byte("+[-]"), OptimizeLevel{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	costly := level.WithCosts(&m)
+	if costly.Name != level.Name || len(costly.Passes) != len(level.Passes) {
+		t.Fatalf("Level with costs is %s with %d passes, expected %s with %d",
+			costly.Name, len(costly.Passes), level.Name, len(level.Passes))
+	}
+	for i, pass := range costly.Passes {
+		if pass.Name != level.Passes[i].Name {
+			t.Errorf("Pass %d is %s, expected %s", i, pass.Name, level.Passes[i].Name)
+		}
+		if pass.Name != PassZero.Name {
+			continue
+		}
+		if count := pass.Run(zero()); count != 0 {
+			t.Errorf("Replaced %d loops with a costly data set", count)
+		}
+		if count := level.Passes[i].Run(zero()); count != 1 {
+			t.Errorf("Replaced %d loops with the default model, expected 1", count)
+		}
+	}
+}
+
+func TestLoadConformanceTests(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.b":       "+.",
+		"a.out":     "\x01",
+		"b.bf":      ",.",
+		"b.in":      "x",
+		"b.out":     "x",
+		"c.b":       "<",
+		"c.out":     "",
+		"c.err":     "",
+		"notest.b":  "+",
+		"other.out": "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests, err := LoadConformanceTests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ct := range tests {
+		names = append(names, filepath.Base(ct.Name))
+	}
+	if strings.Join(names, " ") != "a.b b.bf c.b" {
+		t.Fatalf("loaded tests %v, expected a.b b.bf c.b", names)
+	}
+	if string(tests[1].Input) != "x" || tests[0].Input != nil {
+		t.Errorf("inputs are %q and %q, expected \"x\" and none", tests[1].Input, tests[0].Input)
+	}
+	if tests[0].Fail || tests[1].Fail || !tests[2].Fail {
+		t.Error("only c.b should be expected to fail")
+	}
+	for _, ct := range tests {
+		if err := ct.Check(ct.Run()); err != nil {
+			t.Errorf("%s: %v", ct.Name, err)
+		}
+	}
+
+	if _, err := LoadConformanceTests(filepath.Join(dir, "notest.b")); err == nil {
+		t.Error("loading a program without a .out file did not fail")
+	}
+}
+
 func TestNoProgramIL(t *testing.T) {
 	input := bytes.NewBuffer([]byte{})
 	output := bytes.NewBuffer([]byte{})
@@ -245,7 +829,7 @@ func TestNoProgramIL(t *testing.T) {
 	prgm := NewIOBFProgram(0, 0, input, output)
 	il := prgm.CreateILTree()
 	il.Compress()
-	lang.ILBlockToGo(il, output, false)
+	lang.ILBlockToGo(il, output, lang.GenOptions{})
 }
 
 func BenchmarkParsingSource(b *testing.B) {
@@ -0,0 +1,487 @@
+package il
+
+// extent describes the data cells that a sequence of blocks can touch,
+// relative to the data pointer when the sequence starts.
+type extent struct {
+	min, max int64 // lowest and highest cell pointed at or modified
+	net      int64 // data pointer change once the sequence completes
+	bounded  bool  // false if a loop moves the data pointer by an unknown amount
+}
+
+func (e *extent) include(lo, hi int64) {
+	if lo < e.min {
+		e.min = lo
+	}
+	if hi > e.max {
+		e.max = hi
+	}
+}
+
+// blocksExtent computes the extent of running blocks in order.
+// Loops are only bounded when their body, including any nested loops,
+// leaves the data pointer where it started.
+func blocksExtent(blocks []*ILBlock) extent {
+	return blocksExtentMemo(blocks, nil)
+}
+
+// blocksExtentMemo is blocksExtent, which records the extent of every
+// loop body in memo, if it is not nil, to avoid computing it again.
+func blocksExtentMemo(blocks []*ILBlock, memo map[*ILBlock]extent) extent {
+	var e = extent{bounded: true}
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILList:
+			ie := blocksExtentMemo(b.inner, memo)
+			if !ie.bounded {
+				e.bounded = false
+				return e
+			}
+			e.include(e.net+ie.min, e.net+ie.max)
+			e.net += ie.net
+		case ILLoop:
+			ie, ok := memo[b]
+			if !ok {
+				ie = blocksExtentMemo(b.inner, memo)
+				if memo != nil {
+					memo[b] = ie
+				}
+			}
+			if !ie.bounded || ie.net != 0 {
+				e.bounded = false
+				return e
+			}
+			e.include(e.net+ie.min, e.net+ie.max)
+		case ILDataPtrAdd:
+			e.net += b.param
+			e.include(e.net, e.net)
+		case ILDataAddVector:
+			if len(b.vec) > 0 {
+				e.include(e.net, e.net+int64(len(b.vec))-1)
+			}
+		case ILDataAddLinVector:
+			if len(b.vec) > 0 {
+				e.include(e.net+b.param, e.net+b.param+int64(len(b.vec))-1)
+			}
+		}
+	}
+	return e
+}
+
+// tapeState is the abstract state of the tape used by the static analyses.
+// When the data pointer position is not known, ptr and the cell offsets
+// are relative to an unknown base.
+type tapeState struct {
+	ptr      int64
+	ptrKnown bool
+	cells    map[int64]byte // cells with a known value
+	zeroRest bool           // cells missing from cells and unknown are zero
+	unknown  map[int64]bool // cells with an unknown value, while zeroRest
+	dead     bool           // this point of the program is never reached
+}
+
+// maxUnknownCells limits how many cells are tracked as unknown before
+// the analysis gives up on knowing the rest of the tape is zero.
+const maxUnknownCells = 4096
+
+// newTapeState returns the state at the start of a program,
+// where every cell is zero.
+func newTapeState() *tapeState {
+	return &tapeState{
+		ptrKnown: true,
+		cells:    make(map[int64]byte),
+		zeroRest: true,
+		unknown:  make(map[int64]bool),
+	}
+}
+
+func (s *tapeState) clone() *tapeState {
+	n := *s
+	n.cells = make(map[int64]byte, len(s.cells))
+	for k, v := range s.cells {
+		n.cells[k] = v
+	}
+	n.unknown = make(map[int64]bool, len(s.unknown))
+	for k := range s.unknown {
+		n.unknown[k] = true
+	}
+	return &n
+}
+
+// get returns the value of the cell at offset from the data pointer.
+func (s *tapeState) get(offset int64) (byte, bool) {
+	k := s.ptr + offset
+	if v, ok := s.cells[k]; ok {
+		return v, true
+	}
+	return 0, s.zeroRest && !s.unknown[k]
+}
+
+func (s *tapeState) set(offset int64, value byte) {
+	k := s.ptr + offset
+	s.cells[k] = value
+	delete(s.unknown, k)
+}
+
+func (s *tapeState) add(offset int64, value byte) {
+	if v, ok := s.get(offset); ok {
+		s.set(offset, v+value)
+	} else {
+		s.forget(offset)
+	}
+}
+
+func (s *tapeState) forget(offset int64) {
+	s.forgetRange(offset, offset)
+}
+
+// forgetRange forgets cells from offset lo through hi.
+func (s *tapeState) forgetRange(lo, hi int64) {
+	for k := range s.cells {
+		if k >= s.ptr+lo && k <= s.ptr+hi {
+			delete(s.cells, k)
+		}
+	}
+	if !s.zeroRest {
+		return
+	}
+	if len(s.unknown)+int(hi-lo+1) > maxUnknownCells {
+		s.zeroRest = false
+		s.unknown = make(map[int64]bool)
+		return
+	}
+	for k := s.ptr + lo; k <= s.ptr+hi; k++ {
+		s.unknown[k] = true
+	}
+}
+
+// lose forgets the whole tape and the data pointer position.
+func (s *tapeState) lose() {
+	s.ptr = 0
+	s.ptrKnown = false
+	s.cells = make(map[int64]byte)
+	s.zeroRest = false
+	s.unknown = make(map[int64]bool)
+}
+
+// step applies a single non-loop block to the state.
+func (s *tapeState) step(b *ILBlock) {
+	switch b.typ {
+	case ILDataPtrAdd:
+		s.ptr += b.param
+	case ILDataAdd:
+		s.add(0, byte(b.param))
+	case ILDataSet:
+		s.set(0, byte(b.param))
+	case ILRead:
+		s.forget(0)
+	case ILWrite:
+	case ILDataAddVector:
+		for i, v := range b.vec {
+			if v != 0 {
+				s.add(int64(i), v)
+			}
+		}
+	case ILDataAddLinVector:
+		mult, ok := s.get(0)
+		if ok && mult == 0 {
+			return
+		}
+		for i, v := range b.vec {
+			if v == 0 {
+				continue
+			}
+			if ok {
+				s.add(b.param+int64(i), v*mult)
+			} else {
+				s.forget(b.param + int64(i))
+			}
+		}
+	}
+}
+
+// havocLoop forgets everything a loop with body blocks may change,
+// giving a state that holds at the start of every iteration and after
+// the loop finishes.
+func (s *tapeState) havocLoop(blocks []*ILBlock) {
+	e := blocksExtent(blocks)
+	if !e.bounded || e.net != 0 {
+		s.lose()
+		return
+	}
+	s.forgetRange(e.min, e.max)
+}
+
+// loopStep is the change a simple loop body makes to its own control cell
+// in one iteration. If set is true, the cell is set to value, otherwise
+// value is added to it.
+type loopStep struct {
+	set   bool
+	value byte
+}
+
+// controlStep determines how one iteration of a loop with body blocks
+// changes the control cell. It is only known for bodies that leave the
+// data pointer where it started, and whose nested loops stay away from
+// the control cell.
+func controlStep(blocks []*ILBlock) (loopStep, bool) {
+	var step loopStep
+	var off int64
+	ok := controlStepAt(blocks, &step, &off)
+	return step, ok && off == 0
+}
+
+// controlStepAt adds the changes blocks make to the control cell to step,
+// where off is the offset of the data pointer from the control cell.
+func controlStepAt(blocks []*ILBlock, step *loopStep, off *int64) bool {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILList:
+			if !controlStepAt(b.inner, step, off) {
+				return false
+			}
+		case ILLoop:
+			e := blocksExtent(b.inner)
+			if !e.bounded || e.net != 0 || (-*off >= e.min && -*off <= e.max) {
+				return false
+			}
+		case ILDataPtrAdd:
+			*off += b.param
+		case ILDataAdd:
+			if *off == 0 {
+				step.value += byte(b.param)
+			}
+		case ILDataSet:
+			if *off == 0 {
+				*step = loopStep{set: true, value: byte(b.param)}
+			}
+		case ILRead:
+			if *off == 0 {
+				return false
+			}
+		case ILWrite:
+		case ILDataAddVector:
+			if i := -*off; i >= 0 && i < int64(len(b.vec)) {
+				step.value += b.vec[i]
+			}
+		case ILDataAddLinVector:
+			if i := -*off - b.param; i >= 0 && i < int64(len(b.vec)) && b.vec[i] != 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// terminates reports whether a loop entered with control cell value v and
+// changing it by step each iteration ever reaches zero.
+func (step loopStep) terminates(v byte) bool {
+	if v == 0 {
+		return true
+	}
+	if step.set {
+		return step.value == 0
+	}
+	// v + k*d == 0 (mod 256) has a solution k when gcd(d, 256) divides v,
+	// which is when d has no more factors of two than v.
+	if step.value == 0 {
+		return false
+	}
+	return trailingZeros(step.value) <= trailingZeros(v)
+}
+
+// trips returns the number of iterations of a loop entered with control
+// cell value v and changing it by step each iteration, or false if it
+// never terminates.
+func (step loopStep) trips(v byte) (int, bool) {
+	if v == 0 {
+		return 0, true
+	}
+	if !step.terminates(v) {
+		return 0, false
+	}
+	if step.set {
+		return 1, true
+	}
+	n := 1
+	for v += step.value; v != 0; v += step.value {
+		n++
+	}
+	return n, true
+}
+
+func trailingZeros(v byte) int {
+	var n int
+	for v&1 == 0 && n < 8 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+// getAt returns the value of the cell k, which is relative to the same
+// base as ptr.
+func (s *tapeState) getAt(k int64) (byte, bool) {
+	return s.get(k - s.ptr)
+}
+
+// runLoops applies blocks, including loops, to the state. After a loop,
+// its control cell is zero, and the rest of the tape is what holds at the
+// start of every iteration, for loops nested up to depth deep. Deeper loops
+// only keep the cells that stay zero, with the zeroCells of each loop in
+// memo, which may be nil.
+func (s *tapeState) runLoops(blocks []*ILBlock, depth int, memo map[*ILBlock]map[int64]bool) {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILList:
+			s.runLoops(b.inner, depth, memo)
+		case ILLoop:
+			if v, ok := s.get(0); ok && v == 0 {
+				continue
+			}
+			if depth > 0 {
+				*s = *s.iterationState(b.inner, depth-1)
+			} else {
+				var keep []int64
+				for k := range zeroCells(b, memo) {
+					if v, ok := s.get(k); ok && v == 0 {
+						keep = append(keep, k)
+					}
+				}
+				s.havocLoop(b.inner)
+				for _, k := range keep {
+					s.set(k, 0)
+				}
+			}
+			s.set(0, 0)
+		default:
+			s.step(b)
+		}
+	}
+}
+
+// zeroCells returns the offsets of the cells that stay zero at the start
+// of every iteration of loop, and once it ends, if they are zero when it is
+// entered, like the control cells of its nested loops. It records the
+// result for loop and its nested loops in memo, if it is not nil.
+func zeroCells(loop *ILBlock, memo map[*ILBlock]map[int64]bool) map[int64]bool {
+	if zero, ok := memo[loop]; ok {
+		return zero
+	}
+	if memo == nil {
+		memo = make(map[*ILBlock]map[int64]bool)
+	}
+	e := blocksExtent(loop.inner)
+	if !e.bounded || e.net != 0 {
+		memo[loop] = nil
+		return nil
+	}
+
+	// Assume every cell is zero, and drop the cells that an iteration
+	// leaves nonzero, until the rest stay zero
+	zero := make(map[int64]bool)
+	for k := e.min; k <= e.max; k++ {
+		zero[k] = true
+	}
+	for changed := true; changed; {
+		s := &tapeState{
+			ptrKnown: true,
+			cells:    make(map[int64]byte),
+			unknown:  make(map[int64]bool),
+		}
+		for k := range zero {
+			s.cells[k] = 0
+		}
+		s.runLoops(loop.inner, 0, memo)
+		changed = false
+		for k := range zero {
+			if v, ok := s.get(k); !ok || v != 0 {
+				delete(zero, k)
+				changed = true
+			}
+		}
+	}
+	memo[loop] = zero
+	return zero
+}
+
+// meet keeps only what both s and o know about the tape, and reports if
+// s changed. They must have the same data pointer.
+func (s *tapeState) meet(o *tapeState) bool {
+	var changed bool
+	for k, v := range s.cells {
+		if ov, ok := o.getAt(k); !ok || ov != v {
+			s.forget(k - s.ptr)
+			changed = true
+		}
+	}
+	if !s.zeroRest {
+		return changed
+	}
+	if !o.zeroRest {
+		// Keep the zero cells that o knows about
+		for k, v := range o.cells {
+			if sv, ok := s.getAt(k); ok && sv == 0 && v == 0 {
+				s.cells[k] = 0
+			}
+		}
+		s.zeroRest = false
+		s.unknown = make(map[int64]bool)
+		return true
+	}
+	for k, v := range o.cells {
+		if _, ok := s.cells[k]; !ok && v != 0 && !s.unknown[k] {
+			s.forget(k - s.ptr)
+			changed = true
+		}
+	}
+	for k := range o.unknown {
+		if _, ok := s.getAt(k); ok {
+			s.forget(k - s.ptr)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// maxIterationMeets limits how many iterations iterationState simulates
+// before it gives up and havocs the loop.
+const maxIterationMeets = 32
+
+// maxIterationDepth limits how deep the nested loops are that
+// iterationState follows, since each level simulates a few iterations of
+// the loops in it.
+const maxIterationDepth = 3
+
+// iterationState returns a state that holds at the start of every
+// iteration of a loop with body blocks, which is entered in state s, and
+// so also once the loop ends, apart from the control cell. Unlike
+// havocLoop, it keeps the cells that each iteration leaves as it found
+// them, like the control cells of nested loops, which are zero again.
+// Nested loops are followed depth deep.
+func (s *tapeState) iterationState(blocks []*ILBlock, depth int) *tapeState {
+	it := s.clone()
+	if e := blocksExtent(blocks); !e.bounded || e.net != 0 {
+		it.havocLoop(blocks)
+		return it
+	}
+	for i := 0; i < maxIterationMeets; i++ {
+		end := it.clone()
+		end.runLoops(blocks, depth, nil)
+		if !it.meet(end) {
+			return it
+		}
+	}
+	it.havocLoop(blocks)
+	return it
+}
@@ -0,0 +1,129 @@
+package il
+
+// Bounds is the result of the tape bounds analysis of a program.
+// It tells code generators which blocks can skip data pointer and
+// tape growth checks.
+type Bounds struct {
+	// Proven is true when the data pointer position is known throughout
+	// the whole program, so it never leaves the first Size cells.
+	Proven bool
+	// Size is the number of cells the proven part of the program touches.
+	Size int64
+
+	inBounds map[*ILBlock]bool
+	loops    map[*ILBlock]extent
+}
+
+// loopExtent returns the extent of one iteration of the loop b.
+func (bd *Bounds) loopExtent(b *ILBlock) extent {
+	if e, ok := bd.loops[b]; ok {
+		return e
+	}
+	e := blocksExtentMemo(b.inner, bd.loops)
+	bd.loops[b] = e
+	return e
+}
+
+func (bd *Bounds) touch(hi int64) {
+	if hi+1 > bd.Size {
+		bd.Size = hi + 1
+	}
+}
+
+// walk follows the absolute data pointer position ptr through blocks,
+// while known is true. It returns the position after the blocks.
+func (bd *Bounds) walk(blocks []*ILBlock, ptr int64, known bool) (int64, bool) {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		if !known {
+			bd.Proven = false
+		}
+
+		switch b.typ {
+		case ILList:
+			ptr, known = bd.walk(b.inner, ptr, known)
+		case ILLoop:
+			e := bd.loopExtent(b)
+			if known && e.bounded && e.net == 0 && ptr+e.min >= 0 {
+				bd.inBounds[b] = true
+				bd.touch(ptr + e.max)
+				continue
+			}
+			// Visit the body only to find the extents of nested loops
+			bd.walk(b.inner, 0, false)
+			known = false
+		case ILDataPtrAdd:
+			if known && ptr+b.param >= 0 {
+				ptr += b.param
+				bd.inBounds[b] = true
+				bd.touch(ptr)
+			} else {
+				known = false
+			}
+		case ILDataAddVector:
+			if known {
+				bd.inBounds[b] = true
+				bd.touch(ptr + int64(len(b.vec)) - 1)
+			}
+		case ILDataAddLinVector:
+			if known && ptr+b.param >= 0 {
+				bd.inBounds[b] = true
+				bd.touch(ptr + b.param + int64(len(b.vec)) - 1)
+			} else {
+				known = false
+			}
+		}
+	}
+	if !known {
+		bd.Proven = false
+	}
+	return ptr, known
+}
+
+// AnalyzeBounds runs a range analysis of the data pointer over the
+// program b, starting at cell 0.
+//
+// The absolute data pointer position is followed until a loop that does
+// not return the data pointer to where it started, like a scan loop [>].
+// All blocks before that point are proven to stay within the first Size
+// cells. Loops after that point that do return the data pointer still
+// have a known extent relative to their start, see LoopExtent.
+func (b *ILBlock) AnalyzeBounds() *Bounds {
+	bd := &Bounds{
+		Proven:   true,
+		inBounds: make(map[*ILBlock]bool),
+		loops:    make(map[*ILBlock]extent),
+	}
+	bd.walk([]*ILBlock{b}, 0, true)
+	if bd.Size < 1 {
+		bd.Size = 1
+	}
+	return bd
+}
+
+// InBounds reports whether the block b, including everything inside it,
+// is proven to keep the data pointer and cells it touches within the
+// first Size cells.
+func (bd *Bounds) InBounds(b *ILBlock) bool {
+	if bd == nil {
+		return false
+	}
+	return bd.inBounds[b]
+}
+
+// LoopExtent returns the lowest and highest cell, relative to the data
+// pointer at the start of an iteration, that the loop b can touch.
+// The extent is only known for loops that return the data pointer to
+// where it started.
+func (bd *Bounds) LoopExtent(b *ILBlock) (lo, hi int64, ok bool) {
+	if bd == nil || b.typ != ILLoop {
+		return 0, 0, false
+	}
+	e := bd.loopExtent(b)
+	if !e.bounded || e.net != 0 {
+		return 0, 0, false
+	}
+	return e.min, e.max, true
+}
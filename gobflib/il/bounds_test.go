@@ -0,0 +1,43 @@
+package il
+
+import "testing"
+
+func TestAnalyzeBounds(t *testing.T) {
+	var tests = []struct {
+		cmds   string
+		proven bool
+		size   int64
+	}{
+		{"", true, 1},
+		{">>+<", true, 3},
+		{"+[->>+<<]>", true, 3},
+		{"+[>+]", false, 1},
+		{"+>+<[>]>>", false, 2},
+		{"<+", false, 1},
+	}
+
+	for _, test := range tests {
+		bd := parseBF(test.cmds).AnalyzeBounds()
+		if bd.Proven != test.proven || bd.Size != test.size {
+			t.Errorf("%q: expected proven=%v size=%d, got proven=%v size=%d",
+				test.cmds, test.proven, test.size, bd.Proven, bd.Size)
+		}
+	}
+}
+
+func TestLoopExtent(t *testing.T) {
+	il := parseBF("+[>]<[-<<+>[->+<]>]")
+	scan, loop := il.inner[1], il.inner[3]
+	bd := il.AnalyzeBounds()
+
+	if _, _, ok := bd.LoopExtent(scan); ok {
+		t.Error("Reported an extent for a scan loop")
+	}
+	if bd.InBounds(loop) {
+		t.Error("Loop after a scan loop can not be proven in bounds")
+	}
+	lo, hi, ok := bd.LoopExtent(loop)
+	if !ok || lo != -2 || hi != 0 {
+		t.Errorf("Expected extent -2 to 0, got %d to %d (ok=%v)", lo, hi, ok)
+	}
+}
@@ -0,0 +1,357 @@
+package il
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CostModel holds the cost of running each type of ILBlock with one
+// backend, which the optimizations weigh to decide if a rewrite pays off.
+// Only the ratios between the costs matter.
+// The default model counts simple operations, and gobf calibrate measures
+// models for the local machine in nanoseconds.
+type CostModel struct {
+	// Backend is the backend the costs were measured with, or empty
+	Backend string `json:"backend,omitempty"`
+
+	// Loop is the cost of testing the condition of a loop
+	Loop float64 `json:"loop"`
+	// LoopIterations is the number of times a loop is assumed to run,
+	// since it is only known when the program runs
+	LoopIterations float64 `json:"loop_iterations"`
+
+	DataPtrAdd float64 `json:"dataptradd"`
+	DataAdd    float64 `json:"dataadd"`
+	DataSet    float64 `json:"dataset"`
+
+	// DataAddVector is the fixed cost of adding a vector, and
+	// DataAddVectorElem is the cost of each of its elements
+	DataAddVector     float64 `json:"dataaddvector"`
+	DataAddVectorElem float64 `json:"dataaddvector_elem"`
+	// DataAddLinVector and DataAddLinVectorElem are the same for linear
+	// vectors, when the current cell is not zero
+	DataAddLinVector     float64 `json:"dataaddlinvector"`
+	DataAddLinVectorElem float64 `json:"dataaddlinvector_elem"`
+}
+
+// DefaultCostModel is used when no model was calibrated for the backend.
+// Its vector costs keep the decisions of the constants it replaced.
+var DefaultCostModel = CostModel{
+	Loop:           1,
+	LoopIterations: 16,
+	// add, check <0, check readjust
+	DataPtrAdd: 2,
+	DataAdd:    1,
+	DataSet:    1,
+	// check readjust, slice, bound check
+	DataAddVector:     3,
+	DataAddVectorElem: 1,
+	// test the current cell, check readjust, slice
+	DataAddLinVector:     3,
+	DataAddLinVectorElem: 2,
+}
+
+// BlockCost returns the estimated cost of running b once with model m.
+// Input and output cost nothing, since no optimization changes them.
+func (m *CostModel) BlockCost(b *ILBlock) float64 {
+	switch b.typ {
+	case ILList:
+		return m.blocksCost(b.inner)
+	case ILLoop:
+		return (m.LoopIterations+1)*m.Loop + m.LoopIterations*m.blocksCost(b.inner)
+	case ILDataPtrAdd:
+		return m.DataPtrAdd
+	case ILDataAdd:
+		return m.DataAdd
+	case ILDataSet:
+		return m.DataSet
+	case ILDataAddVector:
+		return m.DataAddVector + float64(len(b.vec))*m.DataAddVectorElem
+	case ILDataAddLinVector:
+		return m.DataAddLinVector + float64(len(b.vec))*m.DataAddLinVectorElem
+	}
+	return 0
+}
+
+func (m *CostModel) blocksCost(blocks []*ILBlock) float64 {
+	var cost float64
+	for _, b := range blocks {
+		if b != nil {
+			cost += m.BlockCost(b)
+		}
+	}
+	return cost
+}
+
+// vectorCost returns the cost of the vector b, and of the independent
+// data adds and data pointer moves it replaces.
+func (b *ILBlock) vectorCost(m *CostModel) (vcost, icost float64) {
+	if b.typ != ILDataAddVector && b.typ != ILDataAddLinVector {
+		return -1, -1
+	}
+	vcost = m.BlockCost(b)
+	for _, v := range b.vec {
+		if v != 0 {
+			icost += m.DataAdd + m.DataPtrAdd
+		}
+	}
+	return
+}
+
+// CostProfile holds the cost models of several backends, as written by
+// gobf calibrate.
+type CostProfile struct {
+	Models []CostModel `json:"models"`
+}
+
+// DefaultCostProfile returns the path of the cost profile named by the
+// GOBFCOSTS environment variable, or costs.json in gobf under the user's
+// config directory.
+func DefaultCostProfile() (string, error) {
+	if path := os.Getenv("GOBFCOSTS"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gobf", "costs.json"), nil
+}
+
+// ReadCostProfile reads a profile written by WriteJSON.
+func ReadCostProfile(in io.Reader) (*CostProfile, error) {
+	p := new(CostProfile)
+	if err := json.NewDecoder(in).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteJSON writes the profile as indented JSON.
+func (p *CostProfile) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// Lookup returns the model of backend, or the default model and false if
+// the profile has none.
+func (p *CostProfile) Lookup(backend string) (*CostModel, bool) {
+	for i := range p.Models {
+		if p.Models[i].Backend == backend {
+			return &p.Models[i], true
+		}
+	}
+	return &DefaultCostModel, false
+}
+
+// Set adds m to the profile, replacing the model of the same backend.
+func (p *CostProfile) Set(m CostModel) {
+	for i := range p.Models {
+		if p.Models[i].Backend == m.Backend {
+			p.Models[i] = m
+			return
+		}
+	}
+	p.Models = append(p.Models, m)
+}
+
+const (
+	// calibrateCopies is the number of copies of the measured block in
+	// the body of a microbenchmark
+	calibrateCopies = 16
+	// calibrateRuns is the number of runs of a microbenchmark, the fastest
+	// of which is kept
+	calibrateRuns = 3
+	// calibrateCell is the cell the measured blocks work on, after the
+	// three loop counters
+	calibrateCell = 3
+	// calibrateGuard is the offset from calibrateCell of the cell that
+	// guards every copy of the measured block, past the cells of vectors
+	calibrateGuard = 16
+)
+
+// calibrateProgram returns a microbenchmark, which runs prefix and then
+// body outer*255*255 times in three nested loops, with the data pointer at
+// calibrateCell. body must leave the data pointer there. The program writes
+// calibrateCell at the end, so that compilers can't drop the loops.
+//
+// The guard cell is read from the input first, which must be empty, so it
+// stays zero but compilers can't know it.
+func calibrateProgram(outer int64, prefix, body []*ILBlock) *ILBlock {
+	counter := func(count int64, inner []*ILBlock) []*ILBlock {
+		loop := NewILBlock(ILLoop)
+		loop.Append(&ILBlock{typ: ILDataPtrAdd, param: 1})
+		loop.Append(inner...)
+		loop.Append(&ILBlock{typ: ILDataPtrAdd, param: -1}, &ILBlock{typ: ILDataAdd, param: -1})
+		return []*ILBlock{&ILBlock{typ: ILDataSet, param: count}, loop}
+	}
+
+	b := NewILBlock(ILList)
+	b.Append(&ILBlock{typ: ILDataPtrAdd, param: calibrateCell + calibrateGuard})
+	b.Append(&ILBlock{typ: ILRead, param: 1})
+	b.Append(&ILBlock{typ: ILDataPtrAdd, param: -calibrateGuard})
+	b.Append(prefix...)
+	b.Append(&ILBlock{typ: ILDataPtrAdd, param: -calibrateCell})
+	b.Append(counter(outer, counter(255, counter(255, body)))...)
+	b.Append(&ILBlock{typ: ILDataPtrAdd, param: calibrateCell}, &ILBlock{typ: ILWrite, param: 1})
+	return b
+}
+
+// copies returns calibrateCopies blocks made by block, each followed by a
+// loop on the guard cell. The loop is never entered, but it may write the
+// tape, so compilers can't fold the copies together.
+// A nil block returns only the guards.
+func copies(block func(i int) []*ILBlock) []*ILBlock {
+	var blocks []*ILBlock
+	var off int64
+	for i := 0; i < calibrateCopies; i++ {
+		if block != nil {
+			b := block(i)
+			off += blocksExtent(b).net
+			blocks = append(blocks, b...)
+		}
+		guard := NewILBlock(ILLoop)
+		guard.Append(&ILBlock{typ: ILWrite, param: 1}, &ILBlock{typ: ILDataSet, param: 0})
+		blocks = append(blocks,
+			&ILBlock{typ: ILDataPtrAdd, param: calibrateGuard - off},
+			guard,
+			&ILBlock{typ: ILDataPtrAdd, param: off - calibrateGuard})
+	}
+	return blocks
+}
+
+// ones returns a vector of n ones.
+func ones(n int) []byte {
+	vec := make([]byte, n)
+	for i := range vec {
+		vec[i] = 1
+	}
+	return vec
+}
+
+// Calibrate measures the cost model of backend with microbenchmarks. run
+// runs an IL program with the backend, and returns how long it took.
+//
+// Each microbenchmark repeats guarded copies of one block in a loop, which
+// is scaled up until the loop and guards alone take at least minTime. The
+// cost of the block is its share of the extra time over the loop alone, in
+// nanoseconds, and no less than calibrateMinCost. LoopIterations can't be
+// measured, and is kept from the default model.
+func Calibrate(backend string, run func(b *ILBlock) (time.Duration, error), minTime time.Duration) (*CostModel, error) {
+	fastest := func(b *ILBlock) (time.Duration, error) {
+		var min time.Duration
+		for i := 0; i < calibrateRuns; i++ {
+			t, err := run(b)
+			if err != nil {
+				return 0, err
+			}
+			if i == 0 || t < min {
+				min = t
+			}
+		}
+		return min, nil
+	}
+
+	// Scale up the loop, within the 255 iterations of the outer counter
+	guards := copies(nil)
+	outer := int64(1)
+	base, err := fastest(calibrateProgram(outer, nil, guards))
+	for ; err == nil && base < minTime && outer < 255; base, err = fastest(calibrateProgram(outer, nil, guards)) {
+		outer *= 2
+		if outer > 255 {
+			outer = 255
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := float64(outer * 255 * 255 * calibrateCopies)
+	cost := func(prefix, body []*ILBlock) (float64, error) {
+		t, err := fastest(calibrateProgram(outer, prefix, body))
+		if err != nil {
+			return 0, err
+		}
+		return floorCost(float64(t-base) / iterations), nil
+	}
+
+	m := DefaultCostModel
+	m.Backend = backend
+	mult := []*ILBlock{&ILBlock{typ: ILDataSet, param: 1}}
+	var ptradd, vec1, vec9, lin1, lin9 float64
+	for _, bench := range []struct {
+		cost   *float64
+		prefix []*ILBlock
+		body   []*ILBlock
+	}{
+		{&m.Loop, nil, copies(func(i int) []*ILBlock {
+			// The cell is zero, so each loop is one test
+			loop := NewILBlock(ILLoop)
+			loop.Append(&ILBlock{typ: ILDataAdd, param: 1})
+			return []*ILBlock{loop}
+		})},
+		{&ptradd, nil, copies(func(i int) []*ILBlock {
+			// Compilers merge the move with the moves of the guards, so
+			// it is measured between two adds
+			return []*ILBlock{
+				&ILBlock{typ: ILDataAdd, param: 1},
+				&ILBlock{typ: ILDataPtrAdd, param: int64(1 - 2*(i%2))},
+				&ILBlock{typ: ILDataAdd, param: 1},
+			}
+		})},
+		{&m.DataAdd, nil, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataAdd, param: int64(i + 1)}}
+		})},
+		{&m.DataSet, nil, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataSet, param: int64(i + 1)}}
+		})},
+		{&vec1, nil, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataAddVector, vec: ones(1)}}
+		})},
+		{&vec9, nil, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataAddVector, vec: ones(9)}}
+		})},
+		{&lin1, mult, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataAddLinVector, param: 1, vec: ones(1)}}
+		})},
+		{&lin9, mult, copies(func(i int) []*ILBlock {
+			return []*ILBlock{&ILBlock{typ: ILDataAddLinVector, param: 1, vec: ones(9)}}
+		})},
+	} {
+		if *bench.cost, err = cost(bench.prefix, bench.body); err != nil {
+			return nil, err
+		}
+	}
+
+	m.DataPtrAdd = floorCost(ptradd - 2*m.DataAdd)
+	m.DataAddVector, m.DataAddVectorElem = splitCost(vec1, vec9)
+	m.DataAddLinVector, m.DataAddLinVectorElem = splitCost(lin1, lin9)
+	return &m, nil
+}
+
+// calibrateMinCost is the least cost in nanoseconds that Calibrate
+// derives, which is about the noise of the measurements. A cost of zero
+// would make the optimizations treat blocks as free.
+const calibrateMinCost = 0.01
+
+// floorCost returns cost, or calibrateMinCost if it is less.
+func floorCost(cost float64) float64 {
+	if cost < calibrateMinCost {
+		return calibrateMinCost
+	}
+	return cost
+}
+
+// splitCost splits the costs of vectors of 1 and 9 elements into a fixed
+// cost and a cost per element, neither less than calibrateMinCost.
+func splitCost(cost1, cost9 float64) (fixed, elem float64) {
+	elem = floorCost((cost9 - cost1) / 8)
+	return floorCost(cost1 - elem), elem
+}
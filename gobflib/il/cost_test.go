@@ -0,0 +1,186 @@
+package il
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBlockCost(t *testing.T) {
+	m := CostModel{
+		Loop:                 1,
+		LoopIterations:       4,
+		DataPtrAdd:           2,
+		DataAdd:              3,
+		DataSet:              5,
+		DataAddVector:        7,
+		DataAddVectorElem:    11,
+		DataAddLinVector:     13,
+		DataAddLinVectorElem: 17,
+	}
+	b := NewILBlock(ILList)
+	b.inner = []*ILBlock{
+		{typ: ILRead, param: 1},
+		{typ: ILDataSet, param: 2},
+		{typ: ILLoop, inner: []*ILBlock{
+			{typ: ILDataPtrAdd, param: 1},
+			{typ: ILDataAddVector, vec: []byte{1, 2}},
+			{typ: ILDataAdd, param: -1},
+		}},
+		{typ: ILDataAddLinVector, param: 1, vec: []byte{1, 2, 3}},
+		{typ: ILWrite, param: 1},
+	}
+	expected := 5.0 + (5*1 + 4*(2+7+2*11+3)) + (13 + 3*17)
+	if cost := m.BlockCost(b); cost != expected {
+		t.Errorf("BlockCost is %v, expected %v", cost, expected)
+	}
+}
+
+func TestPatternReplaceCost(t *testing.T) {
+	// A model where setting a cell costs more than a loop that counts it
+	// down from a few
+	m := DefaultCostModel
+	m.LoopIterations = 2
+	m.DataSet = 100
+
+	zero := parseBF("+[-]")
+	if count := zero.PatternReplaceWith(&m, PatternReplaceZero); count != 0 {
+		t.Errorf("Replaced %d loops with a costly data set", count)
+	}
+	if count := zero.PatternReplace(PatternReplaceZero); count != 1 {
+		t.Errorf("Replaced %d loops with the default model, expected 1", count)
+	}
+}
+
+func TestCostProfile(t *testing.T) {
+	var p CostProfile
+	if m, ok := p.Lookup("c"); ok || m != &DefaultCostModel {
+		t.Errorf("Empty profile has a model for c")
+	}
+	c := DefaultCostModel
+	c.Backend, c.DataAdd = "c", 0.25
+	jit := DefaultCostModel
+	jit.Backend = "jit"
+	p.Set(c)
+	p.Set(jit)
+	c.DataAdd = 0.5
+	p.Set(c)
+	if len(p.Models) != 2 {
+		t.Fatalf("Profile has %d models, expected 2", len(p.Models))
+	}
+	if m, ok := p.Lookup("c"); !ok || *m != c {
+		t.Errorf("Model for c is %+v, expected %+v", m, c)
+	}
+
+	var out bytes.Buffer
+	if err := p.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCostProfile(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*read, p) {
+		t.Errorf("Read %+v, expected %+v", *read, p)
+	}
+}
+
+// costRun returns a function for Calibrate that interprets b, and
+// returns the cost of the blocks it ran in model m as nanoseconds.
+func costRun(m CostModel) func(b *ILBlock) (time.Duration, error) {
+	return func(b *ILBlock) (time.Duration, error) {
+		var data [32]byte
+		var ptr int64
+		var cost float64
+		var run func(b *ILBlock)
+		run = func(b *ILBlock) {
+			switch b.typ {
+			case ILList:
+				for _, ib := range b.inner {
+					run(ib)
+				}
+			case ILLoop:
+				for cost += m.Loop; data[ptr] != 0; cost += m.Loop {
+					for _, ib := range b.inner {
+						run(ib)
+					}
+				}
+			case ILDataPtrAdd:
+				ptr += b.param
+				cost += m.DataPtrAdd
+			case ILDataAdd:
+				data[ptr] += byte(b.param)
+				cost += m.DataAdd
+			case ILDataSet:
+				data[ptr] = byte(b.param)
+				cost += m.DataSet
+			case ILDataAddVector:
+				for i, v := range b.vec {
+					data[ptr+int64(i)] += v
+				}
+				cost += m.BlockCost(b)
+			case ILDataAddLinVector:
+				for i, v := range b.vec {
+					data[ptr+b.param+int64(i)] += data[ptr] * v
+				}
+				cost += m.BlockCost(b)
+			}
+		}
+		run(b)
+		return time.Duration(cost), nil
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	measured := CostModel{
+		Backend:              "fake",
+		Loop:                 1.5,
+		LoopIterations:       DefaultCostModel.LoopIterations,
+		DataPtrAdd:           1,
+		DataAdd:              0.5,
+		DataSet:              0.75,
+		DataAddVector:        4,
+		DataAddVectorElem:    0.25,
+		DataAddLinVector:     6,
+		DataAddLinVectorElem: 1.25,
+	}
+	// A backend that optimizes sets and vector elements away
+	free := measured
+	free.DataSet, free.DataAddVectorElem = 0, 0
+	floored := free
+	floored.DataSet, floored.DataAddVectorElem = calibrateMinCost, calibrateMinCost
+	floored.DataAddVector -= calibrateMinCost
+
+	for _, test := range []struct {
+		name     string
+		costs    CostModel
+		expected CostModel
+	}{
+		{"measured", measured, measured},
+		{"floored", free, floored},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Calibrate("fake", costRun(test.costs), time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := reflect.ValueOf(*m)
+			want := reflect.ValueOf(test.expected)
+			for i := 0; i < got.NumField(); i++ {
+				name := got.Type().Field(i).Name
+				if got.Field(i).Kind() != reflect.Float64 {
+					if got.Field(i).Interface() != want.Field(i).Interface() {
+						t.Errorf("%s is %v, expected %v", name, got.Field(i), want.Field(i))
+					}
+					continue
+				}
+				if g, w := got.Field(i).Float(), want.Field(i).Float(); math.Abs(g-w) > 1e-3 {
+					t.Errorf("%s is %v, expected %v", name, g, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package il
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// walk calls fn for b and every block below it, in program order.
+func (b *ILBlock) walk(fn func(*ILBlock)) {
+	if b == nil {
+		return
+	}
+	fn(b)
+	for _, ib := range b.inner {
+		ib.walk(fn)
+	}
+}
+
+type snapshotEntry struct {
+	typ    ILBlockType
+	param  int64
+	vec    string
+	ninner int
+}
+
+// Snapshot records the state of every block in an ILBlock tree, so that
+// the blocks touched by a later optimization pass can be found.
+type Snapshot map[*ILBlock]snapshotEntry
+
+func (b *ILBlock) snapshotEntry() snapshotEntry {
+	return snapshotEntry{
+		typ:    b.typ,
+		param:  b.param,
+		vec:    string(b.vec),
+		ninner: len(b.inner),
+	}
+}
+
+// Snapshot takes a Snapshot of the tree b.
+func (b *ILBlock) Snapshot() Snapshot {
+	s := make(Snapshot)
+	b.walk(func(ib *ILBlock) {
+		s[ib] = ib.snapshotEntry()
+	})
+	return s
+}
+
+// Changed returns the set of blocks in tree b that are either new or
+// have been modified since the Snapshot s was taken.
+func (s Snapshot) Changed(b *ILBlock) map[*ILBlock]bool {
+	changed := make(map[*ILBlock]bool)
+	b.walk(func(ib *ILBlock) {
+		if e, ok := s[ib]; !ok || e != ib.snapshotEntry() {
+			changed[ib] = true
+		}
+	})
+	return changed
+}
+
+// dotLabel returns the Graphviz label text for a single block.
+func (b *ILBlock) dotLabel() string {
+	var lines = []string{b.typ.String()}
+	switch b.typ {
+	case ILList, ILLoop:
+		lines = append(lines, fmt.Sprintf("inner=%d", len(b.inner)))
+	case ILDataAdd, ILDataPtrAdd, ILDataSet, ILRead, ILWrite:
+		lines = append(lines, fmt.Sprintf("param=%v", b.param))
+	case ILDataAddVector:
+		vc, oc := b.vectorCost(&DefaultCostModel)
+		lines = append(lines, fmt.Sprintf("vec=%v", b.vec))
+		lines = append(lines, fmt.Sprintf("vcost=%g ocost=%g", vc, oc))
+	case ILDataAddLinVector:
+		vc, oc := b.vectorCost(&DefaultCostModel)
+		lines = append(lines, fmt.Sprintf("off=%v", b.param))
+		lines = append(lines, fmt.Sprintf("vec=%v", b.vec))
+		lines = append(lines, fmt.Sprintf("vcost=%g ocost=%g", vc, oc))
+	}
+	return strings.Join(lines, `\n`)
+}
+
+type dotWriter struct {
+	out       io.Writer
+	highlight map[*ILBlock]bool
+	nextid    int
+}
+
+func (d *dotWriter) node(b *ILBlock, indent int) string {
+	id := fmt.Sprintf("n%d", d.nextid)
+	d.nextid++
+
+	var attrs = fmt.Sprintf("label=\"%s\"", b.dotLabel())
+	switch b.typ {
+	case ILLoop:
+		attrs += ", shape=diamond"
+	case ILList:
+		attrs += ", shape=ellipse"
+	}
+	if d.highlight[b] {
+		attrs += ", style=filled, fillcolor=orange"
+	}
+	fmt.Fprintf(d.out, "%*s%s [%s];\n", indent, "", id, attrs)
+	return id
+}
+
+// block writes b and everything below it. It returns the node ids
+// that control flow enters and leaves b through.
+func (d *dotWriter) block(b *ILBlock, indent int) (entry, exit string) {
+	const indentWidth = 4
+	switch b.typ {
+	case ILLoop:
+		fmt.Fprintf(d.out, "%*ssubgraph cluster_%d {\n", indent, "", d.nextid)
+		fmt.Fprintf(d.out, "%*sstyle=rounded;\n", indent+indentWidth, "")
+		id := d.node(b, indent+indentWidth)
+		last := d.chain(id, b.inner, indent+indentWidth)
+		if last != id {
+			fmt.Fprintf(d.out, "%*s%s -> %s [style=dashed];\n", indent+indentWidth, "", last, id)
+		}
+		fmt.Fprintf(d.out, "%*s}\n", indent, "")
+		return id, id
+	case ILList:
+		fmt.Fprintf(d.out, "%*ssubgraph cluster_%d {\n", indent, "", d.nextid)
+		fmt.Fprintf(d.out, "%*sstyle=dotted;\n", indent+indentWidth, "")
+		id := d.node(b, indent+indentWidth)
+		last := d.chain(id, b.inner, indent+indentWidth)
+		fmt.Fprintf(d.out, "%*s}\n", indent, "")
+		return id, last
+	default:
+		id := d.node(b, indent)
+		return id, id
+	}
+}
+
+// chain writes blocks in program order, connecting each one to the last
+// and starting from the node id prev. The final exit node id is returned.
+func (d *dotWriter) chain(prev string, blocks []*ILBlock, indent int) string {
+	for _, ib := range blocks {
+		if ib == nil {
+			continue
+		}
+		entry, exit := d.block(ib, indent)
+		fmt.Fprintf(d.out, "%*s%s -> %s;\n", indent, "", prev, entry)
+		prev = exit
+	}
+	return prev
+}
+
+// DumpDot writes the tree b as a Graphviz DOT digraph.
+// Blocks are connected in program order and loops are drawn as nested
+// clusters with a dashed edge back to the loop head.
+// Blocks present in highlight are filled, which can be used to show the
+// output of Snapshot.Changed.
+func (b *ILBlock) DumpDot(out io.Writer, highlight map[*ILBlock]bool) {
+	const indentWidth = 4
+	d := &dotWriter{
+		out:       out,
+		highlight: highlight,
+	}
+	fmt.Fprintf(out, "digraph il {\n")
+	fmt.Fprintf(out, "%*snode [shape=box, fontname=\"monospace\"];\n", indentWidth, "")
+	if b != nil {
+		id := d.node(b, indentWidth)
+		d.chain(id, b.inner, indentWidth)
+	}
+	fmt.Fprintf(out, "}\n")
+}
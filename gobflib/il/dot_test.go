@@ -0,0 +1,57 @@
+package il
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotChanged(t *testing.T) {
+	il := NewILBlock(ILList)
+	add1 := NewILBlock(ILDataAdd)
+	add1.param = 1
+	add2 := NewILBlock(ILDataAdd)
+	add2.param = 2
+	loop := NewILBlock(ILLoop)
+	ptr := NewILBlock(ILDataPtrAdd)
+	ptr.param = 1
+	loop.Append(ptr)
+	il.Append(add1, add2, loop)
+
+	snap := il.Snapshot()
+	il.Compress()
+	changed := snap.Changed(il)
+
+	if !changed[add1] {
+		t.Error("Failed to detect the compressed data add")
+	}
+	if changed[loop] || changed[ptr] {
+		t.Error("Reported an untouched block as changed")
+	}
+}
+
+func TestDumpDot(t *testing.T) {
+	il := NewILBlock(ILList)
+	loop := NewILBlock(ILLoop)
+	add := NewILBlock(ILDataAdd)
+	add.param = -1
+	loop.Append(add)
+	il.Append(loop)
+
+	var out bytes.Buffer
+	il.DumpDot(&out, map[*ILBlock]bool{add: true})
+	dot := out.String()
+
+	if !strings.HasPrefix(dot, "digraph il {") {
+		t.Error("Output is not a digraph")
+	}
+	if strings.Count(dot, "subgraph cluster_") != 1 {
+		t.Error("Loop was not drawn as a cluster")
+	}
+	if strings.Count(dot, "fillcolor") != 1 {
+		t.Error("Highlighted block was not filled")
+	}
+	if strings.Count(dot, "{") != strings.Count(dot, "}") {
+		t.Error("Unbalanced braces in output")
+	}
+}
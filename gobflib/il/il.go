@@ -23,12 +23,31 @@ const (
 	ILDataAddLinVector // param is offset of vector
 )
 
+// Pos is the source position a block was generated from.
+// Lines and columns start at 1, the zero Pos is unknown.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
 // ILBlock represents an Intermediate Language Block of instruction(s)
 type ILBlock struct {
 	typ   ILBlockType
 	param int64
 	inner []*ILBlock
 	vec   []byte
+	pos   Pos
 }
 
 func NewILBlock(typ ILBlockType) *ILBlock {
@@ -58,6 +77,19 @@ func (b *ILBlock) SetParam(param int64) {
 	b.param = param
 }
 
+func (b *ILBlock) SetVector(vec []byte) {
+	b.vec = make([]byte, len(vec))
+	copy(b.vec, vec)
+}
+
+func (b *ILBlock) GetPos() Pos {
+	return b.pos
+}
+
+func (b *ILBlock) SetPos(pos Pos) {
+	b.pos = pos
+}
+
 func (b *ILBlock) ResetInner(size int) {
 	if size < 0 {
 		size = len(b.inner)
@@ -91,13 +123,13 @@ func (b *ILBlock) Dump(out io.Writer, indent int) {
 		fmt.Fprintf(out, " param=%v |", b.param)
 	case ILDataAddVector:
 		fmt.Fprintf(out, " vec=%v |", b.vec)
-		vc, oc := b.vectorCost()
-		fmt.Fprintf(out, " vcost=%d ocost=%d", vc, oc)
+		vc, oc := b.vectorCost(&DefaultCostModel)
+		fmt.Fprintf(out, " vcost=%g ocost=%g", vc, oc)
 	case ILDataAddLinVector:
 		fmt.Fprintf(out, " off=%v |", b.param)
 		fmt.Fprintf(out, " vec=%v |", b.vec)
-		vc, oc := b.vectorCost()
-		fmt.Fprintf(out, " vcost=%d ocost=%d", vc, oc)
+		vc, oc := b.vectorCost(&DefaultCostModel)
+		fmt.Fprintf(out, " vcost=%g ocost=%g", vc, oc)
 	}
 	fmt.Fprintf(out, "\n")
 	for _, ib := range b.inner {
@@ -156,14 +188,19 @@ func (b *ILBlock) Compress() int {
 	/* This step expands ILLists elements into the parent ILBlock */
 	oldinner = b.GetInner()
 	b.ResetInner(-1)
-	for _, ib := range oldinner {
-		if ib.typ == ILList {
-			b.Append(ib.inner...)
-			count += int64(len(ib.inner))
-		} else {
-			b.Append(ib)
+	var flatten func(blocks []*ILBlock)
+	flatten = func(blocks []*ILBlock) {
+		for _, ib := range blocks {
+			if ib != nil && ib.typ == ILList {
+				// Lists can be nested by pattern replacements
+				flatten(ib.inner)
+				count += int64(len(ib.inner))
+			} else {
+				b.Append(ib)
+			}
 		}
 	}
+	flatten(oldinner)
 
 	/* This step combines similar consecutive ILBlock types */
 	var wg sync.WaitGroup
@@ -187,6 +224,7 @@ func (b *ILBlock) Compress() int {
 				// combine with previous run
 				lastb.param += ib.param
 				atomic.AddInt64(&count, 1)
+				lastb = b.dropNoOp(lastb)
 			} else {
 				// start next run
 				b.Append(ib)
@@ -200,6 +238,7 @@ func (b *ILBlock) Compress() int {
 					// combine with previous DataAdd or DataSet
 					lastb.param += ib.param
 					atomic.AddInt64(&count, 1)
+					lastb = b.dropNoOp(lastb)
 				default:
 					b.Append(ib)
 					lastb = ib
@@ -242,6 +281,24 @@ func (b *ILBlock) Compress() int {
 	return int(count)
 }
 
+// dropNoOp removes the last inner block of b, lastb, if combining made it
+// a no-op, so that the blocks around it can be combined without another
+// Prune and Compress. It returns the block to combine the next block with.
+func (b *ILBlock) dropNoOp(lastb *ILBlock) *ILBlock {
+	if lastb.typ == ILDataSet || !lastb.isPruneable() {
+		return lastb
+	}
+	b.inner = b.inner[:len(b.inner)-1]
+	if len(b.inner) == 0 {
+		return nil
+	}
+	switch prev := b.inner[len(b.inner)-1]; prev.typ {
+	case ILDataPtrAdd, ILWrite, ILDataAdd, ILDataSet:
+		return prev
+	}
+	return nil
+}
+
 // isPruneable uses a set of rules to determine if an ILBlock
 // node is able to be removed.
 func (b *ILBlock) isPruneable() bool {
@@ -328,28 +385,6 @@ func (c *voverlay) dataptradd(delta int64) {
 	c.footer.param = int64(c.ptrOff)
 }
 
-func (b *ILBlock) vectorCost() (vcost, icost int) {
-	const datapaddCost = 1 + 1 + 1         // add, check <0, check readjust
-	const dataaddvecStaticCost = 1 + 1 + 1 // check readjust, slice, bound check
-	if b.typ != ILDataAddVector && b.typ != ILDataAddLinVector {
-		return -1, -1
-	}
-
-	// Vector Static Cost
-	vcost += dataaddvecStaticCost
-	// Vector Dynamic Cost
-	vcost += len(b.vec)
-
-	for _, v := range b.vec {
-		if v != 0 {
-			// Independent Dynamic Cost
-			icost += datapaddCost
-		}
-	}
-
-	return
-}
-
 func (b *ILBlock) Vectorize() int {
 	// for long blocks that don't print, aggregate their data deltas
 	// and dataptr moves into the following:
@@ -395,13 +430,16 @@ func (b *ILBlock) Vectorize() int {
 				lastVec = &voverlay{
 					header: &ILBlock{
 						typ: ILDataPtrAdd,
+						pos: ib.pos,
 					},
 					vec: &ILBlock{
 						typ: ILDataAddVector,
 						vec: make([]byte, 0),
+						pos: ib.pos,
 					},
 					footer: &ILBlock{
 						typ: ILDataPtrAdd,
+						pos: ib.pos,
 					},
 				}
 				b.Append(lastVec.header)
@@ -416,7 +454,9 @@ func (b *ILBlock) Vectorize() int {
 			} else {
 				b.Append(ib)
 			}
-		case ILDataAddVector:
+		default:
+			// Vectors don't extend over data sets or other vectors
+			lastVec = nil
 			b.Append(ib)
 		}
 	}
@@ -428,7 +468,13 @@ func (b *ILBlock) Vectorize() int {
 // VectorBalance runs after Vectorizing and determines the runtime
 // cost of keeping vectorized adds as compared to having independent operations.
 // If the cost is higher to have vectorized operations, they are split up.
+// It uses the DefaultCostModel.
 func (b *ILBlock) VectorBalance() int {
+	return b.VectorBalanceWith(&DefaultCostModel)
+}
+
+// VectorBalanceWith is VectorBalance with the costs of the model m.
+func (b *ILBlock) VectorBalanceWith(m *CostModel) int {
 	var count int64
 
 	// base condition
@@ -448,22 +494,24 @@ func (b *ILBlock) VectorBalance() int {
 			b.Append(ib)
 			wg.Add(1)
 			go func(wg *sync.WaitGroup, ib *ILBlock) {
-				c := ib.VectorBalance()
+				c := ib.VectorBalanceWith(m)
 				atomic.AddInt64(&count, int64(c))
 				wg.Done()
 			}(&wg, ib)
 		case ILDataAddVector:
-			vcost, ocost := ib.vectorCost()
+			vcost, ocost := ib.vectorCost(m)
 			if vcost > ocost {
 				// Break It Up
 				for _, v := range ib.vec {
 					b.Append(&ILBlock{
 						typ:   ILDataAdd,
 						param: int64(v),
+						pos:   ib.pos,
 					})
 					b.Append(&ILBlock{
 						typ:   ILDataPtrAdd,
 						param: 1,
+						pos:   ib.pos,
 					})
 				}
 
@@ -475,6 +523,7 @@ func (b *ILBlock) VectorBalance() int {
 				b.Append(&ILBlock{
 					typ:   ILDataPtrAdd,
 					param: int64(-len(ib.vec)),
+					pos:   ib.pos,
 				})
 				atomic.AddInt64(&count, 1)
 			} else {
@@ -548,6 +597,11 @@ func PatternReplaceLinearVector(b *ILBlock) []*ILBlock {
 			return nil
 		}
 		addvec = b.inner[0]
+
+		// The loop must count down by one
+		if len(addvec.vec) == 0 || addvec.vec[0] != 0xFF {
+			return nil
+		}
 	} else if len(b.inner) == 3 {
 		if b.inner[0].typ != ILDataPtrAdd ||
 			b.inner[1].typ != ILDataAddVector ||
@@ -581,15 +635,28 @@ func PatternReplaceLinearVector(b *ILBlock) []*ILBlock {
 	}
 }
 
+// PatternReplace replaces the blocks of the tree b that a replacer
+// matches, when the replacement costs less with the DefaultCostModel.
 func (b *ILBlock) PatternReplace(replacers ...PatternReplacer) int {
+	return b.PatternReplaceWith(&DefaultCostModel, replacers...)
+}
+
+// PatternReplaceWith is PatternReplace with the costs of the model m.
+func (b *ILBlock) PatternReplaceWith(m *CostModel, replacers ...PatternReplacer) int {
 	var count int64
 
 	// Try all the replacer. If one matches and returns
-	// a set of replacement instructions, wrap them in an ILList
+	// a set of replacement instructions, which the model doesn't find
+	// more costly, wrap them in an ILList
 	// replace the current ILBlock.
 	for _, replacer := range replacers {
-		if rep := replacer(b); rep != nil {
+		if rep := replacer(b); rep != nil && m.blocksCost(rep) <= m.BlockCost(b) {
 			atomic.AddInt64(&count, 1)
+			for _, r := range rep {
+				if !r.pos.IsValid() {
+					r.pos = b.pos
+				}
+			}
 			// wrap it in an ILList
 			b.typ = ILList
 			b.inner = rep
@@ -605,7 +672,7 @@ func (b *ILBlock) PatternReplace(replacers ...PatternReplacer) int {
 		// again.
 		wg.Add(1)
 		go func(wg *sync.WaitGroup, ib *ILBlock) {
-			c := ib.PatternReplace(replacers...)
+			c := ib.PatternReplaceWith(m, replacers...)
 			atomic.AddInt64(&count, int64(c))
 			wg.Done()
 		}(&wg, ib)
@@ -638,51 +705,19 @@ func (b *ILBlock) BlockCount() int {
 	return int(count)
 }
 
-// You can't really predict the max data depth, since
+// PredictMaxDataSize returns the number of data cells the program b
+// touches, or -1 if it can not be predicted.
+//
+// You can't always predict the max data depth, since
 // you can use a loop to skip forward one at a time.
 // For example, this program sets the first two cells
 // to 1, rewinds the ptr back, has a loop find the last cell,
 // and then moves two places past.
 // +>+><<[>]>>
 func (b *ILBlock) PredictMaxDataSize() int {
-	var deltaMax int64
-	var delta int64
-
-	// base condition
-	if b.typ == ILDataPtrAdd {
-		if b.param > 0 {
-			deltaMax = b.param
-		}
-		return int(deltaMax)
-	}
-	if b.typ != ILList && b.typ != ILLoop {
-		return int(deltaMax)
-	}
-
-	// var wg sync.WaitGroup
-
-	for _, ib := range b.inner {
-		switch ib.typ {
-		case ILDataPtrAdd:
-			delta += ib.param
-			if delta > deltaMax {
-				deltaMax = delta
-			}
-		case ILList, ILLoop:
-			// wg.Add(1)
-			// go func(wg *sync.WaitGroup, ib *ILBlock) {
-			dMax := int64(ib.PredictMaxDataSize()) + delta
-			if dMax > deltaMax {
-				deltaMax = dMax
-			}
-			// atomic.AddInt64(&delta, int64(c))
-			// wg.Done()
-			// }(&wg, ib)
-		case ILRead, ILWrite, ILDataAdd:
-		case ILDataAddVector:
-		}
+	bd := b.AnalyzeBounds()
+	if !bd.Proven {
+		return -1
 	}
-	// wg.Wait()
-
-	return int(deltaMax)
+	return int(bd.Size)
 }
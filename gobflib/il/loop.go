@@ -0,0 +1,325 @@
+package il
+
+// The loop passes rewrite loops whose number of iterations is known,
+// because their control cell holds a known value when they are entered
+// and changes by a fixed step each iteration. The values of the cells are
+// tracked from the start of the program, so the passes must be run on the
+// IL tree of a whole program.
+
+const (
+	// unrollMaxBlocks limits the blocks that unrolling one loop creates
+	unrollMaxBlocks = 64
+	// unrollFactor is the number of copies of the body in the loop of a
+	// partially unrolled loop
+	unrollFactor = 4
+)
+
+// loopRewrite returns the blocks that replace loop, which is entered in
+// state s, or nil to keep it. The replacement may be empty.
+type loopRewrite func(loop *ILBlock, s *tapeState) []*ILBlock
+
+// rewriteLoops replaces the loops of the whole program b with rewrite,
+// inner loops first, and returns the number of loops replaced.
+func (b *ILBlock) rewriteLoops(rewrite loopRewrite) int {
+	if b.typ != ILList {
+		return 0
+	}
+	var count int
+	b.inner = newTapeState().rewriteLoops(b.inner, rewrite, &count)
+	return count
+}
+
+func (s *tapeState) rewriteLoops(blocks []*ILBlock, rewrite loopRewrite, count *int) []*ILBlock {
+	out := make([]*ILBlock, 0, len(blocks))
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILList:
+			b.inner = s.rewriteLoops(b.inner, rewrite, count)
+		case ILLoop:
+			var it *tapeState
+			if v, ok := s.get(0); !ok || v != 0 {
+				it = s.iterationState(b.inner, maxIterationDepth)
+				b.inner = it.clone().rewriteLoops(b.inner, rewrite, count)
+			}
+			if rep := rewrite(b, s); rep != nil {
+				for _, r := range rep {
+					if !r.pos.IsValid() {
+						r.pos = b.pos
+					}
+				}
+				*count++
+				s.runLoops(rep, maxIterationDepth, nil)
+				out = append(out, rep...)
+				continue
+			}
+			if it != nil {
+				*s = *it
+				s.set(0, 0)
+			}
+		default:
+			s.step(b)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// loopTrips returns the number of iterations of loop, which is entered
+// in state s, if it is known.
+func loopTrips(loop *ILBlock, s *tapeState) (int, bool) {
+	v, known := s.get(0)
+	if !known {
+		return 0, false
+	}
+	if v == 0 {
+		return 0, true
+	}
+	step, ok := controlStep(loop.inner)
+	if !ok {
+		return 0, false
+	}
+	return step.trips(v)
+}
+
+// clone returns a deep copy of b.
+func (b *ILBlock) clone() *ILBlock {
+	c := *b
+	if b.vec != nil {
+		c.vec = append([]byte(nil), b.vec...)
+	}
+	if b.inner != nil {
+		c.inner = make([]*ILBlock, len(b.inner))
+		for i, ib := range b.inner {
+			if ib != nil {
+				c.inner[i] = ib.clone()
+			}
+		}
+	}
+	return &c
+}
+
+// constantAdds returns the sum of the constants that blocks add to each
+// cell, by offset from the data pointer, if they only add constants to
+// cells and leave the data pointer where it started.
+func constantAdds(blocks []*ILBlock) (map[int64]byte, bool) {
+	adds := make(map[int64]byte)
+	var off int64
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILDataPtrAdd:
+			off += b.param
+		case ILDataAdd:
+			adds[off] += byte(b.param)
+		case ILDataAddVector:
+			for i, v := range b.vec {
+				adds[off+int64(i)] += v
+			}
+		default:
+			return nil, false
+		}
+	}
+	return adds, off == 0
+}
+
+// multipliedAdd returns the blocks that add the constants of adds,
+// multiplied by n, to the cells at their offsets from the data pointer,
+// and leave the data pointer where it was. The adds to more than one cell
+// become a vector.
+func multipliedAdd(adds map[int64]byte, n int) []*ILBlock {
+	var lo, hi int64
+	var found bool
+	for off, v := range adds {
+		if v*byte(n) == 0 {
+			continue
+		}
+		if !found || off < lo {
+			lo = off
+		}
+		if !found || off > hi {
+			hi = off
+		}
+		found = true
+	}
+	if !found {
+		return []*ILBlock{}
+	}
+
+	vec := make([]byte, hi-lo+1)
+	for off, v := range adds {
+		if off >= lo && off <= hi {
+			vec[off-lo] = v * byte(n)
+		}
+	}
+	add := &ILBlock{typ: ILDataAddVector, vec: vec}
+	if len(vec) == 1 {
+		// A single add compresses with its neighbors
+		add = &ILBlock{typ: ILDataAdd, param: int64(int8(vec[0]))}
+	}
+	if lo == 0 {
+		return []*ILBlock{add}
+	}
+	return []*ILBlock{
+		&ILBlock{typ: ILDataPtrAdd, param: lo},
+		add,
+		&ILBlock{typ: ILDataPtrAdd, param: -lo},
+	}
+}
+
+// CollapseLoops replaces the loops of the whole program b that run a
+// known number of times and only add constants to cells, like the loops
+// of -[->+++<], with one add of the total to each cell.
+// It returns the number of loops collapsed.
+func (b *ILBlock) CollapseLoops() int {
+	return b.rewriteLoops(func(loop *ILBlock, s *tapeState) []*ILBlock {
+		adds, ok := constantAdds(loop.inner)
+		if !ok {
+			return nil
+		}
+		n, ok := loopTrips(loop, s)
+		if !ok {
+			return nil
+		}
+		return multipliedAdd(adds, n)
+	})
+}
+
+// usedCells marks the cells that blocks use other than by adding constants
+// to them, like the control cells of loops, in used, by their offset from
+// the data pointer plus off. It reports false if blocks may move the data
+// pointer by an unknown amount.
+func usedCells(blocks []*ILBlock, off int64, used map[int64]bool) bool {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.typ {
+		case ILList:
+			if !usedCells(b.inner, off, used) {
+				return false
+			}
+			off += blocksExtent(b.inner).net
+		case ILLoop:
+			if e := blocksExtent(b.inner); !e.bounded || e.net != 0 {
+				return false
+			}
+			used[off] = true
+			if !usedCells(b.inner, off, used) {
+				return false
+			}
+		case ILDataPtrAdd:
+			off += b.param
+		case ILDataAdd, ILDataAddVector:
+		case ILDataSet, ILRead, ILWrite:
+			used[off] = true
+		case ILDataAddLinVector:
+			used[off] = true
+			for i := range b.vec {
+				used[off+b.param+int64(i)] = true
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// HoistLoopInvariants moves the constant adds out of the loops of the
+// whole program b that run a known number of times, when nothing else in
+// the loop uses the cells they add to. They are replaced by one add of
+// the total after the loop, so adds in nested loops move out as far as
+// they can. It returns the number of loops changed.
+func (b *ILBlock) HoistLoopInvariants() int {
+	return b.rewriteLoops(func(loop *ILBlock, s *tapeState) []*ILBlock {
+		n, ok := loopTrips(loop, s)
+		if !ok || n == 0 {
+			return nil
+		}
+
+		used := map[int64]bool{0: true}
+		if !usedCells(loop.inner, 0, used) {
+			return nil
+		}
+
+		adds := make(map[int64]byte)
+		inner := make([]*ILBlock, 0, len(loop.inner))
+		var off int64
+		for _, b := range loop.inner {
+			if b == nil {
+				continue
+			}
+			var hoist bool
+			switch b.typ {
+			case ILList:
+				off += blocksExtent(b.inner).net
+			case ILDataPtrAdd:
+				off += b.param
+			case ILDataAdd:
+				if hoist = !used[off]; hoist {
+					adds[off] += byte(b.param)
+				}
+			case ILDataAddVector:
+				hoist = true
+				for i, v := range b.vec {
+					if v != 0 && used[off+int64(i)] {
+						hoist = false
+					}
+				}
+				if hoist {
+					for i, v := range b.vec {
+						adds[off+int64(i)] += v
+					}
+				}
+			}
+			if !hoist {
+				inner = append(inner, b)
+			}
+		}
+		if len(inner) == len(loop.inner) {
+			return nil
+		}
+		loop.inner = inner
+		return append([]*ILBlock{loop}, multipliedAdd(adds, n)...)
+	})
+}
+
+// UnrollLoops replaces the loops of the whole program b that run a
+// known number of times with copies of their body. Loops that would
+// become too long are partially unrolled, into a loop over a few copies
+// of the body after the copies for the remaining iterations. Loops that
+// are never entered are removed. It returns the number of loops unrolled.
+func (b *ILBlock) UnrollLoops() int {
+	return b.rewriteLoops(func(loop *ILBlock, s *tapeState) []*ILBlock {
+		n, ok := loopTrips(loop, s)
+		if !ok {
+			return nil
+		}
+		var size int
+		for _, ib := range loop.inner {
+			size += ib.BlockCount()
+		}
+		copies := func(count int) []*ILBlock {
+			blocks := make([]*ILBlock, 0, count*len(loop.inner))
+			for i := 0; i < count; i++ {
+				for _, ib := range loop.inner {
+					blocks = append(blocks, ib.clone())
+				}
+			}
+			return blocks
+		}
+
+		if n*size <= unrollMaxBlocks {
+			return copies(n)
+		}
+		if n < 2*unrollFactor || unrollFactor*size > unrollMaxBlocks {
+			return nil
+		}
+		unrolled := &ILBlock{typ: ILLoop, inner: copies(unrollFactor), pos: loop.pos}
+		return append(copies(n%unrollFactor), unrolled)
+	})
+}
@@ -0,0 +1,96 @@
+package il
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// countLoops counts the loops in the tree b.
+func countLoops(b *ILBlock) int {
+	var count int
+	if b.typ == ILLoop {
+		count++
+	}
+	for _, ib := range b.inner {
+		count += countLoops(ib)
+	}
+	return count
+}
+
+// runTape runs b with the input 5, and returns its output and final tape.
+func runTape(t *testing.T, b *ILBlock) ([]byte, []byte) {
+	t.Helper()
+	var r Runner
+	var out bytes.Buffer
+	if err := r.Run(b, strings.NewReader("\x05"), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), bytes.TrimRight(r.Data, "\x00")
+}
+
+func TestLoopStepTrips(t *testing.T) {
+	for _, test := range []struct {
+		step  loopStep
+		v     byte
+		trips int
+		ok    bool
+	}{
+		{loopStep{value: 255}, 0, 0, true},
+		{loopStep{value: 255}, 5, 5, true},
+		{loopStep{value: 255}, 255, 255, true},
+		{loopStep{value: 1}, 255, 1, true},
+		{loopStep{value: 2}, 250, 3, true},
+		{loopStep{value: 2}, 5, 0, false},
+		{loopStep{value: 0}, 5, 0, false},
+		{loopStep{set: true}, 7, 1, true},
+		{loopStep{set: true, value: 1}, 7, 0, false},
+	} {
+		trips, ok := test.step.trips(test.v)
+		if trips != test.trips || ok != test.ok {
+			t.Errorf("%+v from %d runs %d times (%v), expected %d (%v)",
+				test.step, test.v, trips, ok, test.trips, test.ok)
+		}
+	}
+}
+
+func TestLoopPasses(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		src   string
+		pass  func(b *ILBlock) int
+		count int
+		loops int
+	}{
+		// The nested counters of gen-vector-test.bash, counting down from
+		// fewer than 255
+		{"collapse", "++[->+++[->++++[->+>+>+><<<<]<]<]>>>>.", (*ILBlock).CollapseLoops, 3, 0},
+		{"collapse unknown", ",[->+++<]>.", (*ILBlock).CollapseLoops, 0, 1},
+		{"collapse output", "+++[->+.<]", (*ILBlock).CollapseLoops, 0, 1},
+		{"collapse never entered", "[->+<]+.", (*ILBlock).CollapseLoops, 1, 0},
+		{"hoist", "++++[-.>++<]>.", (*ILBlock).HoistLoopInvariants, 1, 1},
+		{"hoist nested", "---[->++++[->.>+<<]>>+<<<]>>>.", (*ILBlock).HoistLoopInvariants, 2, 2},
+		{"hoist used", "++++[-.>+.<]", (*ILBlock).HoistLoopInvariants, 0, 1},
+		{"unroll", "++++[-.>+<]>.", (*ILBlock).UnrollLoops, 1, 0},
+		{"unroll partial", "-[->+.<]", (*ILBlock).UnrollLoops, 1, 1},
+		{"unroll nested", "++[->+++[->+.<]<]", (*ILBlock).UnrollLoops, 2, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			expOut, expTape := runTape(t, parseBF(test.src))
+			b := parseBF(test.src)
+			if count := test.pass(b); count != test.count {
+				t.Errorf("Rewrote %d loops, expected %d", count, test.count)
+			}
+			if loops := countLoops(b); loops != test.loops {
+				t.Errorf("%d loops are left, expected %d", loops, test.loops)
+			}
+			out, tape := runTape(t, b)
+			if !bytes.Equal(out, expOut) {
+				t.Errorf("Output is %q, expected %q", out, expOut)
+			}
+			if !bytes.Equal(tape, expTape) {
+				t.Errorf("Tape is %v, expected %v", tape, expTape)
+			}
+		})
+	}
+}
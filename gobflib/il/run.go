@@ -0,0 +1,162 @@
+package il
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// runDataSize is the initial size of the tape of a Runner, which grows as
+// needed.
+const runDataSize = 1024
+
+var ErrDataPtr = errors.New("Error: Data pointer moved out of bounds (off the beginning)")
+var ErrStepLimit = errors.New("Error: Program ran more steps than the step limit")
+
+// Runner interprets IL trees, and keeps the tape after a run, so that
+// it can be compared with the tape of another way of running the program.
+// The zero Runner starts with an empty tape and no step limit.
+type Runner struct {
+	// Data is the tape, which grows as needed
+	Data []byte
+	// Ptr is the data pointer
+	Ptr int64
+	// Steps counts the blocks run, where each test of a loop condition
+	// is also a step
+	Steps int64
+	// MaxSteps stops a run with ErrStepLimit once Steps exceeds it,
+	// unless it is 0
+	MaxSteps int64
+
+	in  io.Reader
+	out *bufio.Writer
+}
+
+// Run interprets b with a new tape, reading from in and writing to out.
+// Like the BF interpreter, the tape grows as needed, and the current cell
+// is left unchanged at the end of input. It lets the optimized IL be
+// checked without a backend.
+func (b *ILBlock) Run(in io.Reader, out io.Writer) error {
+	return new(Runner).Run(b, in, out)
+}
+
+// Run interprets b on the tape of r, reading from in and writing to out.
+func (r *Runner) Run(b *ILBlock, in io.Reader, out io.Writer) error {
+	if len(r.Data) == 0 {
+		r.Data = make([]byte, runDataSize)
+	}
+	r.in, r.out = in, bufio.NewWriter(out)
+	defer func() { r.in, r.out = nil, nil }()
+
+	if err := r.block(b); err != nil {
+		r.out.Flush()
+		return err
+	}
+	return r.out.Flush()
+}
+
+// cells makes sure the cells from offset lo to hi of the data pointer are
+// on the tape.
+func (r *Runner) cells(lo, hi int64) error {
+	if r.Ptr+lo < 0 {
+		return ErrDataPtr
+	}
+	if r.Ptr+hi >= int64(len(r.Data)) {
+		size := 2 * int64(len(r.Data))
+		for r.Ptr+hi >= size {
+			size *= 2
+		}
+		data := make([]byte, size)
+		copy(data, r.Data)
+		r.Data = data
+	}
+	return nil
+}
+
+// step counts a step, and returns ErrStepLimit past the step limit.
+func (r *Runner) step() error {
+	r.Steps++
+	if r.MaxSteps != 0 && r.Steps > r.MaxSteps {
+		return ErrStepLimit
+	}
+	return nil
+}
+
+func (r *Runner) blocks(blocks []*ILBlock) error {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		if err := r.block(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) block(b *ILBlock) error {
+	if b.typ != ILList {
+		if err := r.step(); err != nil {
+			return err
+		}
+	}
+	switch b.typ {
+	case ILList:
+		return r.blocks(b.inner)
+	case ILLoop:
+		for r.Data[r.Ptr] != 0 {
+			if err := r.blocks(b.inner); err != nil {
+				return err
+			}
+			if err := r.step(); err != nil {
+				return err
+			}
+		}
+	case ILDataPtrAdd:
+		if err := r.cells(b.param, b.param); err != nil {
+			return err
+		}
+		r.Ptr += b.param
+	case ILDataAdd:
+		r.Data[r.Ptr] += byte(b.param)
+	case ILDataSet:
+		r.Data[r.Ptr] = byte(b.param)
+	case ILRead:
+		if err := r.out.Flush(); err != nil {
+			return err
+		}
+		for i := int64(0); i < b.param; i++ {
+			_, err := io.ReadFull(r.in, r.Data[r.Ptr:r.Ptr+1])
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+	case ILWrite:
+		for i := int64(0); i < b.param; i++ {
+			if err := r.out.WriteByte(r.Data[r.Ptr]); err != nil {
+				return err
+			}
+		}
+	case ILDataAddVector:
+		if err := r.cells(0, int64(len(b.vec))-1); err != nil {
+			return err
+		}
+		for i, v := range b.vec {
+			r.Data[r.Ptr+int64(i)] += v
+		}
+	case ILDataAddLinVector:
+		mult := r.Data[r.Ptr]
+		if mult == 0 || len(b.vec) == 0 {
+			break
+		}
+		if err := r.cells(b.param, b.param+int64(len(b.vec))-1); err != nil {
+			return err
+		}
+		for i, v := range b.vec {
+			r.Data[r.Ptr+b.param+int64(i)] += mult * v
+		}
+	default:
+		panic("Encountered an unknown ILBlock type.")
+	}
+	return nil
+}
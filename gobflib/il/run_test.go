@@ -0,0 +1,53 @@
+package il
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// ,>++[<+>-]<. with its loop replaced by a linear vector
+	b := NewILBlock(ILList)
+	b.inner = []*ILBlock{
+		{typ: ILRead, param: 1},
+		{typ: ILDataPtrAdd, param: 1},
+		{typ: ILDataAdd, param: 2},
+		{typ: ILDataAddLinVector, param: -1, vec: []byte{1, 0xFF}},
+		{typ: ILDataPtrAdd, param: -1},
+		{typ: ILWrite, param: 2},
+		{typ: ILRead, param: 1},
+		{typ: ILWrite, param: 1},
+		{typ: ILDataSet, param: '!'},
+		{typ: ILWrite, param: 1},
+	}
+	var out bytes.Buffer
+	if err := b.Run(strings.NewReader("a"), &out); err != nil {
+		t.Fatal(err)
+	}
+	// Reading at the end of input leaves the cell unchanged
+	if out.String() != "ccc!" {
+		t.Errorf("output is %q, expected %q", out.String(), "ccc!")
+	}
+}
+
+func TestRunTape(t *testing.T) {
+	// Grow the tape far to the right, then move below zero
+	b := NewILBlock(ILList)
+	b.inner = []*ILBlock{
+		{typ: ILDataAdd, param: 1},
+		{typ: ILDataPtrAdd, param: 3 * runDataSize},
+		{typ: ILDataAddVector, vec: []byte{0, 0, 1}},
+		{typ: ILDataPtrAdd, param: -3 * runDataSize},
+		{typ: ILWrite, param: 1},
+		{typ: ILDataPtrAdd, param: -1},
+		{typ: ILWrite, param: 1},
+	}
+	var out bytes.Buffer
+	if err := b.Run(nil, &out); err != ErrDataPtr {
+		t.Fatalf("Run returned %v, expected %v", err, ErrDataPtr)
+	}
+	if out.String() != "\x01" {
+		t.Errorf("output is %q, expected %q", out.String(), "\x01")
+	}
+}
@@ -0,0 +1,135 @@
+package il
+
+import "fmt"
+
+// Diagnostic is a problem found in a program by Vet.
+type Diagnostic struct {
+	Pos     Pos
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %s", d.Pos, d.Message)
+}
+
+type vetter struct {
+	diags []Diagnostic
+	// reported unreachable code, so that it is only reported once
+	reportedDead bool
+}
+
+func (v *vetter) report(b *ILBlock, format string, a ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{
+		Pos:     b.pos,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// ptrCheck reports if the cell at offset lo from the data pointer
+// is known to be below zero.
+func (v *vetter) ptrCheck(b *ILBlock, s *tapeState, lo int64) {
+	if s.ptrKnown && s.ptr+lo < 0 {
+		v.report(b, "data pointer moves below zero (to %d)", s.ptr+lo)
+		// Stop reporting the same problem over and over
+		s.lose()
+	}
+}
+
+// isScan reports if the loop body only moves the data pointer,
+// like [>] or [<<], which is an intentional unbalanced loop.
+func isScan(blocks []*ILBlock) bool {
+	for _, b := range blocks {
+		if b.typ != ILDataPtrAdd {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *vetter) blocks(blocks []*ILBlock, s *tapeState) {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		if s.dead {
+			if !v.reportedDead {
+				v.report(b, "unreachable code after a loop that never terminates")
+				v.reportedDead = true
+			}
+			return
+		}
+
+		switch b.typ {
+		case ILList:
+			v.blocks(b.inner, s)
+		case ILLoop:
+			v.loop(b, s)
+		case ILDataPtrAdd:
+			s.step(b)
+			v.ptrCheck(b, s, 0)
+		case ILDataAddVector:
+			v.ptrCheck(b, s, 0)
+			s.step(b)
+		case ILDataAddLinVector:
+			v.ptrCheck(b, s, b.param)
+			s.step(b)
+		default:
+			s.step(b)
+		}
+	}
+}
+
+func (v *vetter) loop(b *ILBlock, s *tapeState) {
+	cell, known := s.get(0)
+	if known && cell == 0 {
+		v.report(b, "loop is never entered, the current cell is always zero here")
+		return
+	}
+
+	step, simple := controlStep(b.inner)
+	var infinite bool
+	if simple && known && !step.terminates(cell) {
+		infinite = true
+		v.report(b, "loop never terminates, the current cell is %d and the body %s", cell, step)
+	} else if simple && !known && (step.value != 0) == step.set {
+		v.report(b, "loop never terminates once entered, the body %s", step)
+	}
+
+	// Loops holding scans, like [<], move by an unknown amount but are
+	// usually intended, so only provably unbalanced loops are reported.
+	if e := blocksExtent(b.inner); e.bounded && e.net != 0 && !isScan(b.inner) {
+		v.report(b, "loop moves the data pointer by %d each iteration", e.net)
+	}
+
+	// Analyze the body from a state that holds for every iteration
+	body := s.clone()
+	body.havocLoop(b.inner)
+	v.blocks(b.inner, body)
+
+	s.havocLoop(b.inner)
+	s.set(0, 0)
+	if infinite || (known && body.dead) {
+		s.dead = true
+	}
+}
+
+func (step loopStep) String() string {
+	if step.set {
+		return fmt.Sprintf("sets it to %d", step.value)
+	}
+	if step.value == 0 {
+		return "never changes it"
+	}
+	return fmt.Sprintf("adds %d to it", step.value)
+}
+
+// Vet statically analyzes the program b, starting from a zeroed tape,
+// and reports likely mistakes. It finds loops that are never entered or
+// never terminate, code that can not be reached after such a loop,
+// data pointer moves below zero, and loops that unexpectedly move the
+// data pointer.
+func (b *ILBlock) Vet() []Diagnostic {
+	v := new(vetter)
+	v.blocks([]*ILBlock{b}, newTapeState())
+	return v.diags
+}
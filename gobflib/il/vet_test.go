@@ -0,0 +1,74 @@
+package il
+
+import (
+	"strings"
+	"testing"
+)
+
+// parseBF builds an unoptimized ILBlock tree from BF commands,
+// recording the column of each command.
+func parseBF(cmds string) *ILBlock {
+	root := NewILBlock(ILList)
+	stack := NewILBlockStack()
+	cur := root
+	for i, c := range cmds {
+		var b *ILBlock
+		switch c {
+		case '>', '<':
+			b = NewILBlock(ILDataPtrAdd)
+			b.param = map[rune]int64{'>': 1, '<': -1}[c]
+		case '+', '-':
+			b = NewILBlock(ILDataAdd)
+			b.param = map[rune]int64{'+': 1, '-': -1}[c]
+		case '.':
+			b = NewILBlock(ILWrite)
+			b.param = 1
+		case ',':
+			b = NewILBlock(ILRead)
+			b.param = 1
+		case '[':
+			b = NewILBlock(ILLoop)
+		case ']':
+			cur = stack.Pop()
+			continue
+		default:
+			continue
+		}
+		b.pos = Pos{Line: 1, Col: i + 1}
+		cur.Append(b)
+		if c == '[' {
+			stack.Push(cur)
+			cur = b
+		}
+	}
+	return root
+}
+
+func TestVet(t *testing.T) {
+	var tests = []struct {
+		cmds  string
+		diags []string // expected position and start of message
+	}{
+		{"++[->+<]>.", nil},
+		{"[comment]+.", []string{"1:1: loop is never entered"}},
+		{"+++[--]", []string{"1:4: loop never terminates"}},
+		{"+[].", []string{"1:2: loop never terminates", "1:4: unreachable code"}},
+		{",[>+<]", []string{"1:2: loop never terminates once entered"}},
+		{">><<<", []string{"1:5: data pointer moves below zero"}},
+		{"+[>+]", []string{"1:2: loop moves the data pointer by 1"}},
+		{"+[>]<[<]", nil},
+	}
+
+	for _, test := range tests {
+		diags := parseBF(test.cmds).Vet()
+		if len(diags) != len(test.diags) {
+			t.Errorf("%q: expected %d diagnostics, got %v", test.cmds, len(test.diags), diags)
+			continue
+		}
+		for i := range diags {
+			if !strings.HasPrefix(diags[i].String(), test.diags[i]) {
+				t.Errorf("%q: expected %q, got %q", test.cmds, test.diags[i], diags[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package jit
+
+import (
+	"encoding/binary"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// Registers used by the generated code:
+//
+//	rbx  data pointer
+//	r12  start of the tape
+//	r13  end of the tape
+//	rax  exit reason, and scratch
+//	rcx  exit argument, and scratch
+//	rdx  resume address, and scratch
+//
+// The code returns to the trampoline with an exit reason in rax.
+// Exits that can continue put the address to resume at in rdx.
+
+// asm is a small x86-64 assembler for the instructions the JIT uses.
+type asm struct {
+	code   []byte
+	labels []int // offset of each label, -1 until it is placed
+	fixups []fixup
+}
+
+// fixup is a rel32 operand at pos that should point at label.
+type fixup struct {
+	pos   int
+	label int
+}
+
+func (a *asm) bytes(b ...byte) {
+	a.code = append(a.code, b...)
+}
+
+func (a *asm) imm32(v int32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(v))
+	a.code = append(a.code, buf[:]...)
+}
+
+func (a *asm) newLabel() int {
+	a.labels = append(a.labels, -1)
+	return len(a.labels) - 1
+}
+
+func (a *asm) place(label int) {
+	a.labels[label] = len(a.code)
+}
+
+// rel32 appends a rel32 operand pointing at label.
+func (a *asm) rel32(label int) {
+	a.fixups = append(a.fixups, fixup{pos: len(a.code), label: label})
+	a.imm32(0)
+}
+
+// finish resolves all label references and returns the code.
+func (a *asm) finish() []byte {
+	for _, f := range a.fixups {
+		rel := a.labels[f.label] - (f.pos + 4)
+		binary.LittleEndian.PutUint32(a.code[f.pos:], uint32(int32(rel)))
+	}
+	return a.code
+}
+
+// Condition codes for jcc.
+const (
+	condB  = 0x2 // below, unsigned
+	condE  = 0x4 // equal or zero
+	condNE = 0x5 // not equal or not zero
+)
+
+func (a *asm) jcc(cond byte, label int) {
+	a.bytes(0x0F, 0x80|cond)
+	a.rel32(label)
+}
+
+func (a *asm) jmp(label int) {
+	a.bytes(0xE9)
+	a.rel32(label)
+}
+
+// addRBX is add $v, %rbx.
+func (a *asm) addRBX(v int32) {
+	a.bytes(0x48, 0x81, 0xC3)
+	a.imm32(v)
+}
+
+// leaRAX is lea disp(%rbx), %rax.
+func (a *asm) leaRAX(disp int32) {
+	a.bytes(0x48, 0x8D, 0x83)
+	a.imm32(disp)
+}
+
+// cmpStart is cmp %r12, reg for reg rbx (3) or rax (0).
+func (a *asm) cmpStart(reg byte) {
+	a.bytes(0x4C, 0x39, 0xE0|reg)
+}
+
+// cmpEnd is cmp %r13, reg for reg rbx (3) or rax (0).
+func (a *asm) cmpEnd(reg byte) {
+	a.bytes(0x4C, 0x39, 0xE8|reg)
+}
+
+const (
+	regRAX = 0
+	regRBX = 3
+)
+
+// addbCell is addb $v, disp(%rbx).
+func (a *asm) addbCell(disp int32, v byte) {
+	a.bytes(0x80, 0x83)
+	a.imm32(disp)
+	a.bytes(v)
+}
+
+// movbCell is movb $v, disp(%rbx).
+func (a *asm) movbCell(disp int32, v byte) {
+	a.bytes(0xC6, 0x83)
+	a.imm32(disp)
+	a.bytes(v)
+}
+
+// cmpbCellZero is cmpb $0, (%rbx).
+func (a *asm) cmpbCellZero() {
+	a.bytes(0x80, 0xBB)
+	a.imm32(0)
+	a.bytes(0)
+}
+
+// loadMult is movzbl (%rbx), %ecx.
+func (a *asm) loadMult() {
+	a.bytes(0x0F, 0xB6, 0x8B)
+	a.imm32(0)
+}
+
+// testECX is test %ecx, %ecx.
+func (a *asm) testECX() {
+	a.bytes(0x85, 0xC9)
+}
+
+// addbCL is addb %cl, disp(%rbx).
+func (a *asm) addbCL(disp int32) {
+	a.bytes(0x00, 0x8B)
+	a.imm32(disp)
+}
+
+// subbCL is subb %cl, disp(%rbx).
+func (a *asm) subbCL(disp int32) {
+	a.bytes(0x28, 0x8B)
+	a.imm32(disp)
+}
+
+// imulEDX is imul $v, %ecx, %edx.
+func (a *asm) imulEDX(v int32) {
+	a.bytes(0x69, 0xD1)
+	a.imm32(v)
+}
+
+// addbDL is addb %dl, disp(%rbx).
+func (a *asm) addbDL(disp int32) {
+	a.bytes(0x00, 0x93)
+	a.imm32(disp)
+}
+
+// exit returns to the trampoline with reason, argument arg in rcx, and
+// resuming at label.
+func (a *asm) exit(reason int32, arg int32, resume int) {
+	a.bytes(0x48, 0xC7, 0xC1) // mov $arg, %rcx
+	a.imm32(arg)
+	a.bytes(0x48, 0x8D, 0x15) // lea resume(%rip), %rdx
+	a.rel32(resume)
+	a.bytes(0xB8) // mov $reason, %eax
+	a.imm32(reason)
+	a.bytes(0xC3) // ret
+}
+
+// Exit reasons, returned in rax.
+const (
+	exitDone  = iota // the program finished
+	exitWrite        // write the current cell rcx times
+	exitRead         // read into the current cell
+	exitGrow         // grow the tape to hold the cell at offset rcx
+	exitLow          // the data pointer moved below the tape
+)
+
+// gen translates IL blocks into machine code.
+type gen struct {
+	asm
+	bounds *il.Bounds
+	low    int // label of the exitLow stub
+}
+
+// checkHigh makes sure the tape holds the cell at offset disp from the
+// data pointer, exiting to grow it if needed.
+func (g *gen) checkHigh(disp int32) {
+	check, ok := g.newLabel(), g.newLabel()
+	g.place(check)
+	g.leaRAX(disp)
+	g.cmpEnd(regRAX)
+	g.jcc(condB, ok)
+	g.exit(exitGrow, disp, check)
+	g.place(ok)
+}
+
+// checkLow exits if the cell at offset disp from the data pointer is
+// below the tape.
+func (g *gen) checkLow(disp int32) {
+	g.leaRAX(disp)
+	g.cmpStart(regRAX)
+	g.jcc(condB, g.low)
+}
+
+func (g *gen) loop(b *il.ILBlock, unchecked bool) {
+	start, end := g.newLabel(), g.newLabel()
+	g.cmpbCellZero()
+	g.jcc(condE, end)
+	g.place(start)
+	for _, ib := range b.GetInner() {
+		g.block(ib, unchecked)
+	}
+	g.cmpbCellZero()
+	g.jcc(condNE, start)
+	g.place(end)
+}
+
+// block translates b. Checks are skipped for blocks that the bounds
+// analysis proves to stay within the tape, like the Go backend does.
+func (g *gen) block(b *il.ILBlock, unchecked bool) {
+	if b == nil {
+		return
+	}
+
+	if unchecked || g.bounds.InBounds(b) {
+		unchecked = true
+	}
+
+	switch b.GetType() {
+	case il.ILList:
+		for _, ib := range b.GetInner() {
+			g.block(ib, unchecked)
+		}
+	case il.ILLoop:
+		lo, hi, ok := g.bounds.LoopExtent(b)
+		switch {
+		case unchecked || !ok:
+			g.loop(b, unchecked)
+		case lo >= 0:
+			g.checkHigh(int32(hi))
+			g.loop(b, true)
+		default:
+			checked, done := g.newLabel(), g.newLabel()
+			g.leaRAX(int32(lo))
+			g.cmpStart(regRAX)
+			g.jcc(condB, checked)
+			g.checkHigh(int32(hi))
+			g.loop(b, true)
+			g.jmp(done)
+			g.place(checked)
+			g.loop(b, false)
+			g.place(done)
+		}
+	case il.ILDataPtrAdd:
+		g.addRBX(int32(b.GetParam()))
+		if unchecked {
+			return
+		}
+		if b.GetParam() < 0 {
+			g.cmpStart(regRBX)
+			g.jcc(condB, g.low)
+		} else {
+			g.checkHigh(0)
+		}
+	case il.ILDataAdd:
+		g.addbCell(0, byte(b.GetParam()))
+	case il.ILRead:
+		for i := int64(0); i < b.GetParam(); i++ {
+			next := g.newLabel()
+			g.exit(exitRead, 0, next)
+			g.place(next)
+		}
+	case il.ILWrite:
+		next := g.newLabel()
+		g.exit(exitWrite, int32(b.GetParam()), next)
+		g.place(next)
+	case il.ILDataAddVector:
+		vec := b.GetVector()
+		if len(vec) == 0 {
+			return
+		}
+		if !unchecked {
+			g.checkHigh(int32(len(vec) - 1))
+		}
+		for i, v := range vec {
+			if v != 0 {
+				g.addbCell(int32(i), v)
+			}
+		}
+	case il.ILDataAddLinVector:
+		vec, off := b.GetVector(), int32(b.GetParam())
+		if len(vec) == 0 {
+			return
+		}
+		skip := g.newLabel()
+		g.loadMult()
+		g.testECX()
+		g.jcc(condE, skip)
+		if !unchecked {
+			g.checkLow(off)
+			g.checkHigh(off + int32(len(vec)) - 1)
+			// Exits to grow the tape clobber rcx
+			g.loadMult()
+		}
+		for i, v := range vec {
+			disp := off + int32(i)
+			switch v {
+			case 0:
+			case 1:
+				g.addbCL(disp)
+			case 255:
+				g.subbCL(disp)
+			default:
+				g.imulEDX(int32(v))
+				g.addbDL(disp)
+			}
+		}
+		g.place(skip)
+	case il.ILDataSet:
+		g.movbCell(0, byte(b.GetParam()))
+	default:
+		panic("Encountered an unknown ILBlock type.")
+	}
+}
+
+// generate translates the program b into machine code, which starts at
+// offset 0.
+func generate(b *il.ILBlock, bounds *il.Bounds) []byte {
+	g := &gen{bounds: bounds}
+	g.low = g.newLabel()
+
+	g.block(b, false)
+	g.bytes(0xB8) // mov $exitDone, %eax
+	g.imm32(exitDone)
+	g.bytes(0xC3) // ret
+
+	g.place(g.low)
+	g.bytes(0xB8) // mov $exitLow, %eax
+	g.imm32(exitLow)
+	g.bytes(0xC3) // ret
+	return g.finish()
+}
@@ -0,0 +1,149 @@
+// Package jit runs BF programs by translating their IL tree into amd64
+// machine code in memory.
+//
+// The machine code runs directly on the tape, and returns to Go for
+// I/O, to grow the tape, and to report errors, then resumes where it
+// left off. Running the code is only supported on Linux amd64, see
+// Supported. Since the code can't be preempted, a long running loop
+// without I/O holds up the garbage collector of other goroutines.
+package jit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// DefaultDataSize is the initial size of the tape, which grows as needed.
+const DefaultDataSize = 100000
+
+var ErrUnsupported = errors.New("Error: The JIT is only supported on linux/amd64")
+var ErrDataPtr = errors.New("Error: Data pointer moved out of bounds (off the beginning)")
+
+// context is shared with the trampoline, which loads the registers of the
+// machine code from it and saves them back when the code exits.
+type context struct {
+	start  uintptr // r12
+	end    uintptr // r13
+	ptr    uintptr // rbx
+	arg    uintptr // rcx
+	resume uintptr // rdx
+}
+
+// Program is a BF program translated into machine code.
+type Program struct {
+	code  []byte
+	entry uintptr
+	free  func() error
+	size  int // initial size of the tape
+}
+
+// Compile translates b into machine code and maps it into executable
+// memory. The program should be closed once it is no longer needed.
+func Compile(b *il.ILBlock) (*Program, error) {
+	bounds := b.AnalyzeBounds()
+	p := &Program{code: generate(b, bounds), size: DefaultDataSize}
+	// Blocks proven to stay within the first Size cells are not checked
+	if bounds.Size > int64(p.size) {
+		p.size = int(bounds.Size)
+	}
+	entry, free, err := mapCode(p.code)
+	if err != nil {
+		return nil, err
+	}
+	p.entry, p.free = entry, free
+	return p, nil
+}
+
+// Size returns the size in bytes of the machine code of p.
+func (p *Program) Size() int {
+	return len(p.code)
+}
+
+// Close unmaps the machine code of p.
+func (p *Program) Close() error {
+	if p.free == nil {
+		return nil
+	}
+	free := p.free
+	p.free = nil
+	return free()
+}
+
+// Run runs p with a new tape, reading from in and writing to out.
+// The current cell is left unchanged at the end of input. Output is
+// buffered until input is read or the program ends, unless unbuffered.
+func (p *Program) Run(in io.Reader, out io.Writer, unbuffered bool) (err error) {
+	if p.free == nil {
+		return errors.New("Error: The program is closed")
+	}
+
+	w := bufio.NewWriter(out)
+	defer func() {
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	tape := make([]byte, p.size)
+	var ctx context
+	ctx.start = addr(tape)
+	ctx.end = ctx.start + uintptr(len(tape))
+	ctx.ptr = ctx.start
+	ctx.resume = p.entry
+	defer runtime.KeepAlive(tape)
+
+	for {
+		reason := jitcall(ctx.resume, &ctx)
+		cell := int(ctx.ptr - ctx.start)
+		switch reason {
+		case exitDone:
+			return nil
+		case exitWrite:
+			for i := uintptr(0); i < ctx.arg; i++ {
+				if err := w.WriteByte(tape[cell]); err != nil {
+					return err
+				}
+			}
+			if unbuffered {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+		case exitRead:
+			// Make sure any prompt is visible before blocking on input
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(in, tape[cell:cell+1]); err != nil && err != io.EOF {
+				return fmt.Errorf("Error: Received read error during runtime: %v", err)
+			}
+		case exitGrow:
+			need := cell + int(int32(ctx.arg)) + 1
+			size := len(tape)
+			for size < need {
+				size *= 2
+			}
+			grown := make([]byte, size)
+			copy(grown, tape)
+			tape = grown
+			ctx.start = addr(tape)
+			ctx.end = ctx.start + uintptr(len(tape))
+			ctx.ptr = ctx.start + uintptr(cell)
+		case exitLow:
+			return ErrDataPtr
+		default:
+			panic(fmt.Sprintf("unknown JIT exit reason %d", reason))
+		}
+	}
+}
+
+// addr returns the address of the first byte of the tape.
+func addr(tape []byte) uintptr {
+	return uintptr(unsafe.Pointer(&tape[0]))
+}
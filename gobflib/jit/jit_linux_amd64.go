@@ -0,0 +1,32 @@
+package jit
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Supported reports if programs can be run on this platform.
+func Supported() bool {
+	return true
+}
+
+// jitcall runs the machine code at code with the registers in ctx, and
+// returns the exit reason.
+//
+//go:noescape
+func jitcall(code uintptr, ctx *context) uint64
+
+// mapCode copies code into a new executable mapping.
+func mapCode(code []byte) (entry uintptr, free func() error, err error) {
+	size := (len(code) + syscall.Getpagesize() - 1) &^ (syscall.Getpagesize() - 1)
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return 0, nil, err
+	}
+	copy(mem, code)
+	if err := syscall.Mprotect(mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		syscall.Munmap(mem)
+		return 0, nil, err
+	}
+	return uintptr(unsafe.Pointer(&mem[0])), func() error { return syscall.Munmap(mem) }, nil
+}
@@ -0,0 +1,19 @@
+#include "textflag.h"
+
+// func jitcall(code uintptr, ctx *context) uint64
+//
+// The machine code only uses the registers below and the return address
+// pushed by CALL, so it can run on the goroutine stack.
+TEXT ·jitcall(SB), NOSPLIT, $0-24
+	MOVQ ctx+8(FP), DI
+	MOVQ 0(DI), R12
+	MOVQ 8(DI), R13
+	MOVQ 16(DI), BX
+	MOVQ code+0(FP), AX
+	CALL AX
+	MOVQ ctx+8(FP), DI
+	MOVQ BX, 16(DI)
+	MOVQ CX, 24(DI)
+	MOVQ DX, 32(DI)
+	MOVQ AX, ret+16(FP)
+	RET
@@ -0,0 +1,16 @@
+//go:build !linux || !amd64
+
+package jit
+
+// Supported reports if programs can be run on this platform.
+func Supported() bool {
+	return false
+}
+
+func jitcall(code uintptr, ctx *context) uint64 {
+	panic(ErrUnsupported)
+}
+
+func mapCode(code []byte) (entry uintptr, free func() error, err error) {
+	return 0, nil, ErrUnsupported
+}
@@ -0,0 +1,208 @@
+package jit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib"
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// buildIL builds the IL tree for the BF commands in src, optionally with
+// the vector and pattern optimizations.
+func buildIL(src []byte, optimize bool) *il.ILBlock {
+	prgm := gobflib.NewIOBFProgram(0, 0, nil, nil)
+	prgm.ReadCommands(bytes.NewReader(src))
+	ilb := prgm.CreateILTree()
+	ilb.Compress()
+	ilb.Prune()
+	if optimize {
+		ilb.Vectorize()
+		ilb.VectorBalance()
+		ilb.PatternReplace(il.PatternReplaceLinearVector)
+		ilb.PatternReplace(il.PatternReplaceZero)
+	}
+	return ilb
+}
+
+func runJIT(t *testing.T, src []byte, optimize bool, input string) (string, error) {
+	p, err := Compile(buildIL(src, optimize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	var out bytes.Buffer
+	err = p.Run(strings.NewReader(input), &out, false)
+	return out.String(), err
+}
+
+func TestJIT(t *testing.T) {
+	if !Supported() {
+		t.Skip("the JIT is not supported on this platform")
+	}
+	hello, err := ioutil.ReadFile("../../testprograms/helloworld.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		cmds          string
+		input, output string
+		err           error
+	}{
+		{"empty", "", "", "", nil},
+		{"echo", ",>,>,>,<<<.>.>.>.", "abcd", "abcd", nil},
+		{"echo loop", ",>,>,>,<<<[.>]", "abcd", "abcd", nil},
+		{"eof unchanged", "+++,.", "", "\x03", nil},
+		{"grow", "+[-" + strings.Repeat(">", 200000) + "]+.", "", "\x01", nil},
+		{"grow in loop", strings.Repeat("+", 200) + "[[-" + strings.Repeat(">", 1000) + "+" + strings.Repeat("<", 1000) + "]" + strings.Repeat(">", 1000) + "-]+.", "", "\x01", nil},
+		{"below zero", "+.<.", "", "\x01", ErrDataPtr},
+		{"below zero in loop", "+[<+]", "", "", ErrDataPtr},
+		{"helloworld", string(hello), "", "Hello World!\n", nil},
+		{"multiply", "+++++[>++++++++++<-]>+++.<++[>>+++[<.>-]<<-]", "", "5555555", nil},
+	}
+
+	for _, tc := range tests {
+		for _, optimize := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/optimize=%v", tc.name, optimize), func(t *testing.T) {
+				out, err := runJIT(t, []byte(tc.cmds), optimize, tc.input)
+				if err != tc.err {
+					t.Fatalf("program returned %v, expected %v", err, tc.err)
+				}
+				if out != tc.output {
+					t.Fatalf("output is %q, expected %q", out, tc.output)
+				}
+			})
+		}
+	}
+}
+
+func TestJITPrograms(t *testing.T) {
+	if !Supported() {
+		t.Skip("the JIT is not supported on this platform")
+	}
+	files, _ := filepath.Glob("../../testprograms/*.b")
+	for _, fname := range files {
+		cmds, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The interpreter gives the expected output
+		var expected bytes.Buffer
+		prgm := gobflib.NewIOBFProgram(0, 0, bytes.NewReader(nil), &expected)
+		prgm.ReadCommands(bytes.NewReader(cmds))
+		if err := prgm.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, optimize := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/optimize=%v", filepath.Base(fname), optimize), func(t *testing.T) {
+				out, err := runJIT(t, cmds, optimize, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out != expected.String() {
+					t.Fatalf("output is %q, expected %q", out, expected.String())
+				}
+			})
+		}
+	}
+}
+
+func TestJITConformance(t *testing.T) {
+	if !Supported() {
+		t.Skip("the JIT is not supported on this platform")
+	}
+	tests, err := gobflib.LoadConformanceTests("../../testprograms/conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tests {
+		ct := &tests[i]
+		for _, level := range gobflib.OptimizeLevels {
+			t.Run(filepath.Base(ct.Name)+"/"+level.Name, func(t *testing.T) {
+				b, err := ct.IL(level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, err := Compile(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer p.Close()
+				var out bytes.Buffer
+				err = p.Run(bytes.NewReader(ct.Input), &out, false)
+				if err := ct.Check(out.Bytes(), err); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+// FuzzJIT checks that the JIT runs optimized programs like the IL
+// interpreter. The programs are balanced before they run.
+func FuzzJIT(f *testing.F) {
+	if !Supported() {
+		f.Skip("the JIT is not supported on this platform")
+	}
+	f.Add([]byte("+++[>++[>+<-]<-]>>."), []byte{})
+	f.Add([]byte(",[.,]"), []byte("abc"))
+	f.Add([]byte("+[->+>+<<]>[-]>[>]<."), []byte{})
+
+	f.Fuzz(func(t *testing.T, src, input []byte) {
+		var cmds []byte
+		var depth int
+		for _, c := range src {
+			switch c {
+			case '[':
+				depth++
+			case ']':
+				if depth == 0 {
+					continue
+				}
+				depth--
+			case '+', '-', '<', '>', '.', ',':
+			default:
+				continue
+			}
+			cmds = append(cmds, c)
+		}
+		cmds = append(cmds, bytes.Repeat([]byte("]"), depth)...)
+
+		for _, level := range gobflib.OptimizeLevels {
+			ct := gobflib.ConformanceTest{Source: cmds, Input: input}
+			b, err := ct.IL(level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only programs that end are run, since the JIT can't be
+			// stopped
+			r := il.Runner{MaxSteps: 10000}
+			var expected bytes.Buffer
+			err = r.Run(b, bytes.NewReader(input), &expected)
+			if err == il.ErrStepLimit {
+				return
+			}
+
+			p, jerr := Compile(b)
+			if jerr != nil {
+				t.Fatal(jerr)
+			}
+			var out bytes.Buffer
+			jerr = p.Run(bytes.NewReader(input), &out, false)
+			p.Close()
+			if (err == nil) != (jerr == nil) {
+				t.Fatalf("%q with level %s returned %v, expected %v", cmds, level.Name, jerr, err)
+			}
+			if out.String() != expected.String() {
+				t.Fatalf("%q with level %s outputs %q, expected %q", cmds, level.Name, out.String(), expected.String())
+			}
+		}
+	})
+}
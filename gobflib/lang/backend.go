@@ -0,0 +1,318 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"sort"
+	"sync"
+	"text/template"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+var ErrNoBuild = errors.New("Error: The backend only generates source, it can't build a binary")
+var ErrCrossCompile = errors.New("Error: Only the go backend can cross-compile or use gccgo")
+
+// Backend generates a program in one language from the IL tree, and
+// builds it into a binary.
+//
+// A Backend value is used for a single program. Generate calls Prologue,
+// then the operation methods while it walks the IL tree, then Epilogue.
+// Data pointer and tape growth checks are decided by the walk: operations
+// with unchecked set may skip them.
+type Backend interface {
+	// Prologue starts a program that is written to output, with the
+	// bounds analysis of the program. It returns an error if the backend
+	// does not support opts.
+	Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error
+	// Epilogue finishes the program, and returns any error writing it.
+	Epilogue() error
+
+	// Loop emits the loop b, which runs body while the current cell is
+	// not zero.
+	Loop(b *il.ILBlock, body func())
+	// Guard emits a loop whose iterations only touch the cells lo to hi,
+	// relative to the data pointer at the start of an iteration. It
+	// emits fast, which is the loop without checks, when the tape holds
+	// those cells, growing it to hold cell hi, and slow, which is the loop
+	// with checks, otherwise. Tapes that can grow only need slow when lo
+	// is negative.
+	Guard(lo, hi int64, fast, slow func())
+
+	DataPtrAdd(delta int64, unchecked bool)
+	DataAdd(value byte)
+	Read()
+	Write(repeat int64)
+	DataAddVector(vec []byte, unchecked bool)
+	// DataAddLinVector adds vec, multiplied by the current cell, to the
+	// cells starting at offset from the data pointer.
+	DataAddLinVector(vec []byte, offset int64, unchecked bool)
+	DataSet(value byte)
+
+	// Build compiles the generated source file infile into the binary
+	// outfile. Backends that only generate source return ErrNoBuild.
+	Build(infile, outfile string, opts CompileOptions) error
+}
+
+// CompileOptions controls how a generated program is built.
+type CompileOptions struct {
+	// Debug builds with debug information, and keeps the generated
+	// source, like KeepSource.
+	Debug bool
+	// KeepSource keeps the temp directory with the generated source.
+	KeepSource bool
+
+	// GOOS and GOARCH select the target operating system and
+	// architecture, like for the go tool. The host's are used if empty.
+	// Only the go backend can cross-compile.
+	GOOS   string
+	GOARCH string
+	// GccGo builds with gccgo instead of the gc compiler.
+	GccGo bool
+
+	// TrimPath removes the paths of the build from the binary.
+	TrimPath bool
+	// Strip leaves the symbol table and debug information out of the
+	// binary.
+	Strip bool
+	// Static links the binary statically.
+	Static bool
+
+	// Cache reuses the binaries built before, if set. It is not used
+	// with Debug or KeepSource.
+	Cache *Cache
+}
+
+// hostOnly returns ErrCrossCompile if opts needs the go tool.
+func (opts CompileOptions) hostOnly() error {
+	if (opts.GOOS != "" && opts.GOOS != runtime.GOOS) ||
+		(opts.GOARCH != "" && opts.GOARCH != runtime.GOARCH) || opts.GccGo {
+		return ErrCrossCompile
+	}
+	return nil
+}
+
+// BackendInfo describes a registered backend.
+type BackendInfo struct {
+	// Name selects the backend, like "c"
+	Name string
+	// Description is a short description for help messages
+	Description string
+	// SourceName is the name of the generated source file, like "main.c"
+	SourceName string
+	// New returns a Backend for one program
+	New func() Backend
+	// Interpreter is the command that runs the generated source, like
+	// "node", for backends that don't build a binary
+	Interpreter string
+	// Available returns an error if the tools that build the program
+	// are missing on this host. It may be nil.
+	Available func() error
+}
+
+// Runnable returns nil if the programs of the backend can be built or
+// interpreted, and run on this host.
+func (info BackendInfo) Runnable() error {
+	if info.Interpreter != "" {
+		_, err := exec.LookPath(info.Interpreter)
+		return err
+	}
+	if info.Available != nil {
+		return info.Available()
+	}
+	return nil
+}
+
+// lookPaths returns an error if one of the commands is not in the PATH.
+func lookPaths(commands ...string) error {
+	for _, c := range commands {
+		if _, err := exec.LookPath(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendInfo)
+)
+
+// RegisterBackend makes a backend available by its name, usually from the
+// init function of the package that implements it.
+// It panics if the name is empty or already registered.
+func RegisterBackend(info BackendInfo) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if info.Name == "" || info.New == nil {
+		panic("lang: RegisterBackend needs a name and New")
+	}
+	if _, dup := backends[info.Name]; dup {
+		panic("lang: RegisterBackend called twice for backend " + info.Name)
+	}
+	backends[info.Name] = info
+}
+
+// LookupBackend returns the registered backend name.
+func LookupBackend(name string) (BackendInfo, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	info, ok := backends[name]
+	return info, ok
+}
+
+// Backends returns the registered backends, sorted by name.
+func Backends() []BackendInfo {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	list := make([]BackendInfo, 0, len(backends))
+	for _, info := range backends {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Generate writes the program b to output with backend.
+func Generate(b *il.ILBlock, output io.Writer, backend Backend, opts GenOptions) error {
+	bounds := b.AnalyzeBounds()
+	if err := backend.Prologue(output, bounds, opts); err != nil {
+		return err
+	}
+	walk(backend, bounds, b, false)
+	return backend.Epilogue()
+}
+
+// CompileBackend generates the program b with the backend named name in a
+// temp directory, and builds it into the binary outfile, or copies it from
+// copts.Cache.
+// If err is non-nil, the tempdir is preserved and returned with the error.
+func CompileBackend(name string, b *il.ILBlock, outfile string, copts CompileOptions, opts GenOptions) (error, string) {
+	info, ok := LookupBackend(name)
+	if !ok {
+		return fmt.Errorf("Unknown backend \"%s\"", name), ""
+	}
+	backend := info.New()
+	return compileIL(outfile, copts, name, info.SourceName,
+		func(output io.Writer) error { return Generate(b, output, backend, opts) },
+		func(infile, outfile string) error { return backend.Build(infile, outfile, copts) })
+}
+
+// walk emits b with e.
+// Data pointer and tape growth checks are skipped for blocks that bounds
+// proves to stay within the tape, or when unchecked is set because an
+// enclosing block was already proven or checked.
+func walk(e Backend, bounds *il.Bounds, b *il.ILBlock, unchecked bool) {
+	if b == nil {
+		return
+	}
+
+	if unchecked || bounds.InBounds(b) {
+		unchecked = true
+	}
+
+	switch b.GetType() {
+	case il.ILList:
+		for _, ib := range b.GetInner() {
+			walk(e, bounds, ib, unchecked)
+		}
+	case il.ILLoop:
+		loop := func(unchecked bool) func() {
+			return func() {
+				e.Loop(b, func() {
+					for _, ib := range b.GetInner() {
+						walk(e, bounds, ib, unchecked)
+					}
+				})
+			}
+		}
+
+		lo, hi, ok := bounds.LoopExtent(b)
+		if unchecked || !ok {
+			loop(unchecked)()
+			return
+		}
+		// The body may only reach below zero in iterations that the
+		// original program would fail in, so keep a checked version of
+		// the loop for that case.
+		e.Guard(lo, hi, loop(true), loop(false))
+	case il.ILDataPtrAdd:
+		e.DataPtrAdd(b.GetParam(), unchecked)
+	case il.ILDataAdd:
+		e.DataAdd(byte(b.GetParam()))
+	case il.ILRead:
+		for i := int64(0); i < b.GetParam(); i++ {
+			e.Read()
+		}
+	case il.ILWrite:
+		e.Write(b.GetParam())
+	case il.ILDataAddVector:
+		if len(b.GetVector()) > 0 {
+			e.DataAddVector(b.GetVector(), unchecked)
+		}
+	case il.ILDataAddLinVector:
+		if len(b.GetVector()) > 0 {
+			e.DataAddLinVector(b.GetVector(), b.GetParam(), unchecked)
+		}
+	case il.ILDataSet:
+		e.DataSet(byte(b.GetParam()))
+	default:
+		panic("Encountered an unknown ILBlock type.")
+	}
+}
+
+// initialDataSize returns the initial tape size for a program with bounds.
+// It is at least every cell the program is proven to touch.
+func initialDataSize(bounds *il.Bounds) int {
+	datasize := DefaultDataSize
+	if bounds.Proven || bounds.Size > int64(datasize) {
+		datasize = int(bounds.Size)
+	}
+	return datasize
+}
+
+// templateText returns the user template in opts, or the backend's builtin
+// template.
+func templateText(opts GenOptions, builtin string) string {
+	if opts.Template != "" {
+		return opts.Template
+	}
+	return builtin
+}
+
+// templateBody runs a program template, whose {{range .Body}} is fed the
+// lines emitted by a backend while the template is written out.
+type templateBody struct {
+	cout chan<- string
+	done chan error
+}
+
+// start runs the template text with params, writing to output.
+func (t *templateBody) start(output io.Writer, text string, params TemplateParams) error {
+	tmpl, err := template.New("main").Parse(text)
+	if err != nil {
+		return fmt.Errorf("Failed to parse template: %v", err)
+	}
+	c := make(chan string, 1024)
+	t.cout, t.done = c, make(chan error, 1)
+	params.Body = c
+	params.CellWidth = CellWidth
+	params.EOFMode = EOFUnchanged
+	go func() {
+		err := tmpl.Execute(output, params)
+		// Let the backend finish
+		for range c {
+		}
+		t.done <- err
+	}()
+	return nil
+}
+
+// finish ends the body and waits for the template to be written.
+func (t *templateBody) finish() error {
+	close(t.cout)
+	return <-t.done
+}
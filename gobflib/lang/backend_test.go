@@ -0,0 +1,190 @@
+package lang
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// recordBackend records the calls made by Generate, one per line.
+type recordBackend struct {
+	output io.Writer
+}
+
+func (r *recordBackend) log(format string, a ...interface{}) {
+	fmt.Fprintf(r.output, format+"\n", a...)
+}
+
+func (r *recordBackend) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	r.output = output
+	r.log("prologue")
+	return nil
+}
+
+func (r *recordBackend) Epilogue() error {
+	r.log("epilogue")
+	return nil
+}
+
+func (r *recordBackend) Loop(b *il.ILBlock, body func()) {
+	r.log("loop")
+	body()
+	r.log("end")
+}
+
+func (r *recordBackend) Guard(lo, hi int64, fast, slow func()) {
+	r.log("guard %d %d", lo, hi)
+	fast()
+	slow()
+}
+
+func (r *recordBackend) DataPtrAdd(delta int64, unchecked bool) {
+	r.log("ptradd %d %v", delta, unchecked)
+}
+
+func (r *recordBackend) DataAdd(value byte) { r.log("add %d", value) }
+func (r *recordBackend) Read()              { r.log("read") }
+func (r *recordBackend) Write(repeat int64) { r.log("write %d", repeat) }
+
+func (r *recordBackend) DataAddVector(vec []byte, unchecked bool) {
+	r.log("vec %v %v", vec, unchecked)
+}
+
+func (r *recordBackend) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	r.log("linvec %v %d %v", vec, offset, unchecked)
+}
+
+func (r *recordBackend) DataSet(value byte) { r.log("set %d", value) }
+
+func (r *recordBackend) Build(infile, outfile string, opts CompileOptions) error {
+	return ErrNoBuild
+}
+
+func TestGenerate(t *testing.T) {
+	b := parseBF(",[>],[>+.<-]")
+	b.Compress()
+	var out strings.Builder
+	if err := Generate(b, &out, new(recordBackend), GenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `prologue
+read
+loop
+ptradd 1 false
+end
+read
+guard 0 1
+loop
+ptradd 1 true
+add 1
+write 1
+ptradd -1 true
+add 255
+end
+loop
+ptradd 1 false
+add 1
+write 1
+ptradd -1 false
+add 255
+end
+epilogue
+`
+	if out.String() != expect {
+		t.Errorf("Generate called:\n%s\nexpected:\n%s", out.String(), expect)
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	mustPanic := func(name string, info BackendInfo) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("RegisterBackend did not panic for %s", name)
+			}
+		}()
+		RegisterBackend(info)
+	}
+	newRecord := func() Backend { return new(recordBackend) }
+	mustPanic("duplicate", BackendInfo{Name: "go", New: newRecord})
+	mustPanic("empty name", BackendInfo{New: newRecord})
+	mustPanic("nil New", BackendInfo{Name: "test-nil"})
+
+	RegisterBackend(BackendInfo{Name: "test-record", New: newRecord})
+	defer func() {
+		backendsMu.Lock()
+		delete(backends, "test-record")
+		backendsMu.Unlock()
+	}()
+	if _, ok := LookupBackend("test-record"); !ok {
+		t.Error("LookupBackend did not find a registered backend")
+	}
+
+	var names []string
+	for _, info := range Backends() {
+		names = append(names, info.Name)
+	}
+	expect := "asm c go js llvm python test-record wasm"
+	if strings.Join(names, " ") != expect {
+		t.Errorf("Backends returned %v, expected %s", names, expect)
+	}
+}
+
+func TestCompileBackendNoBuild(t *testing.T) {
+	err, tempdir := CompileBackend("js", parseBF("+."), t.TempDir()+"/prog", CompileOptions{}, GenOptions{})
+	if err == nil || !strings.Contains(err.Error(), ErrNoBuild.Error()) {
+		t.Errorf("CompileBackend returned %v, expected %v", err, ErrNoBuild)
+	}
+	if tempdir != "" {
+		defer os.RemoveAll(tempdir)
+	}
+
+	if err, _ := CompileBackend("nope", parseBF("+."), "", CompileOptions{}, GenOptions{}); err == nil {
+		t.Error("CompileBackend did not fail for an unknown backend")
+	}
+}
+
+func TestCompileOptionsHostOnly(t *testing.T) {
+	other := "arm64"
+	if runtime.GOARCH == other {
+		other = "amd64"
+	}
+	for _, name := range []string{"c", "asm", "llvm"} {
+		err, tempdir := CompileBackend(name, parseBF("+."), t.TempDir()+"/prog",
+			CompileOptions{GOARCH: other}, GenOptions{})
+		if tempdir != "" {
+			defer os.RemoveAll(tempdir)
+		}
+		if err == nil || !strings.Contains(err.Error(), ErrCrossCompile.Error()) {
+			t.Errorf("CompileBackend(%s) returned %v, expected %v", name, err, ErrCrossCompile)
+		}
+	}
+}
+
+func TestCompileGoCross(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cross-compiling is slow")
+	}
+	outfile := t.TempDir() + "/prog"
+	opts := CompileOptions{GOOS: "linux", GOARCH: "arm64", Strip: true, TrimPath: true}
+	if err, _ := CompileIL(parseBF("+."), outfile, opts, GenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_AARCH64 {
+		t.Errorf("CompileIL built for %v, expected %v", f.Machine, elf.EM_AARCH64)
+	}
+	if f.Section(".symtab") != nil {
+		t.Error("CompileIL did not strip the symbol table")
+	}
+}
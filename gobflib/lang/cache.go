@@ -0,0 +1,213 @@
+package lang
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cache holds binaries built by CompileBackend, so that compiling the same
+// program again copies the binary instead of building it.
+//
+// Entries are keyed by a hash of the backend, the generated source, the
+// build options, and the versions and environment of the compilers. Since
+// the source is generated from the optimized IL, the template, and the
+// GenOptions, they are all part of the key.
+type Cache struct {
+	Dir string
+}
+
+// CacheStats summarizes the contents of a Cache.
+type CacheStats struct {
+	Entries int
+	Size    int64
+}
+
+// cacheTempPrefix prefixes the entries that are being written.
+const cacheTempPrefix = "tmp-"
+
+// DefaultCache returns the cache in the directory named by the GOBFCACHE
+// environment variable, or in gobf under the user's cache directory.
+func DefaultCache() (*Cache, error) {
+	dir := os.Getenv("GOBFCACHE")
+	if dir == "" {
+		userdir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userdir, "gobf")
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// key returns the key of the binary built by the backend name from src.
+func (c *Cache) key(name string, src []byte, opts CompileOptions) string {
+	// Only the options that change the binary
+	opts.Cache, opts.KeepSource = nil, false
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%+v\nCC=%s\n", name, opts, os.Getenv("CC"))
+	h.Write(toolchain(name, opts))
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// toolchains memoizes toolchain for the process, by the backend, options
+// and environment, so that only the first build of each backend pays for
+// running the compilers to get their versions.
+var toolchains = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: make(map[string][]byte)}
+
+// toolchain describes the compilers that the backend name builds with
+// opts, and the environment of the go tool, which select the binary that
+// the same source builds into.
+func toolchain(name string, opts CompileOptions) []byte {
+	memo := fmt.Sprintf("%s\n%+v\n%s", name, opts, strings.Join(os.Environ(), "\n"))
+	toolchains.Lock()
+	v, ok := toolchains.m[memo]
+	toolchains.Unlock()
+	if ok {
+		return v
+	}
+	v = toolchainVersions(name, opts)
+	toolchains.Lock()
+	toolchains.m[memo] = v
+	toolchains.Unlock()
+	return v
+}
+
+// toolchainVersions runs the compilers of toolchain to get their versions.
+func toolchainVersions(name string, opts CompileOptions) []byte {
+	var out bytes.Buffer
+	version := func(env []string, cmd string, args ...string) {
+		c := exec.Command(cmd, args...)
+		c.Env = env
+		v, err := c.Output()
+		if err != nil {
+			// The build fails the same way, so the key doesn't matter
+			fmt.Fprintf(&out, "%s: %v\n", cmd, err)
+		}
+		out.Write(v)
+	}
+	switch name {
+	case "go":
+		version(goEnv(opts), "go", "env", "GOVERSION", "GOOS", "GOARCH", "GOFLAGS", "GOAMD64", "CGO_ENABLED")
+		if opts.GccGo {
+			version(nil, "gccgo", "--version")
+		}
+	case "c":
+		version(nil, ccCommand(), "--version")
+	case "llvm":
+		version(nil, "clang", "--version")
+		version(nil, "llc", "--version")
+		version(nil, ccCommand(), "--version")
+	case "asm":
+		version(nil, "as", "--version")
+		version(nil, "ld", "--version")
+	}
+	return out.Bytes()
+}
+
+// get copies the binary for key to outfile.
+// It returns an error if the cache doesn't hold key.
+func (c *Cache) get(key, outfile string) error {
+	return copyBinary(filepath.Join(c.Dir, key), outfile)
+}
+
+// put adds the binary binfile as key.
+func (c *Cache) put(key, binfile string) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	// Write the entry under a temporary name, so that no other gobf can
+	// see it half written
+	tmp, err := ioutil.TempFile(c.Dir, cacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := copyBinary(binfile, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.Dir, key))
+}
+
+// Stats counts the entries in the cache and their size.
+func (c *Cache) Stats() (CacheStats, error) {
+	var stats CacheStats
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), cacheTempPrefix) {
+			continue
+		}
+		stats.Entries++
+		stats.Size += e.Size()
+	}
+	return stats, nil
+}
+
+// Clean removes every entry from the cache, and returns the number of
+// entries removed.
+func (c *Cache) Clean() (int, error) {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil {
+			return removed, err
+		}
+		if !strings.HasPrefix(e.Name(), cacheTempPrefix) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// copyBinary copies the binary src to dst, which is made executable even
+// if it already existed.
+func copyBinary(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile only sets the mode of a new file
+	return os.Chmod(dst, 0755)
+}
@@ -0,0 +1,124 @@
+package lang
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// buildBackend is recordBackend with a build that writes the source as
+// the binary, and counts the builds.
+type buildBackend struct {
+	recordBackend
+	builds *int
+}
+
+func (r *buildBackend) Build(infile, outfile string, opts CompileOptions) error {
+	*r.builds++
+	return copyBinary(infile, outfile)
+}
+
+func TestCompileCache(t *testing.T) {
+	var builds int
+	RegisterBackend(BackendInfo{
+		Name:       "test-build",
+		SourceName: "main.txt",
+		New:        func() Backend { return &buildBackend{builds: &builds} },
+	})
+	defer func() {
+		backendsMu.Lock()
+		delete(backends, "test-build")
+		backendsMu.Unlock()
+	}()
+
+	cache := &Cache{Dir: t.TempDir()}
+	outfile := t.TempDir() + "/prog"
+	compile := func(src string, copts CompileOptions) string {
+		t.Helper()
+		copts.Cache = cache
+		err, tempdir := CompileBackend("test-build", parseBF(src), outfile, copts, GenOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if copts.KeepSource {
+			os.RemoveAll(tempdir)
+		}
+		bin, err := ioutil.ReadFile(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bin)
+	}
+
+	first := compile("+.", CompileOptions{})
+	// A cache hit makes an existing output file executable
+	if err := os.Chmod(outfile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if again := compile("+.", CompileOptions{}); again != first || builds != 1 {
+		t.Errorf("Compiling again built %d times, expected 1", builds)
+	}
+	fi, err := os.Stat(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm()&0111 == 0 {
+		t.Errorf("Output from the cache has mode %v, expected it to be executable", fi.Mode())
+	}
+	compile("+.", CompileOptions{KeepSource: true})
+	if builds != 2 {
+		t.Errorf("Compiling with KeepSource used the cache")
+	}
+	compile("+.", CompileOptions{Strip: true})
+	compile("-.", CompileOptions{})
+	if builds != 4 {
+		t.Errorf("Compiling a different program or options built %d times, expected 4", builds)
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 3 || stats.Size == 0 {
+		t.Errorf("Cache has %d entries of %d bytes, expected 3", stats.Entries, stats.Size)
+	}
+	if removed, err := cache.Clean(); err != nil || removed != 3 {
+		t.Errorf("Clean removed %d entries with error %v, expected 3", removed, err)
+	}
+	if stats, _ := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("Cache has %d entries after Clean", stats.Entries)
+	}
+}
+
+func TestCacheKeyGoEnv(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not available")
+	}
+	var c Cache
+	src := []byte("package main\n")
+	key := func() string { return c.key("go", src, CompileOptions{}) }
+
+	t.Setenv("GOARCH", "amd64")
+	amd64 := key()
+	t.Setenv("GOARCH", "arm64")
+	arm64 := key()
+	if amd64 == arm64 {
+		t.Errorf("Keys for GOARCH amd64 and arm64 are the same")
+	}
+	toolchains.Lock()
+	probed := len(toolchains.m)
+	toolchains.Unlock()
+	if key() != arm64 {
+		t.Errorf("Key changed without a change to the environment")
+	}
+	toolchains.Lock()
+	if len(toolchains.m) != probed {
+		t.Errorf("The toolchain was probed again for the same environment")
+	}
+	toolchains.Unlock()
+	t.Setenv("GOFLAGS", "-trimpath")
+	if key() == arm64 {
+		t.Errorf("Key doesn't change with GOFLAGS")
+	}
+}
@@ -0,0 +1,253 @@
+package lang
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultFormatWidth  = 80
+	DefaultFormatIndent = 4
+)
+
+var ErrUnbalancedLoop = errors.New("Error: Unbalanced [ ]")
+
+// FormatOptions controls the layout produced by FormatBF.
+type FormatOptions struct {
+	// Width is the line length that runs of commands are wrapped at.
+	Width int
+	// Indent is the number of spaces added for each loop depth.
+	Indent int
+}
+
+type fmtTokenType byte
+
+const (
+	fmtCmd     fmtTokenType = iota // a single BF command
+	fmtWord                        // non-command text
+	fmtComment                     // # comment, up to the end of the line
+	fmtNewline                     // end of a line with content
+	fmtBlank                       // a line without any content
+)
+
+type fmtToken struct {
+	typ  fmtTokenType
+	text string
+	cmd  BFCmd
+}
+
+// tokenizeBF splits BF source into commands, comments, and the
+// non-command text around them, following the same rules as
+// BFProgram.ReadCommands.
+func tokenizeBF(input io.Reader) ([]fmtToken, error) {
+	var tokens []fmtToken
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1024*1024*1024)
+	for scanner.Scan() {
+		line := []rune(strings.TrimRightFunc(scanner.Text(), unicode.IsSpace))
+		if len(line) == 0 {
+			tokens = append(tokens, fmtToken{typ: fmtBlank})
+			continue
+		}
+
+		var word []rune
+		endWord := func() {
+			if len(word) > 0 {
+				tokens = append(tokens, fmtToken{typ: fmtWord, text: string(word)})
+				word = word[:0]
+			}
+		}
+		for i, c := range line {
+			if c == '#' {
+				endWord()
+				tokens = append(tokens, fmtToken{typ: fmtComment, text: string(line[i:])})
+				break
+			}
+			if cmd := NewBFCmd(c); cmd != BFCmdUnknown {
+				endWord()
+				tokens = append(tokens, fmtToken{typ: fmtCmd, cmd: cmd})
+				continue
+			}
+			if unicode.IsSpace(c) {
+				endWord()
+				continue
+			}
+			word = append(word, c)
+		}
+		endWord()
+		tokens = append(tokens, fmtToken{typ: fmtNewline})
+	}
+	return tokens, scanner.Err()
+}
+
+type formatter struct {
+	out          *bufio.Writer
+	opts         FormatOptions
+	tokens       []fmtToken
+	match        map[int]int // index of [ to index of matching ]
+	depth        int
+	line         strings.Builder
+	lineHasText  bool
+	lastWasWord  bool
+	wroteLine    bool
+	pendingBlank bool
+}
+
+func (f *formatter) avail() int {
+	return f.opts.Width - f.depth*f.opts.Indent
+}
+
+func (f *formatter) flush() {
+	if f.line.Len() == 0 {
+		return
+	}
+	if f.pendingBlank {
+		f.out.WriteString("\n")
+		f.pendingBlank = false
+	}
+	f.out.WriteString(strings.Repeat(" ", f.depth*f.opts.Indent))
+	f.out.WriteString(f.line.String())
+	f.out.WriteString("\n")
+	f.line.Reset()
+	f.lineHasText = false
+	f.lastWasWord = false
+	f.wroteLine = true
+}
+
+// put appends text to the current line, wrapping first if it would
+// not fit. Words are separated from everything else by a space.
+func (f *formatter) put(text string, isWord bool) {
+	space := f.line.Len() > 0 && (isWord || f.lastWasWord)
+	need := len(text)
+	if space {
+		need++
+	}
+	if f.line.Len() > 0 && f.line.Len()+need > f.avail() {
+		f.flush()
+		space = false
+	}
+	if space {
+		f.line.WriteString(" ")
+	}
+	f.line.WriteString(text)
+	f.lastWasWord = isWord
+	if isWord {
+		f.lineHasText = true
+	}
+}
+
+// inlineLoop returns the loop starting at token i as a single string if
+// it only holds commands and would fit on a line by itself.
+func (f *formatter) inlineLoop(i int) (string, bool) {
+	var loop strings.Builder
+	for _, t := range f.tokens[i : f.match[i]+1] {
+		switch t.typ {
+		case fmtCmd:
+			loop.WriteString(t.cmd.String())
+		case fmtNewline:
+		default:
+			return "", false
+		}
+	}
+	if loop.Len() > f.avail() {
+		return "", false
+	}
+	return loop.String(), true
+}
+
+func (f *formatter) format() error {
+	var stack []int
+	f.match = make(map[int]int)
+	for i, t := range f.tokens {
+		if t.typ != fmtCmd {
+			continue
+		}
+		switch t.cmd {
+		case BFCmdLoopStart:
+			stack = append(stack, i)
+		case BFCmdLoopEnd:
+			if len(stack) == 0 {
+				return ErrUnbalancedLoop
+			}
+			f.match[stack[len(stack)-1]] = i
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) != 0 {
+		return ErrUnbalancedLoop
+	}
+
+	for i := 0; i < len(f.tokens); i++ {
+		t := f.tokens[i]
+		switch t.typ {
+		case fmtCmd:
+			switch t.cmd {
+			case BFCmdLoopStart:
+				if loop, ok := f.inlineLoop(i); ok {
+					f.put(loop, false)
+					i = f.match[i]
+					continue
+				}
+				f.flush()
+				f.put(t.cmd.String(), false)
+				f.flush()
+				f.depth++
+			case BFCmdLoopEnd:
+				f.flush()
+				f.depth--
+				f.put(t.cmd.String(), false)
+				f.flush()
+			default:
+				f.put(t.cmd.String(), false)
+			}
+		case fmtWord:
+			f.put(t.text, true)
+		case fmtComment:
+			if f.line.Len() > 0 {
+				f.line.WriteString(" ")
+			}
+			f.line.WriteString(t.text)
+			f.flush()
+		case fmtNewline:
+			if f.lineHasText {
+				f.flush()
+			}
+		case fmtBlank:
+			f.flush()
+			if f.wroteLine {
+				f.pendingBlank = true
+			}
+		}
+	}
+	f.flush()
+	return f.out.Flush()
+}
+
+// FormatBF reads BF source from input and writes it to output in a
+// canonical layout. Loops are indented by depth, unless they are short
+// enough to stay on one line, and runs of commands are wrapped at
+// opts.Width. Comments and other non-command text are preserved.
+// Formatting already formatted source does not change it.
+func FormatBF(input io.Reader, output io.Writer, opts FormatOptions) error {
+	if opts.Width <= 0 {
+		opts.Width = DefaultFormatWidth
+	}
+	if opts.Indent <= 0 {
+		opts.Indent = DefaultFormatIndent
+	}
+
+	tokens, err := tokenizeBF(input)
+	if err != nil {
+		return err
+	}
+
+	f := &formatter{
+		out:    bufio.NewWriter(output),
+		opts:   opts,
+		tokens: tokens,
+	}
+	return f.format()
+}
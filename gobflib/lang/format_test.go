@@ -0,0 +1,91 @@
+package lang
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commandsOf returns only the BF commands of src, ignoring comments.
+func commandsOf(t *testing.T, src []byte) string {
+	tokens, err := tokenizeBF(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmds strings.Builder
+	for _, tok := range tokens {
+		if tok.typ == fmtCmd {
+			cmds.WriteString(tok.cmd.String())
+		}
+	}
+	return cmds.String()
+}
+
+func TestFormatBF(t *testing.T) {
+	sources := map[string][]byte{
+		"comments": []byte("\n # ignore <.>[]+- everything here\nNothing to run here  \n#+++."),
+		"nested":   []byte("++++[>++[>+<-]<-]>>.# done\n\n\n,[.,]"),
+		"long":     []byte(strings.Repeat("+>", 100) + "[" + strings.Repeat("-<", 60) + "]"),
+	}
+	files, _ := filepath.Glob("../../testprograms/*.b")
+	for _, fname := range files {
+		src, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(fname)] = src
+	}
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			opts := FormatOptions{Width: 40}
+
+			once := bytes.NewBuffer([]byte{})
+			if err := FormatBF(bytes.NewReader(src), once, opts); err != nil {
+				t.Fatal(err)
+			}
+			twice := bytes.NewBuffer([]byte{})
+			if err := FormatBF(bytes.NewReader(once.Bytes()), twice, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if commandsOf(t, src) != commandsOf(t, once.Bytes()) {
+				t.Log("formatted:\n" + once.String())
+				t.Fatal("Formatting changed the program commands")
+			}
+			if once.String() != twice.String() {
+				t.Log("once:\n" + once.String())
+				t.Log("twice:\n" + twice.String())
+				t.Fatal("Formatting is not idempotent")
+			}
+			for _, line := range strings.Split(once.String(), "\n") {
+				if len(line) > opts.Width && !strings.Contains(line, "#") {
+					t.Fatalf("Line exceeds width: %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatBFComments(t *testing.T) {
+	src := "+++ # add three\n# a whole line\n[-]\n"
+	out := bytes.NewBuffer([]byte{})
+	if err := FormatBF(strings.NewReader(src), out, FormatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != src {
+		t.Log("formatted:\n" + out.String())
+		t.Fatal("Comments were not preserved")
+	}
+}
+
+func TestFormatBFUnbalanced(t *testing.T) {
+	for _, src := range []string{"[", "]", "[]]"} {
+		err := FormatBF(strings.NewReader(src), ioutil.Discard, FormatOptions{})
+		if err != ErrUnbalancedLoop {
+			t.Errorf("Expected unbalanced error for %q, got %v", src, err)
+		}
+	}
+}
@@ -11,8 +11,15 @@ $HEADER
 package $PACKAGE
 EOF
 
-for template in templates/*.go; do
-	name=$(basename $template .go)
+# Go templates are named after the file, like templateConstMain,
+# and others also get their extension, like templateConstMainC
+for template in templates/*; do
+	file=$(basename $template)
+	name=${file%.*}
+	ext=${file##*.}
+	if [ "$ext" != go ]; then
+		name=${name}${ext^}
+	fi
 	echo
 	echo "const templateConst${name^} = \`"
 	cat $template
@@ -0,0 +1,230 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// AsmTapeSize is the size of the tape reserved by the assembly backend.
+// Only the pages of the tape that are touched are allocated.
+const AsmTapeSize = 1 << 30
+
+var ErrAsmUnsupported = errors.New("Error: Profiling and generating a function are not supported by the assembly backend")
+
+// asmGen emits the x86-64 GNU assembler instructions for a program body.
+type asmGen struct {
+	templateBody
+	labels int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "asm",
+		Description: "x86-64 GNU assembler for Linux, built with as and ld",
+		SourceName:  "main.s",
+		New:         func() Backend { return new(asmGen) },
+		Available:   asmAvailable,
+	})
+}
+
+func (g *asmGen) emit(format string, a ...interface{}) {
+	g.cout <- "\t" + fmt.Sprintf(format, a...)
+}
+
+func (g *asmGen) label(name string) {
+	g.cout <- name + ":"
+}
+
+func (g *asmGen) newLabel() int {
+	g.labels++
+	return g.labels
+}
+
+// checkLow emits jumping to fail if the cell at offset from the data
+// pointer is below the tape.
+func (g *asmGen) checkLow(offset int64, fail string) {
+	g.emit("lea %d(%%rbx), %%rax", offset)
+	g.emit("cmp %%r12, %%rax")
+	g.emit("jb %s", fail)
+}
+
+// checkHigh emits jumping to fail if the cell at offset from the data
+// pointer is past the tape.
+func (g *asmGen) checkHigh(offset int64, fail string) {
+	g.emit("lea %d(%%rbx), %%rax", offset)
+	g.emit("cmp %%r13, %%rax")
+	g.emit("jae %s", fail)
+}
+
+func (g *asmGen) Loop(b *il.ILBlock, body func()) {
+	n := g.newLabel()
+	g.emit("cmpb $0, (%%rbx)")
+	g.emit("je .Lend%d", n)
+	g.label(fmt.Sprintf(".Lloop%d", n))
+	body()
+	g.emit("cmpb $0, (%%rbx)")
+	g.emit("jne .Lloop%d", n)
+	g.label(fmt.Sprintf(".Lend%d", n))
+}
+
+// guard runs the checked version of the loop whenever its extent leaves
+// the tape, since the tape can't grow.
+func (g *asmGen) Guard(lo, hi int64, fast, slow func()) {
+	n := g.newLabel()
+	checked := fmt.Sprintf(".Lchecked%d", n)
+	if lo < 0 {
+		g.checkLow(lo, checked)
+	}
+	g.checkHigh(hi, checked)
+	fast()
+	g.emit("jmp .Ldone%d", n)
+	g.label(checked)
+	slow()
+	g.label(fmt.Sprintf(".Ldone%d", n))
+}
+
+func (g *asmGen) DataPtrAdd(delta int64, unchecked bool) {
+	g.emit("add $%d, %%rbx", delta)
+	if unchecked {
+		return
+	}
+	if delta < 0 {
+		g.emit("cmp %%r12, %%rbx")
+		g.emit("jb oob_low")
+	} else {
+		g.emit("cmp %%r13, %%rbx")
+		g.emit("jae oob_high")
+	}
+}
+
+func (g *asmGen) DataAdd(value byte) {
+	g.emit("addb $%d, (%%rbx)", value)
+}
+
+func (g *asmGen) Read() {
+	g.emit("call readb")
+}
+
+func (g *asmGen) Write(repeat int64) {
+	g.emit("mov $%d, %%edi", repeat)
+	g.emit("call writeb")
+}
+
+func (g *asmGen) DataAddVector(vec []byte, unchecked bool) {
+	if !unchecked {
+		g.checkHigh(int64(len(vec))-1, "oob_high")
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.emit("addb $%d, %d(%%rbx)", v, i)
+		}
+	}
+}
+
+func (g *asmGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	n := g.newLabel()
+	g.emit("movzbl (%%rbx), %%ecx")
+	g.emit("test %%ecx, %%ecx")
+	g.emit("jz .Lskip%d", n)
+	if !unchecked {
+		g.checkLow(offset, "oob_low")
+		g.checkHigh(offset+int64(len(vec))-1, "oob_high")
+	}
+	for i, v := range vec {
+		disp := offset + int64(i)
+		switch v {
+		case 0:
+		case 1:
+			g.emit("addb %%cl, %d(%%rbx)", disp)
+		case 255:
+			g.emit("subb %%cl, %d(%%rbx)", disp)
+		default:
+			g.emit("imul $%d, %%ecx, %%edx", v)
+			g.emit("addb %%dl, %d(%%rbx)", disp)
+		}
+	}
+	g.label(fmt.Sprintf(".Lskip%d", n))
+}
+
+func (g *asmGen) DataSet(value byte) {
+	g.emit("movb $%d, (%%rbx)", value)
+}
+
+func (g *asmGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrAsmUnsupported
+	}
+	if bounds.Size > AsmTapeSize {
+		return fmt.Errorf("Error: Program needs %d cells, which is more than the tape size %d", bounds.Size, AsmTapeSize)
+	}
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainS, "\n")), TemplateParams{
+		Unbuffered: opts.Unbuffered,
+		TapeSize:   AsmTapeSize,
+	})
+}
+
+func (g *asmGen) Epilogue() error {
+	return g.finish()
+}
+
+func (g *asmGen) Build(infile, outfile string, opts CompileOptions) error {
+	return CompileAsm(infile, outfile, opts)
+}
+
+// ILBlockToAsm writes GNU assembler source for Linux x86-64, equivalent
+// to b, to output. The program uses raw system calls instead of libc,
+// so it links into a small static binary, see CompileAsm.
+func ILBlockToAsm(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(asmGen), opts)
+}
+
+// asmAvailable returns an error if the host can't build or run the
+// assembly programs.
+func asmAvailable() error {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		return errors.New("the assembly backend only runs on linux/amd64")
+	}
+	return lookPaths("as", "ld")
+}
+
+// CompileAsm assembles the GNU assembler file infile with as and links it
+// into the static binary outfile with ld. The binary is stripped unless
+// opts.Debug is set.
+func CompileAsm(infile, outfile string, opts CompileOptions) error {
+	if err := opts.hostOnly(); err != nil {
+		return err
+	}
+	objfile := strings.TrimSuffix(infile, filepath.Ext(infile)) + ".o"
+
+	asargs := []string{"--64", "-o", objfile, infile}
+	ldargs := []string{"-static", "-o", outfile, objfile}
+	if opts.Debug {
+		asargs = append([]string{"-g"}, asargs...)
+	} else {
+		ldargs = append([]string{"-s"}, ldargs...)
+	}
+
+	for _, c := range [][]string{append([]string{"as"}, asargs...), append([]string{"ld"}, ldargs...)} {
+		build := exec.Command(c[0], c[1:]...)
+		build.Stdout = os.Stderr
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to build binary from assembly: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// CompileILAsm is CompileIL, using the assembly backend.
+func CompileILAsm(b *il.ILBlock, outfile string, copts CompileOptions, opts GenOptions) (error, string) {
+	return CompileBackend("asm", b, outfile, copts, opts)
+}
@@ -0,0 +1,202 @@
+package lang
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// bfWriter lowers ILBlocks to BF commands.
+// Data pointer moves are deferred until a command needs the pointer,
+// so that adjacent moves and vector walks cancel out, unless they go below
+// every cell visited so far, where they may fail.
+// The offsets are relative to the data pointer on entry to the innermost
+// loop being written, or where it was left by the last loop.
+type bfWriter struct {
+	out  *bufio.Writer
+	cur  int64 // where the emitted commands have left the data pointer
+	virt int64 // where the IL expects the data pointer to be
+	low  int64 // the lowest cell the emitted commands have visited
+	dip  int64 // the lowest cell the IL has moved to since the last move
+}
+
+// reset makes the current data pointer the origin of the offsets.
+func (w *bfWriter) reset() {
+	w.cur, w.virt, w.low, w.dip = 0, 0, 0, 0
+}
+
+// walk emits the commands to bring the data pointer to offset.
+func (w *bfWriter) walk(offset int64) {
+	if offset > w.cur {
+		w.repeat(BFCmdDataPtrIncrement, offset-w.cur)
+	} else {
+		w.repeat(BFCmdDataPtrDecrement, w.cur-offset)
+	}
+	w.cur = offset
+	if offset < w.low {
+		w.low = offset
+	}
+}
+
+// keepDip walks to the lowest cell the IL has moved to, if it is below
+// every cell visited so far and below offset, since that move may take the
+// data pointer out of bounds.
+func (w *bfWriter) keepDip(offset int64) {
+	if w.dip < w.low && w.dip < offset {
+		w.walk(w.dip)
+	}
+}
+
+func (w *bfWriter) repeat(cmd BFCmd, count int64) {
+	for i := int64(0); i < count; i++ {
+		w.out.WriteString(cmd.String())
+	}
+}
+
+// move emits the commands to bring the data pointer to offset, through the
+// deferred moves that may fail.
+func (w *bfWriter) move(offset int64) {
+	w.keepDip(offset)
+	w.walk(offset)
+	w.dip = w.virt
+}
+
+// add emits the shortest run of + or - that adds value to the current cell.
+func (w *bfWriter) add(value byte) {
+	if value <= 128 {
+		w.repeat(BFCmdDataIncrement, int64(value))
+	} else {
+		w.repeat(BFCmdDataDecrement, 256-int64(value))
+	}
+}
+
+// vector emits adds of vec to the cells starting at offset from the
+// logical data pointer. The cells are visited in whichever direction
+// is closest to the current data pointer.
+func (w *bfWriter) vector(vec []byte, offset int64) {
+	var first, last = -1, -1
+	for i, v := range vec {
+		if v != 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	var start = w.virt + offset + int64(first)
+	var end = w.virt + offset + int64(last)
+	var distStart, distEnd = w.cur - start, w.cur - end
+	if distStart < 0 {
+		distStart = -distStart
+	}
+	if distEnd < 0 {
+		distEnd = -distEnd
+	}
+
+	if distStart <= distEnd {
+		for i := first; i <= last; i++ {
+			if vec[i] != 0 {
+				w.move(w.virt + offset + int64(i))
+				w.add(vec[i])
+			}
+		}
+	} else {
+		for i := last; i >= first; i-- {
+			if vec[i] != 0 {
+				w.move(w.virt + offset + int64(i))
+				w.add(vec[i])
+			}
+		}
+	}
+}
+
+func (w *bfWriter) block(b *il.ILBlock) error {
+	if b == nil {
+		return nil
+	}
+
+	switch b.GetType() {
+	case il.ILList:
+		for _, ib := range b.GetInner() {
+			if err := w.block(ib); err != nil {
+				return err
+			}
+		}
+	case il.ILLoop:
+		w.move(w.virt)
+		w.out.WriteString(BFCmdLoopStart.String())
+		w.reset()
+		for _, ib := range b.GetInner() {
+			if err := w.block(ib); err != nil {
+				return err
+			}
+		}
+		w.move(w.virt)
+		w.out.WriteString(BFCmdLoopEnd.String())
+		w.reset()
+	case il.ILDataPtrAdd:
+		w.virt += b.GetParam()
+		if w.virt < w.dip {
+			w.dip = w.virt
+		}
+	case il.ILDataAdd:
+		w.move(w.virt)
+		w.add(byte(b.GetParam()))
+	case il.ILDataSet:
+		w.move(w.virt)
+		w.out.WriteString("[-]")
+		w.add(byte(b.GetParam()))
+	case il.ILRead:
+		w.move(w.virt)
+		w.repeat(BFCmdInputByte, b.GetParam())
+	case il.ILWrite:
+		w.move(w.virt)
+		w.repeat(BFCmdOutputByte, b.GetParam())
+	case il.ILDataAddVector:
+		w.vector(b.GetVector(), 0)
+	case il.ILDataAddLinVector:
+		// A linear vector can only be written as a BF loop when it
+		// decrements the multiplier cell by one, so that the loop runs
+		// exactly multiplier times.
+		vec, off := b.GetVector(), b.GetParam()
+		if off > 0 || -off >= int64(len(vec)) || vec[-off] != 0xFF {
+			return fmt.Errorf("linear vector %v at offset %d does not decrement its multiplier cell", vec, off)
+		}
+		w.move(w.virt)
+		w.out.WriteString(BFCmdLoopStart.String())
+		w.reset()
+		w.vector(vec, off)
+		w.move(w.virt)
+		w.out.WriteString(BFCmdLoopEnd.String())
+		w.reset()
+	default:
+		panic("Encountered an unknown ILBlock type.")
+	}
+	return nil
+}
+
+// ILBlockToBF lowers the ILBlock tree b back into plain BF commands.
+// Data pointer moves at the very end of the program, which can not affect
+// its output, are not written, unless they may move the data pointer out
+// of bounds.
+//
+// A linear vector is written as a loop, so it must decrement its
+// multiplier cell by one, like the vectors of il.PatternReplaceLinearVector.
+// Lowering other linear vectors would need a scratch cell that is known to
+// be zero, so they return an error.
+func ILBlockToBF(b *il.ILBlock, output io.Writer) error {
+	w := &bfWriter{
+		out: bufio.NewWriter(output),
+	}
+	if err := w.block(b); err != nil {
+		return err
+	}
+	w.keepDip(w.cur)
+	return w.out.Flush()
+}
@@ -0,0 +1,61 @@
+package lang
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+func TestILBlockToBF(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"cancel", "+><-", "+-"},
+		{"trailing", "+>>", "+"},
+		{"dip", "<>+", "<>+"},
+		{"trailing dip", "+>+<<<", "+>+<<<"},
+		{"visited", ">+<>>+<<", ">+>+"},
+		{"loop dip", "+[<>-]", "+[<>-]"},
+		{"loop visited", "+[-<+>]", "+[-<+>]"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := ILBlockToBF(parseBF(test.src), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Lowered %q to %q, expected %q", test.src, out.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestILBlockToBFLinVector(t *testing.T) {
+	b := parseBF("+[->++<]")
+	b.Compress()
+	b.Vectorize()
+	b.Prune()
+	if count := b.PatternReplace(il.PatternReplaceLinearVector); count != 1 {
+		t.Fatalf("Replaced %d loops, expected 1", count)
+	}
+	var out bytes.Buffer
+	if err := ILBlockToBF(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "+[->++<]" {
+		t.Errorf("Lowered to %q, expected %q", out.String(), "+[->++<]")
+	}
+
+	// A vector that leaves its multiplier cell unchanged
+	lin := il.NewILBlock(il.ILDataAddLinVector)
+	lin.SetParam(1)
+	lin.SetVector([]byte{2})
+	b = il.NewILBlock(il.ILList)
+	b.Append(lin)
+	if err := ILBlockToBF(b, &out); err == nil {
+		t.Error("Lowering a vector that doesn't decrement its multiplier did not fail")
+	}
+}
@@ -0,0 +1,197 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+var ErrCUnsupported = errors.New("Error: Profiling and generating a function are not supported by the C backend")
+
+// cGen emits the C statements for a program body.
+type cGen struct {
+	templateBody
+	depth int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "c",
+		Description: "C99, built with the system C compiler",
+		SourceName:  "main.c",
+		New:         func() Backend { return new(cGen) },
+		Available:   func() error { return lookPaths(ccCommand()) },
+	})
+}
+
+func (g *cGen) emit(format string, a ...interface{}) {
+	g.cout <- strings.Repeat("\t", g.depth) + fmt.Sprintf(format, a...)
+}
+
+// cVector returns the C arguments for vec, which are a C99 compound
+// literal followed by its length.
+func cVector(vec []byte) string {
+	var s strings.Builder
+	s.WriteString("(const unsigned char[]){")
+	for i, v := range vec {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		fmt.Fprintf(&s, "%d", v)
+	}
+	fmt.Fprintf(&s, "}, %d", len(vec))
+	return s.String()
+}
+
+// isScanRight reports if the loop b is [>], which is run with memchr.
+func isScanRight(b *il.ILBlock) bool {
+	inner := b.GetInner()
+	return len(inner) == 1 && inner[0] != nil &&
+		inner[0].GetType() == il.ILDataPtrAdd && inner[0].GetParam() == 1
+}
+
+func (g *cGen) Loop(b *il.ILBlock, body func()) {
+	if isScanRight(b) {
+		g.emit("scanright();")
+		return
+	}
+	g.emit("while (data[datap]) {")
+	g.depth++
+	body()
+	g.depth--
+	g.emit("}")
+}
+
+func (g *cGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.emit("datagrow(%d);", hi)
+		fast()
+		return
+	}
+	g.emit("if (datap >= %d) {", -lo)
+	g.depth++
+	g.emit("datagrow(%d);", hi)
+	fast()
+	g.depth--
+	g.emit("} else {")
+	g.depth++
+	slow()
+	g.depth--
+	g.emit("}")
+}
+
+func (g *cGen) DataPtrAdd(delta int64, unchecked bool) {
+	if unchecked {
+		g.emit("datap += %d;", delta)
+	} else {
+		g.emit("datapadd(%d);", delta)
+	}
+}
+
+func (g *cGen) DataAdd(value byte) {
+	g.emit("data[datap] += %d;", value)
+}
+
+func (g *cGen) Read() {
+	g.emit("readb();")
+}
+
+func (g *cGen) Write(repeat int64) {
+	g.emit("writeb(%d);", repeat)
+}
+
+func (g *cGen) DataAddVector(vec []byte, unchecked bool) {
+	g.emit("dataaddvector%s(%s);", suffix(unchecked), cVector(vec))
+}
+
+func (g *cGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	g.emit("dataaddlvector%s(%s, %d);", suffix(unchecked), cVector(vec), offset)
+}
+
+func (g *cGen) DataSet(value byte) {
+	g.emit("data[datap] = %d;", value)
+}
+
+func (g *cGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrCUnsupported
+	}
+	g.depth = 1
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainC, "\n")), TemplateParams{
+		InitialDataSize: initialDataSize(bounds),
+		Unbuffered:      opts.Unbuffered,
+	})
+}
+
+func (g *cGen) Epilogue() error {
+	return g.finish()
+}
+
+func (g *cGen) Build(infile, outfile string, opts CompileOptions) error {
+	return CompileC(infile, outfile, opts)
+}
+
+// ILBlockToC writes a C99 program equivalent to b to output.
+// Profiling and generating a function are not supported.
+func ILBlockToC(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(cGen), opts)
+}
+
+// CompileC compiles the C file infile to the binary outfile, using the
+// compiler named by the CC environment variable or cc.
+func CompileC(infile, outfile string, opts CompileOptions) error {
+	if err := opts.hostOnly(); err != nil {
+		return err
+	}
+	cc := ccCommand()
+	var args = []string{"-O2", "-std=c99"}
+	args = append(args, ccFlags(infile, opts)...)
+	args = append(args, "-o", outfile, infile)
+
+	ccbuild := exec.Command(cc, args...)
+	ccbuild.Stdout = os.Stderr
+	ccbuild.Stderr = os.Stderr
+	if err := ccbuild.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build binary from C: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// ccCommand returns the C compiler named by the CC environment variable,
+// or cc.
+func ccCommand() string {
+	if cc := os.Getenv("CC"); cc != "" {
+		return cc
+	}
+	return "cc"
+}
+
+// ccFlags returns the C compiler flags for building infile with opts.
+func ccFlags(infile string, opts CompileOptions) []string {
+	var args []string
+	if opts.Debug {
+		args = append(args, "-g")
+	}
+	if opts.TrimPath {
+		args = append(args, "-ffile-prefix-map="+filepath.Dir(infile)+"=.")
+	}
+	if opts.Strip {
+		args = append(args, "-s")
+	}
+	if opts.Static {
+		args = append(args, "-static")
+	}
+	return args
+}
+
+// CompileILC is CompileIL, using the C backend.
+func CompileILC(b *il.ILBlock, outfile string, copts CompileOptions, opts GenOptions) (error, string) {
+	return CompileBackend("c", b, outfile, copts, opts)
+}
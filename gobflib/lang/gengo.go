@@ -2,130 +2,344 @@
 package lang
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"text/template"
+	"sort"
+	"strings"
 
 	"github.com/linux4life798/gobf/gobflib/il"
 )
 
 const (
 	DefaultDataSize = 100000
+	DefaultFuncName = "Run"
+
+	// CellWidth is the number of bits in a cell. Cells wrap around.
+	CellWidth = 8
+	// EOFUnchanged is the EOF mode where reading at the end of input
+	// leaves the current cell unchanged.
+	EOFUnchanged = "unchanged"
 )
 
+var ErrFuncProfile = errors.New("Error: Profiling is not supported when generating a function")
+
+// TemplateParams are the parameters of a program template, see
+// GenOptions.Template.
+//
+// A template is a text/template, which must write each line of Body,
+// with {{ range .Body }}{{ . }}\n{{ end }}, where the program runs.
+// The body uses helpers that the template defines, see GoHelpers for the
+// Go backend.
 type TemplateParams struct {
-	InitialDataSize  int
-	Body             <-chan string
+	// InitialDataSize is the initial number of cells in the tape, which
+	// holds every cell that the program is proven to touch
+	InitialDataSize int
+	// Body is the program, one statement per line
+	Body <-chan string
+	// ProfilingEnabled selects counting the operations of the program
 	ProfilingEnabled bool
+	// Unbuffered selects writing output bytes immediately
+	Unbuffered bool
+	// CellWidth is the number of bits in a cell, which is always 8
+	CellWidth int
+	// EOFMode is what reading at the end of input does, which is always
+	// EOFUnchanged
+	EOFMode string
+
+	// Package, Func, and State name the package, the function, and the
+	// function's state struct, when generating a function
+	Package string
+	Func    string
+	State   string
+
+	// WASI selects WASI imports, TapeBase is the address of the first
+	// cell, and MemoryPages is the initial memory size, for WebAssembly
+	WASI        bool
+	TapeBase    int
+	MemoryPages int
+
+	// TapeSize is the size of the tape reserved by the assembly backend
+	TapeSize int
 }
 
-func ilBlockGo(b *il.ILBlock, cout chan<- string) {
-	if b == nil {
-		cout <- ""
-		return
+// GoHelpers are the helpers that the body of a Go program calls, by the
+// IL operation that uses them. The helpers ending in u may skip the data
+// pointer and tape checks. The body also uses the tape data []byte and the
+// data pointer datap int directly.
+// When generating a function, they are members of the State struct.
+var GoHelpers = []string{
+	"datapadd(delta int)",
+	"datapaddu(delta int)",
+	"dataadd(delta byte)",
+	"dataset(value byte)",
+	"dataaddvector(vec []byte)",
+	"dataaddvectoru(vec []byte)",
+	"dataaddlvector(vec []byte, offset int)",
+	"dataaddlvectoru(vec []byte, offset int)",
+	"datagrow(hi int)",
+	"readb()",
+	"writeb(repeat int)",
+}
+
+// GenOptions controls the features of a generated program.
+type GenOptions struct {
+	// Profile enables self profiling, which slows down the program.
+	Profile bool
+	// Unbuffered writes output bytes immediately, instead of when input
+	// is read or the program exits. This is useful for interactive programs.
+	Unbuffered bool
+
+	// Package and Func select generating a reusable function
+	// func Func(in io.Reader, out io.Writer) error in package Package,
+	// instead of a main package. If only one is set, the other defaults
+	// to main or DefaultFuncName.
+	Package string
+	Func    string
+
+	// WASI selects the WASI imports, instead of env.read and env.write,
+	// for WebAssembly.
+	WASI bool
+
+	// Template replaces the backend's builtin program template, see
+	// TemplateParams. The Go backend checks that the program defines the
+	// helpers its body calls.
+	Template string
+}
+
+// goGen emits the Go statements for a program body.
+type goGen struct {
+	templateBody
+	src    bytes.Buffer
+	output io.Writer
+	// recv prefixes the tape state and helpers, like "s." when they are
+	// members of a state struct instead of globals
+	recv string
+	// used holds the helpers called by the body, to check user templates
+	used     map[string]bool
+	validate bool
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "go",
+		Description: "Go, built with the go tool",
+		SourceName:  "main.go",
+		New:         func() Backend { return new(goGen) },
+		Available:   func() error { return lookPaths("go") },
+	})
+}
+
+func (g *goGen) emit(format string, a ...interface{}) {
+	g.cout <- fmt.Sprintf(format, a...)
+}
+
+// call emits calling helper with the arguments args.
+func (g *goGen) call(helper string, args string) {
+	if g.used == nil {
+		g.used = make(map[string]bool)
 	}
+	g.used[helper] = true
+	g.emit("%s%s(%s)", g.recv, helper, args)
+}
 
-	switch b.GetType() {
-	case il.ILList:
-		for _, ib := range b.GetInner() {
-			ilBlockGo(ib, cout)
-		}
-	case il.ILLoop:
-		cout <- "for data[datap] != 0 {"
-		for _, ib := range b.GetInner() {
-			ilBlockGo(ib, cout)
-		}
-		cout <- "}"
-	case il.ILDataPtrAdd:
-		cout <- fmt.Sprintf("datapadd(%d)", b.GetParam())
-	case il.ILDataAdd:
-		cout <- fmt.Sprintf("dataadd(%v)", byte(b.GetParam()))
-	case il.ILRead:
-		for i := int64(0); i < b.GetParam(); i++ {
-			cout <- "readb()"
-		}
-	case il.ILWrite:
-		cout <- fmt.Sprintf("writeb(%v)", b.GetParam())
-	case il.ILDataAddVector:
-		cout <- fmt.Sprintf("dataaddvector(%#v)", b.GetVector())
-	case il.ILDataAddLinVector:
-		cout <- fmt.Sprintf("dataaddlvector(%#v, %v)", b.GetVector(), b.GetParam())
-	case il.ILDataSet:
-		cout <- fmt.Sprintf("dataset(%d)", byte(b.GetParam()))
-	default:
-		panic("Encountered an unknown ILBlock type.")
+// suffix returns the suffix of the helpers that skip checks.
+func suffix(unchecked bool) string {
+	if unchecked {
+		return "u"
 	}
+	return ""
 }
 
-func ILBlockToGo(b *il.ILBlock, output io.Writer, profileenabled bool) error {
-	var useGoFmt bool = true
-	var err error
+func (g *goGen) Loop(b *il.ILBlock, body func()) {
+	g.emit("for %sdata[%sdatap] != 0 {", g.recv, g.recv)
+	body()
+	g.emit("}")
+}
 
-	_, err = exec.LookPath("gofmt")
-	if err != nil {
-		useGoFmt = false
+func (g *goGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.call("datagrow", fmt.Sprint(hi))
+		fast()
+		return
 	}
+	g.emit("if %sdatap >= %d {", g.recv, -lo)
+	g.call("datagrow", fmt.Sprint(hi))
+	fast()
+	g.emit("} else {")
+	slow()
+	g.emit("}")
+}
 
-	var c = make(chan string, 1024)
-	go func() {
-		ilBlockGo(b, c)
-		close(c)
-	}()
+func (g *goGen) DataPtrAdd(delta int64, unchecked bool) {
+	g.call("datapadd"+suffix(unchecked), fmt.Sprint(delta))
+}
 
-	var params = TemplateParams{
-		InitialDataSize:  DefaultDataSize,
-		Body:             c,
-		ProfilingEnabled: profileenabled,
-	}
-	t := template.Must(template.New("main").Parse(templateConstMain))
-
-tryagain:
-	if useGoFmt {
-		gofmt := exec.Command("gofmt")
-		pinput, err := gofmt.StdinPipe()
-		if err != nil {
-			pinput.Close()
-			useGoFmt = false
-			goto tryagain
+func (g *goGen) DataAdd(value byte) {
+	g.call("dataadd", fmt.Sprint(value))
+}
+
+func (g *goGen) Read() {
+	g.call("readb", "")
+}
+
+func (g *goGen) Write(repeat int64) {
+	g.call("writeb", fmt.Sprint(repeat))
+}
+
+func (g *goGen) DataAddVector(vec []byte, unchecked bool) {
+	g.call("dataaddvector"+suffix(unchecked), fmt.Sprintf("%#v", vec))
+}
+
+func (g *goGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	g.call("dataaddlvector"+suffix(unchecked), fmt.Sprintf("%#v, %v", vec, offset))
+}
+
+func (g *goGen) DataSet(value byte) {
+	g.call("dataset", fmt.Sprint(value))
+}
+
+func (g *goGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	tmpl := templateConstMain
+	if opts.Package != "" || opts.Func != "" {
+		if opts.Profile {
+			return ErrFuncProfile
 		}
-		gofmt.Stdout = output
-		err = gofmt.Start()
-		if err != nil {
-			pinput.Close()
-			return err
+		if opts.Package == "" {
+			opts.Package = "main"
+		}
+		if opts.Func == "" {
+			opts.Func = DefaultFuncName
 		}
-		err = t.Execute(pinput, params)
-		if err != nil {
+		if !token.IsIdentifier(opts.Package) || !token.IsIdentifier(opts.Func) {
+			return fmt.Errorf("Invalid package or function name \"%s.%s\"", opts.Package, opts.Func)
+		}
+		tmpl, g.recv = templateConstFunc, "s."
+	}
+
+	var params = TemplateParams{
+		InitialDataSize:  initialDataSize(bounds),
+		ProfilingEnabled: opts.Profile,
+		Unbuffered:       opts.Unbuffered,
+		Package:          opts.Package,
+		Func:             opts.Func,
+	}
+	if opts.Func != "" {
+		params.State = strings.ToLower(opts.Func[:1]) + opts.Func[1:] + "State"
+	}
+	g.output = output
+	g.validate = opts.Template != ""
+	return g.start(&g.src, templateText(opts, tmpl), params)
+}
+
+// Epilogue formats the program like gofmt, before writing it out.
+func (g *goGen) Epilogue() error {
+	if err := g.finish(); err != nil {
+		return err
+	}
+	if g.validate {
+		if err := checkGoHelpers(g.src.Bytes(), g.used); err != nil {
 			return err
 		}
-		err = pinput.Close()
-		if err != nil {
-			useGoFmt = false
-			goto tryagain
+	}
+	src, err := format.Source(g.src.Bytes())
+	if err != nil {
+		// Still write out the program, to see what went wrong
+		src = g.src.Bytes()
+	}
+	_, err = g.output.Write(src)
+	return err
+}
+
+// checkGoHelpers returns an error if the Go program src does not parse,
+// or does not define the helpers in used.
+func checkGoHelpers(src []byte, used map[string]bool) error {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", src, 0)
+	if err != nil {
+		return fmt.Errorf("Template does not generate valid Go: %v", err)
+	}
+	defined := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			defined[fn.Name.Name] = true
 		}
-		err = gofmt.Wait()
-		if err != nil {
-			useGoFmt = false
-			goto tryagain
+	}
+	var missing []string
+	for helper := range used {
+		if !defined[helper] {
+			missing = append(missing, helper)
 		}
-	} else {
-		err = t.Execute(output, params)
 	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Template is missing the helper functions %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
 
-	return err
+func (g *goGen) Build(infile, outfile string, opts CompileOptions) error {
+	return CompileGo(infile, outfile, opts)
+}
+
+func ILBlockToGo(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(goGen), opts)
+}
+
+// goEnv returns the environment of the go tool for building with opts.
+func goEnv(opts CompileOptions) []string {
+	env := os.Environ()
+	if opts.GOOS != "" {
+		env = append(env, "GOOS="+opts.GOOS)
+	}
+	if opts.GOARCH != "" {
+		env = append(env, "GOARCH="+opts.GOARCH)
+	}
+	if opts.Static && !opts.GccGo {
+		// The program doesn't use cgo, but the standard library may
+		env = append(env, "CGO_ENABLED=0")
+	}
+	return env
 }
 
-func CompileGo(infile, outfile string, debugenabled bool, gccgo bool) error {
+// CompileGo builds the Go file infile into the binary outfile with the go
+// tool.
+func CompileGo(infile, outfile string, opts CompileOptions) error {
 	var args = []string{"build"}
-	if gccgo {
+	var ldflags, gccgoflags []string
+	if opts.GccGo {
 		args = append(args, "-compiler", "gccgo")
 	}
+	if opts.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	if opts.Strip {
+		if opts.GccGo {
+			gccgoflags = append(gccgoflags, "-s")
+		} else {
+			ldflags = append(ldflags, "-s", "-w")
+		}
+	}
+	if opts.Static && opts.GccGo {
+		gccgoflags = append(gccgoflags, "-static")
+	}
+	if len(ldflags) > 0 {
+		args = append(args, "-ldflags", strings.Join(ldflags, " "))
+	}
+	if len(gccgoflags) > 0 {
+		args = append(args, "-gccgoflags", strings.Join(gccgoflags, " "))
+	}
 	args = append(args, "-o", outfile, infile)
 
 	gobuild := exec.Command("go", args...)
+	gobuild.Env = goEnv(opts)
 	gobuild.Stdout = os.Stderr
 	gobuild.Stderr = os.Stderr
 	if err := gobuild.Start(); err != nil {
@@ -141,30 +355,62 @@ func CompileGo(infile, outfile string, debugenabled bool, gccgo bool) error {
 
 // If err is non-nil, the tempdir is preserved and returned
 // with the error
-func CompileIL(b *il.ILBlock, outfile string, debugenabled, profileenabled bool) (error, string) {
-	// Create temp directory for generated Go /tmp/gobfcompile########
+func CompileIL(b *il.ILBlock, outfile string, copts CompileOptions, opts GenOptions) (error, string) {
+	return CompileBackend("go", b, outfile, copts, opts)
+}
+
+// compileIL generates the source file srcname in a temp directory with
+// gen and builds it with build, using the backend name.
+func compileIL(outfile string, copts CompileOptions, name, srcname string,
+	gen func(output io.Writer) error, build func(infile, outfile string) error) (error, string) {
+	keepsource := copts.Debug || copts.KeepSource
+
+	// Create temp directory for generated source /tmp/gobfcompile########
 	tempdir, err := ioutil.TempDir("", "gobfcompile")
 	if err != nil {
 		return fmt.Errorf("Failed to create temp dir: %v", err), tempdir
 	}
 
-	// Create temp /tmp/gobfcompile########/main.go file
-	gofile, err := os.Create(tempdir + "/main.go")
+	// Create temp /tmp/gobfcompile########/srcname file
+	srcfile, err := os.Create(tempdir + "/" + srcname)
 	if err != nil {
 		return fmt.Errorf("Failed to create temp file: %v", err), tempdir
 	}
 
-	// Generate the Go code
-	if err := ILBlockToGo(b, gofile, profileenabled); err != nil {
-		return fmt.Errorf("Failed to generate Go: %v", err), tempdir
+	// Generate the source code
+	var src bytes.Buffer
+	err = gen(io.MultiWriter(srcfile, &src))
+	srcfile.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to generate source: %v", err), tempdir
 	}
 
-	// Compile the Go code to binary
-	if err := CompileGo(gofile.Name(), outfile, debugenabled, false); err != nil {
-		return fmt.Errorf("Failed to compile generated Go: %v", err), tempdir
+	// Reuse the binary if it was built before. The cache is skipped
+	// when keeping the source, since there would be no build to keep.
+	var key string
+	binfile := outfile
+	if copts.Cache != nil && !keepsource {
+		key = copts.Cache.key(name, src.Bytes(), copts)
+		if err := copts.Cache.get(key, outfile); err == nil {
+			return os.RemoveAll(tempdir), tempdir
+		}
+		binfile = tempdir + "/gobfbin"
+	}
+
+	// Compile the source code to binary
+	if err := build(srcfile.Name(), binfile); err != nil {
+		return fmt.Errorf("Failed to compile generated source: %v", err), tempdir
+	}
+
+	if key != "" {
+		if err := copyBinary(binfile, outfile); err != nil {
+			return fmt.Errorf("Failed to copy binary: %v", err), tempdir
+		}
+		// The binary is built, so failing to cache it is not an error
+		copts.Cache.put(key, binfile)
 	}
 
-	if !debugenabled {
+	if !keepsource {
 		// Remove the temp directory if everything succeeded
 		if err := os.RemoveAll(tempdir); err != nil {
 			return err, tempdir
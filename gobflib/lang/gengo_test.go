@@ -0,0 +1,52 @@
+package lang
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+func TestGoTemplate(t *testing.T) {
+	b := parseBF(",[->+<]>.")
+
+	var builtin, custom bytes.Buffer
+	if err := ILBlockToGo(b, &builtin, GenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	opts := GenOptions{Template: templateConstMain}
+	if err := ILBlockToGo(b, &custom, opts); err != nil {
+		t.Fatalf("ILBlockToGo failed with the builtin template as a user template: %v", err)
+	}
+	if builtin.String() != custom.String() {
+		t.Error("ILBlockToGo generated a different program with the builtin template as a user template")
+	}
+
+	// Only the helpers that the body calls are needed
+	i := strings.Index(templateConstMain, "func dataset(")
+	j := i + strings.Index(templateConstMain[i:], "}\n") + 2
+	opts.Template = templateConstMain[:i] + templateConstMain[j:]
+	if err := ILBlockToGo(b, new(bytes.Buffer), opts); err != nil {
+		t.Errorf("ILBlockToGo failed for a template without an unused helper: %v", err)
+	}
+	b = parseBF(",[-].")
+	b.PatternReplace(il.PatternReplaceZero)
+	err := ILBlockToGo(b, new(bytes.Buffer), opts)
+	if err == nil || !strings.Contains(err.Error(), "dataset") {
+		t.Errorf("ILBlockToGo returned %v for a template without dataset", err)
+	}
+}
+
+func TestGoTemplateInvalid(t *testing.T) {
+	for _, tmpl := range []string{
+		"{{ .Body",
+		"{{ .Missing }}",
+		"package main\n{{ range .Body }}{{ . }}\n{{ end }}",
+	} {
+		err := ILBlockToGo(parseBF("+."), new(bytes.Buffer), GenOptions{Template: tmpl})
+		if err == nil {
+			t.Errorf("ILBlockToGo did not fail for the template %q", tmpl)
+		}
+	}
+}
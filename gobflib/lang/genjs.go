@@ -0,0 +1,148 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+var ErrJSUnsupported = errors.New("Error: Profiling and generating a function are not supported by the JavaScript backend")
+
+// cellIndex returns the index of the cell at offset from the data pointer
+// p, like "p + 2", for the JavaScript and Python backends.
+func cellIndex(offset int64) string {
+	switch {
+	case offset > 0:
+		return fmt.Sprintf("p + %d", offset)
+	case offset < 0:
+		return fmt.Sprintf("p - %d", -offset)
+	}
+	return "p"
+}
+
+// jsGen emits the JavaScript statements for a program body.
+type jsGen struct {
+	templateBody
+	depth int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "js",
+		Description: "JavaScript for Node and browsers",
+		SourceName:  "main.js",
+		New:         func() Backend { return new(jsGen) },
+		Interpreter: "node",
+	})
+}
+
+func (g *jsGen) emit(format string, a ...interface{}) {
+	g.cout <- strings.Repeat("\t", g.depth) + fmt.Sprintf(format, a...)
+}
+
+func (g *jsGen) Loop(b *il.ILBlock, body func()) {
+	g.emit("while (data[p]) {")
+	g.depth++
+	body()
+	g.depth--
+	g.emit("}")
+}
+
+func (g *jsGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.emit("grow(%d);", hi)
+		fast()
+		return
+	}
+	g.emit("if (p >= %d) {", -lo)
+	g.depth++
+	g.emit("grow(%d);", hi)
+	fast()
+	g.depth--
+	g.emit("} else {")
+	g.depth++
+	slow()
+	g.depth--
+	g.emit("}")
+}
+
+func (g *jsGen) DataPtrAdd(delta int64, unchecked bool) {
+	g.emit("p += %d;", delta)
+	if !unchecked {
+		g.emit("check(0, 0);")
+	}
+}
+
+func (g *jsGen) DataAdd(value byte) {
+	g.emit("data[p] += %d;", value)
+}
+
+func (g *jsGen) Read() {
+	g.emit("readb();")
+}
+
+func (g *jsGen) Write(repeat int64) {
+	g.emit("writeb(%d);", repeat)
+}
+
+func (g *jsGen) DataAddVector(vec []byte, unchecked bool) {
+	if !unchecked {
+		g.emit("grow(%d);", len(vec)-1)
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.emit("data[%s] += %d;", cellIndex(int64(i)), v)
+		}
+	}
+}
+
+func (g *jsGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	g.emit("m = data[p];")
+	g.emit("if (m) {")
+	g.depth++
+	if !unchecked {
+		g.emit("check(%d, %d);", offset, offset+int64(len(vec))-1)
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.emit("data[%s] += m * %d;", cellIndex(offset+int64(i)), v)
+		}
+	}
+	g.depth--
+	g.emit("}")
+}
+
+func (g *jsGen) DataSet(value byte) {
+	g.emit("data[p] = %d;", value)
+}
+
+func (g *jsGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrJSUnsupported
+	}
+	g.depth = 1
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainJs, "\n")), TemplateParams{
+		InitialDataSize: initialDataSize(bounds),
+		Unbuffered:      opts.Unbuffered,
+	})
+}
+
+func (g *jsGen) Epilogue() error {
+	return g.finish()
+}
+
+func (g *jsGen) Build(infile, outfile string, opts CompileOptions) error {
+	return ErrNoBuild
+}
+
+// ILBlockToJS writes a JavaScript program equivalent to b to output.
+// The tape is a Uint8Array that grows as needed. The program runs with
+// standard input and output in Node, and exports a run(read, write)
+// function for browsers and other modules.
+// Profiling and generating a function are not supported.
+func ILBlockToJS(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(jsGen), opts)
+}
@@ -0,0 +1,266 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+var ErrLLVMUnsupported = errors.New("Error: Profiling and generating a function are not supported by the LLVM backend")
+
+// llvmGen emits the LLVM IR instructions for a program body.
+// The data pointer is kept in the stack slot %pp, which the LLVM
+// optimizer turns into a register.
+type llvmGen struct {
+	templateBody
+	tmps   int
+	labels int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "llvm",
+		Description: "LLVM IR, built with clang, or llc and the C compiler",
+		SourceName:  "main.ll",
+		New:         func() Backend { return new(llvmGen) },
+		Available:   llvmAvailable,
+	})
+}
+
+func (g *llvmGen) emit(format string, a ...interface{}) {
+	g.cout <- "  " + fmt.Sprintf(format, a...)
+}
+
+// value emits an instruction that defines a new value, and returns the
+// value's name.
+func (g *llvmGen) value(format string, a ...interface{}) string {
+	g.tmps++
+	name := fmt.Sprintf("%%t%d", g.tmps)
+	g.emit("%s = %s", name, fmt.Sprintf(format, a...))
+	return name
+}
+
+func (g *llvmGen) label(name string) {
+	g.cout <- name + ":"
+}
+
+func (g *llvmGen) newLabel() int {
+	g.labels++
+	return g.labels
+}
+
+// ptr emits loading the data pointer.
+func (g *llvmGen) ptr() string {
+	return g.value("load i64, ptr %%pp")
+}
+
+// cell emits computing the address of the cell at offset from the data
+// pointer p.
+func (g *llvmGen) cell(p string, offset int64) string {
+	data := g.value("load ptr, ptr @data")
+	if offset != 0 {
+		p = g.value("add i64 %s, %d", p, offset)
+	}
+	return g.value("getelementptr i8, ptr %s, i64 %s", data, p)
+}
+
+// cellAdd emits adding the i8 value to the cell at offset from p.
+func (g *llvmGen) cellAdd(p string, offset int64, value string) {
+	a := g.cell(p, offset)
+	v := g.value("load i8, ptr %s", a)
+	sum := g.value("add i8 %s, %s", v, value)
+	g.emit("store i8 %s, ptr %s", sum, a)
+}
+
+func (g *llvmGen) Loop(b *il.ILBlock, body func()) {
+	n := g.newLabel()
+	g.emit("br label %%cond%d", n)
+	g.label(fmt.Sprintf("cond%d", n))
+	v := g.value("load i8, ptr %s", g.cell(g.ptr(), 0))
+	nz := g.value("icmp ne i8 %s, 0", v)
+	g.emit("br i1 %s, label %%body%d, label %%end%d", nz, n, n)
+	g.label(fmt.Sprintf("body%d", n))
+	body()
+	g.emit("br label %%cond%d", n)
+	g.label(fmt.Sprintf("end%d", n))
+}
+
+func (g *llvmGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.emit("call void @datagrow(i64 %s, i64 %d)", g.ptr(), hi)
+		fast()
+		return
+	}
+	n := g.newLabel()
+	fits := g.value("icmp sge i64 %s, %d", g.ptr(), -lo)
+	g.emit("br i1 %s, label %%fast%d, label %%slow%d", fits, n, n)
+	g.label(fmt.Sprintf("fast%d", n))
+	g.emit("call void @datagrow(i64 %s, i64 %d)", g.ptr(), hi)
+	fast()
+	g.emit("br label %%join%d", n)
+	g.label(fmt.Sprintf("slow%d", n))
+	slow()
+	g.emit("br label %%join%d", n)
+	g.label(fmt.Sprintf("join%d", n))
+}
+
+func (g *llvmGen) DataPtrAdd(delta int64, unchecked bool) {
+	p := g.value("add i64 %s, %d", g.ptr(), delta)
+	g.emit("store i64 %s, ptr %%pp", p)
+	if !unchecked {
+		g.emit("call void @datacheck(i64 %s, i64 0, i64 0)", p)
+	}
+}
+
+func (g *llvmGen) DataAdd(value byte) {
+	g.cellAdd(g.ptr(), 0, strconv.Itoa(int(value)))
+}
+
+func (g *llvmGen) Read() {
+	g.emit("call void @readb(ptr %s)", g.cell(g.ptr(), 0))
+}
+
+func (g *llvmGen) Write(repeat int64) {
+	g.emit("call void @writeb(ptr %s, i64 %d)", g.cell(g.ptr(), 0), repeat)
+}
+
+func (g *llvmGen) DataAddVector(vec []byte, unchecked bool) {
+	p := g.ptr()
+	if !unchecked {
+		g.emit("call void @datagrow(i64 %s, i64 %d)", p, len(vec)-1)
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.cellAdd(p, int64(i), strconv.Itoa(int(v)))
+		}
+	}
+}
+
+func (g *llvmGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	n := g.newLabel()
+	p := g.ptr()
+	m := g.value("load i8, ptr %s", g.cell(p, 0))
+	zero := g.value("icmp eq i8 %s, 0", m)
+	g.emit("br i1 %s, label %%skip%d, label %%lvec%d", zero, n, n)
+	g.label(fmt.Sprintf("lvec%d", n))
+	if !unchecked {
+		g.emit("call void @datacheck(i64 %s, i64 %d, i64 %d)", p, offset, offset+int64(len(vec))-1)
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.cellAdd(p, offset+int64(i), g.value("mul i8 %s, %d", m, v))
+		}
+	}
+	g.emit("br label %%skip%d", n)
+	g.label(fmt.Sprintf("skip%d", n))
+}
+
+func (g *llvmGen) DataSet(value byte) {
+	g.emit("store i8 %d, ptr %s", value, g.cell(g.ptr(), 0))
+}
+
+func (g *llvmGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrLLVMUnsupported
+	}
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainLl, "\n")), TemplateParams{
+		InitialDataSize: initialDataSize(bounds),
+		Unbuffered:      opts.Unbuffered,
+	})
+}
+
+func (g *llvmGen) Epilogue() error {
+	return g.finish()
+}
+
+func (g *llvmGen) Build(infile, outfile string, opts CompileOptions) error {
+	return CompileLLVM(infile, outfile, opts)
+}
+
+// ILBlockToLLVM writes an LLVM IR module equivalent to b to output.
+// Loops are basic blocks, the tape is a heap buffer that grows as needed,
+// and I/O uses getchar and putchar from libc.
+// Profiling and generating a function are not supported.
+func ILBlockToLLVM(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(llvmGen), opts)
+}
+
+var llvmVersion = regexp.MustCompile(`version (\d+)\.`)
+
+// llvmOpaquePointers returns the flags that make the LLVM tool enable
+// opaque pointers, which are only the default since LLVM 15, and were
+// made the only choice in LLVM 17.
+func llvmOpaquePointers(tool string, flag ...string) []string {
+	out, err := exec.Command(tool, "--version").Output()
+	if err != nil {
+		return nil
+	}
+	m := llvmVersion.FindSubmatch(out)
+	if m == nil {
+		return nil
+	}
+	if major, _ := strconv.Atoi(string(m[1])); major >= 15 {
+		return nil
+	}
+	return flag
+}
+
+// llvmAvailable returns an error if neither clang nor llc and the C
+// compiler are available.
+func llvmAvailable() error {
+	if lookPaths("clang") == nil {
+		return nil
+	}
+	return lookPaths("llc", ccCommand())
+}
+
+// CompileLLVM compiles the LLVM IR file infile to the binary outfile with
+// clang. If clang is not available, it is compiled with llc and linked
+// with the C compiler named by the CC environment variable or cc.
+func CompileLLVM(infile, outfile string, opts CompileOptions) error {
+	if err := opts.hostOnly(); err != nil {
+		return err
+	}
+	var cmds [][]string
+	if _, err := exec.LookPath("clang"); err == nil {
+		args := []string{"clang", "-O2", "-Wno-override-module"}
+		args = append(args, llvmOpaquePointers("clang", "-Xclang", "-opaque-pointers")...)
+		args = append(args, ccFlags(infile, opts)...)
+		cmds = append(cmds, append(args, "-o", outfile, infile))
+	} else if _, err := exec.LookPath("llc"); err == nil {
+		cc := ccCommand()
+		objfile := strings.TrimSuffix(infile, ".ll") + ".o"
+		llc := []string{"llc", "-O2", "-filetype=obj", "-relocation-model=pic"}
+		llc = append(llc, llvmOpaquePointers("llc", "-opaque-pointers")...)
+		cmds = append(cmds, append(llc, "-o", objfile, infile))
+		link := append([]string{cc}, ccFlags(infile, opts)...)
+		cmds = append(cmds, append(link, "-o", outfile, objfile))
+	} else {
+		err := errors.New("clang is not available")
+		fmt.Fprintf(os.Stderr, "Failed to build binary from LLVM IR: %v\n", err)
+		return err
+	}
+
+	for _, c := range cmds {
+		build := exec.Command(c[0], c[1:]...)
+		build.Stdout = os.Stderr
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to build binary from LLVM IR: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// CompileILLLVM is CompileIL, using the LLVM backend.
+func CompileILLLVM(b *il.ILBlock, outfile string, copts CompileOptions, opts GenOptions) (error, string) {
+	return CompileBackend("llvm", b, outfile, copts, opts)
+}
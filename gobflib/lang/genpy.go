@@ -0,0 +1,148 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+var ErrPythonUnsupported = errors.New("Error: Profiling and generating a function are not supported by the Python backend")
+
+// pyGen emits the Python statements for a program body.
+type pyGen struct {
+	templateBody
+	depth int
+	lines int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "python",
+		Description: "Python 3",
+		SourceName:  "main.py",
+		New:         func() Backend { return new(pyGen) },
+		Interpreter: "python3",
+	})
+}
+
+func (g *pyGen) emit(format string, a ...interface{}) {
+	g.cout <- strings.Repeat("    ", g.depth) + fmt.Sprintf(format, a...)
+	g.lines++
+}
+
+// indented emits body one level deeper, with a pass if it is empty.
+func (g *pyGen) indented(body func()) {
+	g.depth++
+	lines := g.lines
+	body()
+	if g.lines == lines {
+		g.emit("pass")
+	}
+	g.depth--
+}
+
+// cellAdd emits adding the expression value to the cell at offset.
+func (g *pyGen) cellAdd(offset int64, value string) {
+	i := cellIndex(offset)
+	g.emit("data[%s] = (data[%s] + %s) & 255", i, i, value)
+}
+
+func (g *pyGen) Loop(b *il.ILBlock, body func()) {
+	g.emit("while data[p]:")
+	g.indented(body)
+}
+
+func (g *pyGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.emit("grow(data, %s)", cellIndex(hi))
+		fast()
+		return
+	}
+	g.emit("if p >= %d:", -lo)
+	g.indented(func() {
+		g.emit("grow(data, %s)", cellIndex(hi))
+		fast()
+	})
+	g.emit("else:")
+	g.indented(slow)
+}
+
+func (g *pyGen) DataPtrAdd(delta int64, unchecked bool) {
+	g.emit("p += %d", delta)
+	if !unchecked {
+		g.emit("check(data, p, p)")
+	}
+}
+
+func (g *pyGen) DataAdd(value byte) {
+	g.cellAdd(0, fmt.Sprint(value))
+}
+
+func (g *pyGen) Read() {
+	g.emit("readb(data, p, read)")
+}
+
+func (g *pyGen) Write(repeat int64) {
+	g.emit("write(data[p:p + 1] * %d)", repeat)
+}
+
+func (g *pyGen) DataAddVector(vec []byte, unchecked bool) {
+	if !unchecked {
+		g.emit("grow(data, %s)", cellIndex(int64(len(vec))-1))
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.cellAdd(int64(i), fmt.Sprint(v))
+		}
+	}
+}
+
+func (g *pyGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	g.emit("m = data[p]")
+	g.emit("if m:")
+	g.indented(func() {
+		if !unchecked {
+			g.emit("check(data, %s, %s)", cellIndex(offset), cellIndex(offset+int64(len(vec))-1))
+		}
+		for i, v := range vec {
+			if v != 0 {
+				g.cellAdd(offset+int64(i), fmt.Sprintf("m * %d", v))
+			}
+		}
+	})
+}
+
+func (g *pyGen) DataSet(value byte) {
+	g.emit("data[p] = %d", value)
+}
+
+func (g *pyGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrPythonUnsupported
+	}
+	g.depth = 1
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainPy, "\n")), TemplateParams{
+		InitialDataSize: initialDataSize(bounds),
+		Unbuffered:      opts.Unbuffered,
+	})
+}
+
+func (g *pyGen) Epilogue() error {
+	return g.finish()
+}
+
+func (g *pyGen) Build(infile, outfile string, opts CompileOptions) error {
+	return ErrNoBuild
+}
+
+// ILBlockToPython writes a Python 3 program equivalent to b to output.
+// The tape is a bytearray that grows as needed. The program runs with
+// standard input and output as a script, and has a run(read, write)
+// function for use as a module.
+// Profiling and generating a function are not supported.
+func ILBlockToPython(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(pyGen), opts)
+}
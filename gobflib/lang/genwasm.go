@@ -0,0 +1,190 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+const (
+	// WATTapeBase is the address of the first tape cell in linear memory.
+	// The addresses before it are scratch space for WASI I/O vectors.
+	WATTapeBase = 16
+
+	watPageSize = 65536
+)
+
+var ErrWATUnsupported = errors.New("Error: Profiling and generating a function are not supported by the WebAssembly backend")
+
+// watGen emits the WebAssembly text instructions for a program body.
+type watGen struct {
+	templateBody
+	depth  int
+	labels int
+}
+
+func init() {
+	RegisterBackend(BackendInfo{
+		Name:        "wasm",
+		Description: "WebAssembly text, for browsers or WASI with --wasi",
+		SourceName:  "main.wat",
+		New:         func() Backend { return new(watGen) },
+		Available:   func() error { return ErrNoBuild },
+	})
+}
+
+func (g *watGen) emit(format string, a ...interface{}) {
+	g.cout <- strings.Repeat("  ", g.depth) + fmt.Sprintf(format, a...)
+}
+
+// watAddr returns the memory argument and address operand for the cell at
+// offset from the data pointer, like "offset=2 (local.get $p)".
+func watAddr(offset int64) string {
+	switch {
+	case offset > 0:
+		return fmt.Sprintf("offset=%d (local.get $p)", offset)
+	case offset < 0:
+		return fmt.Sprintf("(i32.sub (local.get $p) (i32.const %d))", -offset)
+	}
+	return "(local.get $p)"
+}
+
+// watPtr returns the address of the cell at offset from the data pointer.
+func watPtr(offset int64) string {
+	if offset == 0 {
+		return "(local.get $p)"
+	}
+	return fmt.Sprintf("(i32.add (local.get $p) (i32.const %d))", offset)
+}
+
+// cellAdd emits adding value to the cell at offset from the data pointer.
+func (g *watGen) cellAdd(offset int64, value string) {
+	a := watAddr(offset)
+	g.emit("(i32.store8 %s (i32.add (i32.load8_u %s) %s))", a, a, value)
+}
+
+func (g *watGen) Loop(b *il.ILBlock, body func()) {
+	g.labels++
+	n := g.labels
+	g.emit("(block $b%d", n)
+	g.depth++
+	g.emit("(loop $l%d", n)
+	g.depth++
+	g.emit("(br_if $b%d (i32.eqz (i32.load8_u (local.get $p))))", n)
+	body()
+	g.emit("(br $l%d)))", n)
+	g.depth -= 2
+}
+
+func (g *watGen) Guard(lo, hi int64, fast, slow func()) {
+	if lo >= 0 {
+		g.emit("(call $grow %s)", watPtr(hi))
+		fast()
+		return
+	}
+	g.emit("(if (i32.ge_s (local.get $p) (i32.const %d))", WATTapeBase-lo)
+	g.depth++
+	g.emit("(then")
+	g.depth++
+	g.emit("(call $grow %s)", watPtr(hi))
+	fast()
+	g.emit(")")
+	g.depth--
+	g.emit("(else")
+	g.depth++
+	slow()
+	g.emit("))")
+	g.depth -= 2
+}
+
+func (g *watGen) DataPtrAdd(delta int64, unchecked bool) {
+	g.emit("(local.set $p %s)", watPtr(delta))
+	if !unchecked {
+		g.emit("(call $check (local.get $p))")
+	}
+}
+
+func (g *watGen) DataAdd(value byte) {
+	g.cellAdd(0, fmt.Sprintf("(i32.const %d)", value))
+}
+
+func (g *watGen) Read() {
+	g.emit("(call $readb (local.get $p))")
+}
+
+func (g *watGen) Write(repeat int64) {
+	g.emit("(call $writeb (local.get $p) (i32.const %d))", repeat)
+}
+
+func (g *watGen) DataAddVector(vec []byte, unchecked bool) {
+	if !unchecked {
+		g.emit("(call $grow %s)", watPtr(int64(len(vec))-1))
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.cellAdd(int64(i), fmt.Sprintf("(i32.const %d)", v))
+		}
+	}
+}
+
+func (g *watGen) DataAddLinVector(vec []byte, offset int64, unchecked bool) {
+	g.emit("(local.set $m (i32.load8_u (local.get $p)))")
+	g.emit("(if (local.get $m)")
+	g.depth++
+	g.emit("(then")
+	g.depth++
+	if !unchecked {
+		g.emit("(call $check %s)", watPtr(offset))
+		g.emit("(call $grow %s)", watPtr(offset+int64(len(vec))-1))
+	}
+	for i, v := range vec {
+		if v != 0 {
+			g.cellAdd(offset+int64(i), fmt.Sprintf("(i32.mul (local.get $m) (i32.const %d))", v))
+		}
+	}
+	g.emit("))")
+	g.depth -= 2
+}
+
+func (g *watGen) DataSet(value byte) {
+	g.emit("(i32.store8 (local.get $p) (i32.const %d))", value)
+}
+
+func (g *watGen) Prologue(output io.Writer, bounds *il.Bounds, opts GenOptions) error {
+	if opts.Profile || opts.Package != "" || opts.Func != "" {
+		return ErrWATUnsupported
+	}
+	datasize := initialDataSize(bounds)
+	g.depth = 2
+	return g.start(output, templateText(opts, strings.TrimPrefix(templateConstMainWat, "\n")), TemplateParams{
+		InitialDataSize: datasize,
+		WASI:            opts.WASI,
+		TapeBase:        WATTapeBase,
+		MemoryPages:     (WATTapeBase + datasize + watPageSize - 1) / watPageSize,
+	})
+}
+
+func (g *watGen) Epilogue() error {
+	return g.finish()
+}
+
+// Build is not supported, since there is no WebAssembly toolchain to rely
+// on. The tests check the structure of the modules instead.
+func (g *watGen) Build(infile, outfile string, opts CompileOptions) error {
+	return ErrNoBuild
+}
+
+// ILBlockToWAT writes a WebAssembly text module equivalent to b to output.
+// The tape is the module's exported linear memory.
+//
+// By default, the module imports env.read, which returns the next input
+// byte or -1 at the end of input, and env.write, which outputs a byte,
+// and exports the program as run. With opts.WASI, the module uses the
+// WASI fd_read and fd_write functions instead, and exports _start.
+// Moving the data pointer below the tape traps.
+func ILBlockToWAT(b *il.ILBlock, output io.Writer, opts GenOptions) error {
+	return Generate(b, output, new(watGen), opts)
+}
@@ -0,0 +1,413 @@
+package lang
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// parseBF builds the IL tree for the BF commands in src.
+func parseBF(src string) *il.ILBlock {
+	s := il.NewILBlockStack()
+	root := il.NewILBlock(il.ILList)
+	cur := root
+	for _, c := range src {
+		cmd := NewBFCmd(c)
+		switch cmd {
+		case BFCmdUnknown:
+			continue
+		case BFCmdLoopEnd:
+			cur = s.Pop()
+			continue
+		}
+		b := cmd.ToILBlock()
+		cur.Append(b)
+		if cmd == BFCmdLoopStart {
+			s.Push(cur)
+			cur = b
+		}
+	}
+	return root
+}
+
+// watTrap is the panic value of a trap in watMachine.
+type watTrap string
+
+// watFunc is a function of a module run by watMachine.
+type watFunc struct {
+	params []string
+	locals []string
+	result bool
+	body   []*sexpr
+}
+
+// watMachine runs the subset of WebAssembly text written by ILBlockToWAT.
+// It only supports folded instructions.
+type watMachine struct {
+	funcs  map[string]*watFunc
+	host   map[string]func(args []int32) int32
+	mem    []byte
+	input  []byte
+	output bytes.Buffer
+}
+
+func newWATMachine(t *testing.T, src string, input []byte) *watMachine {
+	top, err := parseSexprs(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &watMachine{funcs: make(map[string]*watFunc), input: input}
+	read := func([]int32) int32 {
+		if len(m.input) == 0 {
+			return -1
+		}
+		c := m.input[0]
+		m.input = m.input[1:]
+		return int32(c)
+	}
+	m.host = map[string]func([]int32) int32{
+		"$read":  read,
+		"$write": func(args []int32) int32 { m.output.WriteByte(byte(args[0])); return 0 },
+		// Both use the single I/O vector at address 0 that points at a byte
+		"$fd_read": func(args []int32) int32 {
+			n := int32(0)
+			if c := read(nil); c >= 0 {
+				m.mem[m.load(args[1], 4)] = byte(c)
+				n = 1
+			}
+			m.store(args[3], 4, n)
+			return 0
+		},
+		"$fd_write": func(args []int32) int32 {
+			m.output.WriteByte(m.mem[m.load(args[1], 4)])
+			m.store(args[3], 4, 1)
+			return 0
+		},
+	}
+
+	for _, f := range top[0].list[1:] {
+		switch f.head() {
+		case "memory":
+			pages, _ := strconv.Atoi(f.list[len(f.list)-1].atom)
+			m.mem = make([]byte, pages*watPageSize)
+		case "func":
+			fn := new(watFunc)
+			body := f.list[2:]
+			for len(body) > 0 && body[0].isList() {
+				switch body[0].head() {
+				case "param":
+					fn.params = append(fn.params, body[0].list[1].atom)
+				case "local":
+					fn.locals = append(fn.locals, body[0].list[1].atom)
+				case "result":
+					fn.result = true
+				case "export":
+				default:
+					goto done
+				}
+				body = body[1:]
+			}
+		done:
+			fn.body = body
+			m.funcs[f.list[1].atom] = fn
+		}
+	}
+	return m
+}
+
+func (m *watMachine) load(addr int32, size int) int32 {
+	if addr < 0 || int(addr)+size > len(m.mem) {
+		panic(watTrap("out of bounds memory access"))
+	}
+	var v int32
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | int32(m.mem[int(addr)+i])
+	}
+	return v
+}
+
+func (m *watMachine) store(addr int32, size int, v int32) {
+	if addr < 0 || int(addr)+size > len(m.mem) {
+		panic(watTrap("out of bounds memory access"))
+	}
+	for i := 0; i < size; i++ {
+		m.mem[int(addr)+i] = byte(v >> (8 * i))
+	}
+}
+
+// call runs the function name and returns its result.
+func (m *watMachine) call(name string, args []int32) int32 {
+	if h, ok := m.host[name]; ok {
+		return h(args)
+	}
+	fn := m.funcs[name]
+	locals := make(map[string]int32)
+	for i, p := range fn.params {
+		locals[p] = args[i]
+	}
+	var stack []int32
+	m.seq(fn.body, locals, &stack)
+	if fn.result {
+		return stack[len(stack)-1]
+	}
+	return 0
+}
+
+// seq runs instructions and returns the label of a branch out of them.
+func (m *watMachine) seq(es []*sexpr, locals map[string]int32, stack *[]int32) string {
+	for _, e := range es {
+		if br := m.exec(e, locals, stack); br != "" {
+			return br
+		}
+	}
+	return ""
+}
+
+func (m *watMachine) exec(e *sexpr, locals map[string]int32, stack *[]int32) string {
+	pop := func() int32 {
+		v := (*stack)[len(*stack)-1]
+		*stack = (*stack)[:len(*stack)-1]
+		return v
+	}
+	push := func(v int32) { *stack = append(*stack, v) }
+
+	op, args := e.head(), e.list[1:]
+	switch op {
+	case "block":
+		if br := m.seq(args[1:], locals, stack); br != args[0].atom {
+			return br
+		}
+		return ""
+	case "loop":
+		for {
+			br := m.seq(args[1:], locals, stack)
+			if br != args[0].atom {
+				return br
+			}
+		}
+	case "if":
+		if br := m.exec(args[0], locals, stack); br != "" {
+			return br
+		}
+		cond := pop() != 0
+		for _, a := range args[1:] {
+			if cond == (a.head() == "then") {
+				return m.seq(a.list[1:], locals, stack)
+			}
+		}
+		return ""
+	case "br":
+		return args[0].atom
+	case "br_if":
+		if br := m.seq(args[1:], locals, stack); br != "" {
+			return br
+		}
+		if pop() != 0 {
+			return args[0].atom
+		}
+		return ""
+	}
+
+	// The offset immediate and name or const immediates come first
+	var offset int32
+	var imm string
+	for len(args) > 0 && !args[0].isList() {
+		if strings.HasPrefix(args[0].atom, "offset=") {
+			o, _ := strconv.Atoi(strings.TrimPrefix(args[0].atom, "offset="))
+			offset = int32(o)
+		} else {
+			imm = args[0].atom
+		}
+		args = args[1:]
+	}
+	if br := m.seq(args, locals, stack); br != "" {
+		return br
+	}
+
+	switch op {
+	case "unreachable":
+		panic(watTrap("unreachable"))
+	case "drop":
+		pop()
+	case "call":
+		fn := m.funcs[imm]
+		n := len(args)
+		if fn != nil {
+			n = len(fn.params)
+		}
+		callargs := make([]int32, n)
+		for i := n - 1; i >= 0; i-- {
+			callargs[i] = pop()
+		}
+		r := m.call(imm, callargs)
+		if fn == nil && imm != "$write" || fn != nil && fn.result {
+			push(r)
+		}
+	case "local.get":
+		push(locals[imm])
+	case "local.set":
+		locals[imm] = pop()
+	case "i32.const":
+		v, _ := strconv.ParseInt(imm, 0, 64)
+		push(int32(v))
+	case "i32.eqz":
+		push(b2i(pop() == 0))
+	case "memory.size":
+		push(int32(len(m.mem) / watPageSize))
+	case "memory.grow":
+		n := pop()
+		push(int32(len(m.mem) / watPageSize))
+		m.mem = append(m.mem, make([]byte, int(n)*watPageSize)...)
+	case "i32.load8_u":
+		push(m.load(pop()+offset, 1))
+	case "i32.load":
+		push(m.load(pop()+offset, 4))
+	case "i32.store8", "i32.store":
+		v, addr := pop(), pop()
+		size := 4
+		if op == "i32.store8" {
+			size = 1
+		}
+		m.store(addr+offset, size, v)
+	default:
+		b, a := pop(), pop()
+		switch op {
+		case "i32.add":
+			push(a + b)
+		case "i32.sub":
+			push(a - b)
+		case "i32.mul":
+			push(a * b)
+		case "i32.shr_u":
+			push(int32(uint32(a) >> uint32(b)))
+		case "i32.lt_s":
+			push(b2i(a < b))
+		case "i32.ge_s":
+			push(b2i(a >= b))
+		case "i32.ge_u":
+			push(b2i(uint32(a) >= uint32(b)))
+		default:
+			panic(fmt.Sprintf("unsupported instruction %s", op))
+		}
+	}
+	return ""
+}
+
+func b2i(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// runWAT runs the exported entry point of the module src and returns its
+// output, or the trap it stopped at.
+func runWAT(t *testing.T, src string, input []byte) (output []byte, trap watTrap) {
+	m := newWATMachine(t, src, input)
+	defer func() {
+		if r := recover(); r != nil {
+			tr, ok := r.(watTrap)
+			if !ok {
+				panic(r)
+			}
+			output, trap = m.output.Bytes(), tr
+		}
+	}()
+	m.call("$run", nil)
+	return m.output.Bytes(), ""
+}
+
+func TestILBlockToWAT(t *testing.T) {
+	hello, err := ioutil.ReadFile("../../testprograms/helloworld.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		cmds          string
+		input, output string
+		trap          bool
+	}{
+		{"empty", "", "", "", false},
+		{"echo", ",>,>,>,<<<.>.>.>.", "abcd", "abcd", false},
+		{"echo loop", ",>,>,>,<<<[.>]", "abcd", "abcd", false},
+		{"eof unchanged", "+++,.", "", "\x03", false},
+		{"grow", "+[-" + strings.Repeat(">", 200000) + "]+.", "", "\x01", false},
+		{"below zero", "+.<.", "", "\x01", true},
+		{"helloworld", string(hello), "", "Hello World!\n", false},
+		{"multiply", "+++++[>++++++++++<-]>+++.<++[>>+++[<.>-]<<-]", "", "5555555", false},
+	}
+
+	for _, tc := range tests {
+		for _, wasi := range []bool{false, true} {
+			for _, optimize := range []bool{false, true} {
+				name := fmt.Sprintf("%s/wasi=%v/optimize=%v", tc.name, wasi, optimize)
+				t.Run(name, func(t *testing.T) {
+					ilb := parseBF(tc.cmds)
+					ilb.Compress()
+					ilb.Prune()
+					if optimize {
+						ilb.Vectorize()
+						ilb.VectorBalance()
+						ilb.PatternReplace(il.PatternReplaceLinearVector)
+						ilb.PatternReplace(il.PatternReplaceZero)
+					}
+
+					var wat bytes.Buffer
+					if err := ILBlockToWAT(ilb, &wat, GenOptions{WASI: wasi}); err != nil {
+						t.Fatal(err)
+					}
+					if err := validateWAT(bytes.NewReader(wat.Bytes())); err != nil {
+						t.Fatalf("%v\n%s", err, wat.String())
+					}
+					out, trap := runWAT(t, wat.String(), []byte(tc.input))
+					if tc.trap != (trap != "") {
+						t.Fatalf("program trapped with %q, expected a trap %v", trap, tc.trap)
+					}
+					if string(out) != tc.output {
+						t.Fatalf("output is %q, expected %q", out, tc.output)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestValidateWAT(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		ok   bool
+	}{
+		{"minimal", "(module (memory 1))", true},
+		{"comments", "(module ;; line\n (; block ;) (memory 1))", true},
+		{"no memory", "(module (func $f))", false},
+		{"unbalanced", "(module (memory 1)", false},
+		{"extra )", "(module (memory 1)))", false},
+		{"unknown field", "(module (memory 1) (bogus))", false},
+		{"unknown instruction", "(module (memory 1) (func $f (i32.bogus)))", false},
+		{"undefined call", "(module (memory 1) (func $f (call $g)))", false},
+		{"undefined local", "(module (memory 1) (func $f (param $a i32) (drop (local.get $b))))", false},
+		{"undefined label", "(module (memory 1) (func $f (block $a (br $b))))", false},
+		{"label out of scope", "(module (memory 1) (func $f (block $a) (br $a)))", false},
+		{"imports and exports", `(module
+  (import "env" "write" (func $write (param i32)))
+  (memory 1)
+  (func $f (export "f") (param $a i32) (call $write (local.get $a)))
+  (export "g" (func $f)))`, true},
+		{"bad export", `(module (memory 1) (export "g" (func $f)))`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateWAT(strings.NewReader(tc.src))
+			if (err == nil) != tc.ok {
+				t.Fatalf("validateWAT gave %v, expected ok=%v", err, tc.ok)
+			}
+		})
+	}
+}
@@ -2,14 +2,312 @@
 
 package lang
 
+const templateConstFunc = `
+package {{ .Package }}
+
+import (
+	"fmt"
+	"io"
+)
+{{ if not .Unbuffered }}
+import "bufio"
+{{ end }}
+
+// {{ .State }} is the tape of a single {{ .Func }} call.
+type {{ .State }} struct {
+	data  []byte
+	datap int
+	in    io.Reader
+	{{ if .Unbuffered }}
+	out io.Writer
+	{{ else }}
+	out *bufio.Writer
+	{{ end }}
+}
+
+func (s *{{ .State }}) writeb(repeat int) {
+	for i := 0; i < repeat; i++ {
+		{{ if .Unbuffered }}
+		if _, err := s.out.Write(s.data[s.datap : s.datap+1]); err != nil {
+			panic(err)
+		}
+		{{ else }}
+		if err := s.out.WriteByte(s.data[s.datap]); err != nil {
+			panic(err)
+		}
+		{{ end }}
+	}
+}
+
+func (s *{{ .State }}) readb() {
+	{{ if not .Unbuffered }}
+	// Make sure any prompt is visible before blocking on input
+	if err := s.out.Flush(); err != nil {
+		panic(err)
+	}
+	{{ end }}
+	// The cell is left unchanged at the end of input
+	if _, err := io.ReadFull(s.in, s.data[s.datap:s.datap+1]); err != nil && err != io.EOF {
+		panic(err)
+	}
+}
+
+func (s *{{ .State }}) datapadd(delta int) {
+	s.datap += delta
+	if s.datap < 0 {
+		panic("Data pointer is out of bounds")
+	}
+	if s.datap >= len(s.data) {
+		newdata := make([]byte, s.datap*2)
+		copy(newdata, s.data)
+		s.data = newdata
+	}
+}
+
+func (s *{{ .State }}) dataadd(delta byte) {
+	s.data[s.datap] += delta
+}
+
+func (s *{{ .State }}) dataset(value byte) {
+	s.data[s.datap] = value
+}
+
+func (s *{{ .State }}) dataaddvector(vec []byte) {
+	s.datagrow(len(vec) - 1)
+	s.dataaddvectoru(vec)
+}
+
+// dataaddlvector uses data[datap] as a linear multiplier for values of vec,
+// which are added to value of data starting at offset datap+offset.
+func (s *{{ .State }}) dataaddlvector(vec []byte, offset int) {
+	s.datagrow(offset + len(vec) - 1)
+	s.dataaddlvectoru(vec, offset)
+}
+
+// datagrow makes sure data holds the cell at offset hi from datap.
+func (s *{{ .State }}) datagrow(hi int) {
+	if l := s.datap + hi; l >= len(s.data) {
+		newdata := make([]byte, l*2)
+		copy(newdata, s.data)
+		s.data = newdata
+	}
+}
+
+// The following unchecked helpers are used where the data pointer was
+// proven to stay within data.
+
+func (s *{{ .State }}) datapaddu(delta int) {
+	s.datap += delta
+}
+
+func (s *{{ .State }}) dataaddvectoru(vec []byte) {
+	var d = s.data[s.datap : s.datap+len(vec)]
+	_ = d[len(vec)-1]
+	for i := range vec {
+		d[i] += vec[i]
+	}
+}
+
+func (s *{{ .State }}) dataaddlvectoru(vec []byte, offset int) {
+	var mult = s.data[s.datap]
+	if mult == 0 {
+		return
+	}
+
+	var d = s.data[s.datap+offset : s.datap+offset+len(vec)]
+	_ = d[len(vec)-1]
+
+	for i := range vec {
+		d[i] += vec[i] * mult
+	}
+}
+
+// {{ .Func }} runs the BF program, reading its input from in and writing its
+// output to out. It is safe to call concurrently, since every call has its
+// own tape. Runtime errors, like moving the data pointer below zero, and
+// read or write errors are returned.
+func {{ .Func }}(in io.Reader, out io.Writer) (err error) {
+	s := &{{ .State }}{
+		data: make([]byte, {{ .InitialDataSize }}),
+		in:   in,
+		{{ if .Unbuffered }}
+		out: out,
+		{{ else }}
+		out: bufio.NewWriterSize(out, 64*1024),
+		{{ end }}
+	}
+
+	_ = s // the body may be empty
+
+	defer func() {
+		if r := recover(); r != nil {
+			{{ if not .Unbuffered }}
+			s.out.Flush()
+			{{ end }}
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	{{ range .Body }}{{ . }}
+	{{ end }}
+
+	{{ if .Unbuffered }}
+	return nil
+	{{ else }}
+	return s.out.Flush()
+	{{ end }}
+}
+`
+
+const templateConstMainC = `
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static unsigned char *data;
+static long datalen;
+static long datap;
+
+static void fail(const char *msg)
+{
+	fflush(stdout);
+	fprintf(stderr, "Error: %s\n", msg);
+	exit(2);
+}
+
+/* datagrow makes sure data holds the cell at offset hi from datap. */
+static inline void datagrow(long hi)
+{
+	long l = datap + hi;
+	if (l >= datalen) {
+		unsigned char *newdata = realloc(data, l * 2);
+		if (newdata == NULL) {
+			fail("Out of memory");
+		}
+		memset(newdata + datalen, 0, l * 2 - datalen);
+		data = newdata;
+		datalen = l * 2;
+	}
+}
+
+static inline void writeb(int repeat)
+{
+	for (int i = 0; i < repeat; i++) {
+		putchar(data[datap]);
+	}
+}
+
+static inline void readb(void)
+{
+	/* Make sure any prompt is visible before blocking on input */
+	fflush(stdout);
+	/* The cell is left unchanged at the end of input */
+	int c = getchar();
+	if (c != EOF) {
+		data[datap] = (unsigned char)c;
+	}
+}
+
+static inline void datapadd(long delta)
+{
+	datap += delta;
+	if (datap < 0) {
+		fail("Data pointer is out of bounds");
+	}
+	datagrow(0);
+}
+
+static inline void dataaddvectoru(const unsigned char *vec, long n)
+{
+	unsigned char *d = data + datap;
+	for (long i = 0; i < n; i++) {
+		d[i] += vec[i];
+	}
+}
+
+static inline void dataaddvector(const unsigned char *vec, long n)
+{
+	datagrow(n - 1);
+	dataaddvectoru(vec, n);
+}
+
+/*
+ * dataaddlvectoru uses data[datap] as a linear multiplier for values of vec,
+ * which are added to value of data starting at offset datap+offset.
+ */
+static inline void dataaddlvectoru(const unsigned char *vec, long n, long offset)
+{
+	unsigned char mult = data[datap];
+	if (mult == 0) {
+		return;
+	}
+	unsigned char *d = data + datap + offset;
+	for (long i = 0; i < n; i++) {
+		d[i] += vec[i] * mult;
+	}
+}
+
+static inline void dataaddlvector(const unsigned char *vec, long n, long offset)
+{
+	if (data[datap] == 0) {
+		return;
+	}
+	if (datap + offset < 0) {
+		fail("Data pointer is out of bounds");
+	}
+	datagrow(offset + n - 1);
+	dataaddlvectoru(vec, n, offset);
+}
+
+/* scanright runs the loop [>] by searching for the next zero cell. */
+static inline void scanright(void)
+{
+	unsigned char *p = memchr(data + datap, 0, datalen - datap);
+	if (p != NULL) {
+		datap = p - data;
+	} else {
+		/* Cells past the end of data are zero */
+		datap = datalen;
+		datagrow(0);
+	}
+}
+
+int main(void)
+{
+	{{- if .Unbuffered }}
+	setvbuf(stdout, NULL, _IONBF, 0);
+	{{- else }}
+	setvbuf(stdout, NULL, _IOFBF, 64 * 1024);
+	{{- end }}
+	datalen = {{ .InitialDataSize }};
+	data = calloc(datalen, 1);
+	if (data == NULL) {
+		fail("Out of memory");
+	}
+
+{{ range .Body }}{{ . }}
+{{ end }}
+	fflush(stdout);
+	return 0;
+}
+`
+
 const templateConstMain = `
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 )
+{{ if .Unbuffered }}
+import "bytes"
+{{ else }}
+import "bufio"
+{{ end }}
 {{ if .ProfilingEnabled }}
 import (
 	"crypto/sha1"
@@ -22,6 +320,9 @@ import (
 
 var data []byte
 var datap int
+{{ if not .Unbuffered }}
+var out = bufio.NewWriterSize(os.Stdout, 64*1024)
+{{ end }}
 {{ if .ProfilingEnabled }}
 var datapMax int
 var dataExpansionCount int
@@ -52,10 +353,20 @@ func profProgramEnd() {
 {{ end }}
 
 func writeb(repeat int) {
+	{{ if .Unbuffered }}
 	os.Stdout.Write(bytes.Repeat(data[datap : datap+1], repeat))
+	{{ else }}
+	for i := 0; i < repeat; i++ {
+		out.WriteByte(data[datap])
+	}
+	{{ end }}
 }
 
 func readb() {
+	{{ if not .Unbuffered }}
+	// Make sure any prompt is visible before blocking on input
+	out.Flush()
+	{{ end }}
 	os.Stdin.Read(data[datap : datap+1])
 }
 
@@ -137,8 +448,66 @@ func dataaddlvector(vec []byte, offset int) {
 	{{ end }}
 }
 
+// datagrow makes sure data holds the cell at offset hi from datap.
+// It is used before loops whose cell accesses were proven to stay
+// within a known range, so that the loop body can skip the checks.
+func datagrow(hi int) {
+	if l := datap + hi; l >= len(data) {
+		newdata := make([]byte, l*2)
+		copy(newdata, data)
+		data = newdata
+		{{ if .ProfilingEnabled }}
+		dataExpansionCount++
+		{{ end }}
+	}
+}
+
+// The following unchecked helpers are used where the data pointer was
+// proven to stay within data.
+
+func datapaddu(delta int) {
+	datap += delta
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap)
+	{{ end }}
+}
+
+func dataaddvectoru(vec []byte) {
+	var d = data[datap : datap + len(vec)]
+	_ = d[len(vec)-1]
+	for i := range vec {
+		d[i] += vec[i]
+	}
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap + len(vec) - 1)
+	{{ end }}
+}
+
+func dataaddlvectoru(vec []byte, offset int) {
+	var mult = data[datap]
+	if mult == 0 {
+		return
+	}
+
+	var d = data[datap+offset : datap+offset+len(vec)]
+	_ = d[len(vec)-1]
+
+	for i := range vec {
+		d[i] += vec[i] * mult
+	}
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap + offset + len(vec) - 1)
+	{{ end }}
+}
+
 func errorHandler() {
 	if r := recover(); r != nil {
+		{{ if not .Unbuffered }}
+		out.Flush()
+		{{ end }}
 		fmt.Fprintln(os.Stderr, "Error:", r)
 		{{ if .ProfilingEnabled }}
 		profProgramEnd()
@@ -179,6 +548,10 @@ func main() {
 	{{ range .Body }}{{ . }}
 	{{ end }}
 
+	{{ if not .Unbuffered }}
+	out.Flush()
+	{{ end }}
+
 	{{ if .ProfilingEnabled }}
 	profProgramEnd()
 	{{ end }}
@@ -200,3 +573,545 @@ func main() {
 	{{ end }}
 }
 `
+
+const templateConstMainJs = `
+// BF program, for Node and browsers.
+//
+// In Node, running this file runs the program with standard input and
+// output. Otherwise, call run(read, write), where read returns the next
+// input byte or -1 at the end of input, and write outputs a byte.
+"use strict";
+
+function run(read, write) {
+	let data = new Uint8Array({{ .InitialDataSize }});
+	let p = 0;
+	let m = 0;
+
+	// grow makes sure data holds the cell at offset hi from p.
+	function grow(hi) {
+		if (p + hi >= data.length) {
+			const grown = new Uint8Array((p + hi) * 2);
+			grown.set(data);
+			data = grown;
+		}
+	}
+
+	// check throws if the cell at offset lo from p is below the tape, and
+	// grows data to hold the cell at offset hi.
+	function check(lo, hi) {
+		if (p + lo < 0) {
+			throw new RangeError("Data pointer is out of bounds");
+		}
+		grow(hi);
+	}
+
+	// readb reads a byte into the current cell, which is left unchanged at
+	// the end of input.
+	function readb() {
+		const c = read();
+		if (c >= 0) {
+			data[p] = c;
+		}
+	}
+
+	function writeb(repeat) {
+		for (let i = 0; i < repeat; i++) {
+			write(data[p]);
+		}
+	}
+
+{{ range .Body }}{{ . }}
+{{ end -}}
+}
+
+function main() {
+	const fs = require("fs");
+	const out = new Uint8Array(64 * 1024);
+	const inbuf = new Uint8Array(1);
+	let n = 0;
+
+	function flush() {
+		for (let o = 0; o < n; ) {
+			o += fs.writeSync(1, out, o, n - o);
+		}
+		n = 0;
+	}
+
+	function read() {
+		// Make sure any prompt is visible before blocking on input
+		flush();
+		for (;;) {
+			try {
+				return fs.readSync(0, inbuf, 0, 1) === 1 ? inbuf[0] : -1;
+			} catch (e) {
+				if (e.code === "EAGAIN") {
+					continue;
+				}
+				if (e.code === "EOF") {
+					return -1;
+				}
+				throw e;
+			}
+		}
+	}
+
+	function write(b) {
+		if (n === out.length) {
+			flush();
+		}
+		out[n++] = b;
+{{- if .Unbuffered }}
+		flush();
+{{- end }}
+	}
+
+	try {
+		run(read, write);
+	} catch (e) {
+		flush();
+		process.stderr.write("Error: " + e.message + "\n");
+		process.exit(2);
+	}
+	flush();
+}
+
+if (typeof module !== "undefined") {
+	module.exports = { run };
+	if (require.main === module) {
+		main();
+	}
+}
+`
+
+const templateConstMainLl = `
+; BF program in LLVM IR, using libc for I/O and memory.
+;
+; The tape is the heap buffer @data of length @datalen, which grows as
+; needed, and the data pointer is the index %p.
+
+@data = internal global ptr null
+@datalen = internal global i64 0
+
+@errlow = private unnamed_addr constant [37 x i8] c"Error: Data pointer is out of bounds\0A"
+@errmem = private unnamed_addr constant [21 x i8] c"Error: Out of memory\0A"
+
+declare i32 @getchar()
+declare i32 @putchar(i32)
+declare i32 @fflush(ptr)
+declare i64 @write(i32, ptr, i64)
+declare ptr @calloc(i64, i64)
+declare ptr @realloc(ptr, i64)
+declare void @exit(i32) noreturn
+declare void @llvm.memset.p0.i64(ptr, i8, i64, i1)
+
+; fail writes the message msg of length n to standard error and exits
+; with status 2.
+define internal void @fail(ptr %msg, i64 %n) noreturn cold {
+  call i32 @fflush(ptr null)
+  call i64 @write(i32 2, ptr %msg, i64 %n)
+  call void @exit(i32 2)
+  unreachable
+}
+
+; datagrow makes sure @data holds the cell at offset hi from p.
+define internal void @datagrow(i64 %p, i64 %hi) alwaysinline {
+  %l = add i64 %p, %hi
+  %len = load i64, ptr @datalen
+  %full = icmp sge i64 %l, %len
+  br i1 %full, label %grow, label %done
+grow:
+  %newlen = mul i64 %l, 2
+  %old = load ptr, ptr @data
+  %new = call ptr @realloc(ptr %old, i64 %newlen)
+  %null = icmp eq ptr %new, null
+  br i1 %null, label %oom, label %clear
+oom:
+  call void @fail(ptr @errmem, i64 21)
+  unreachable
+clear:
+  %tail = getelementptr i8, ptr %new, i64 %len
+  %taillen = sub i64 %newlen, %len
+  call void @llvm.memset.p0.i64(ptr %tail, i8 0, i64 %taillen, i1 false)
+  store ptr %new, ptr @data
+  store i64 %newlen, ptr @datalen
+  br label %done
+done:
+  ret void
+}
+
+; datacheck fails if the cell at offset lo from p is below the tape, and
+; grows the tape to hold the cell at offset hi.
+define internal void @datacheck(i64 %p, i64 %lo, i64 %hi) alwaysinline {
+  %l = add i64 %p, %lo
+  %low = icmp slt i64 %l, 0
+  br i1 %low, label %fail, label %ok
+fail:
+  call void @fail(ptr @errlow, i64 37)
+  unreachable
+ok:
+  call void @datagrow(i64 %p, i64 %hi)
+  ret void
+}
+
+; writeb writes the cell at address a n times.
+define internal void @writeb(ptr %a, i64 %n) {
+entry:
+  %v = load i8, ptr %a
+  %c = zext i8 %v to i32
+  br label %loop
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %more = icmp slt i64 %i, %n
+  br i1 %more, label %body, label %done
+body:
+  call i32 @putchar(i32 %c)
+  %next = add i64 %i, 1
+  br label %loop
+done:
+{{- if .Unbuffered }}
+  call i32 @fflush(ptr null)
+{{- end }}
+  ret void
+}
+
+; readb reads a byte into the cell at address a, which is left unchanged
+; at the end of input.
+define internal void @readb(ptr %a) {
+  ; Make sure any prompt is visible before blocking on input
+  call i32 @fflush(ptr null)
+  %c = call i32 @getchar()
+  %eof = icmp slt i32 %c, 0
+  br i1 %eof, label %done, label %store
+store:
+  %v = trunc i32 %c to i8
+  store i8 %v, ptr %a
+  br label %done
+done:
+  ret void
+}
+
+define i32 @main() {
+entry:
+  %pp = alloca i64
+  store i64 0, ptr %pp
+  %data = call ptr @calloc(i64 {{ .InitialDataSize }}, i64 1)
+  %null = icmp eq ptr %data, null
+  br i1 %null, label %oom, label %start
+oom:
+  call void @fail(ptr @errmem, i64 21)
+  unreachable
+start:
+  store ptr %data, ptr @data
+  store i64 {{ .InitialDataSize }}, ptr @datalen
+
+{{ range .Body }}{{ . }}
+{{ end }}
+  call i32 @fflush(ptr null)
+  ret i32 0
+}
+`
+
+const templateConstMainPy = `
+#!/usr/bin/env python3
+"""BF program.
+
+Running this file runs the program with standard input and output.
+Otherwise, import it and call run(read, write), where read returns the
+next input byte or -1 at the end of input, and write outputs bytes.
+"""
+
+import sys
+
+
+class BFError(Exception):
+    pass
+
+
+def grow(data, hi):
+    """Make sure data holds the cell hi."""
+    if hi >= len(data):
+        data.extend(bytes(hi * 2 - len(data)))
+
+
+def check(data, lo, hi):
+    """Raise BFError if the cell lo is below the tape, and grow data to
+    hold the cell hi."""
+    if lo < 0:
+        raise BFError("Data pointer is out of bounds")
+    grow(data, hi)
+
+
+def readb(data, p, read):
+    """Read a byte into the cell p, which is left unchanged at the end of
+    input."""
+    c = read()
+    if c >= 0:
+        data[p] = c
+
+
+def run(read, write):
+    data = bytearray({{ .InitialDataSize }})
+    p = 0
+    m = 0
+{{ range .Body }}{{ . }}
+{{ end }}
+
+def main():
+    out = sys.stdout.buffer
+    inp = sys.stdin.buffer
+
+    def read():
+        # Make sure any prompt is visible before blocking on input
+        out.flush()
+        c = inp.read(1)
+        return c[0] if c else -1
+
+    def write(b):
+        out.write(b)
+{{- if .Unbuffered }}
+        out.flush()
+{{- end }}
+
+    try:
+        run(read, write)
+    except BFError as e:
+        out.flush()
+        sys.stderr.write("Error: %s\n" % e)
+        sys.exit(2)
+    out.flush()
+
+
+if __name__ == "__main__":
+    main()
+`
+
+const templateConstMainS = `
+# BF program for Linux x86-64, without libc.
+#
+# %rbx is the data pointer, %r12 and %r13 are the start and end of the
+# tape, and %r14 is the number of bytes in outbuf. The tape is reserved
+# with mmap, so pages are only allocated when they are touched, and a
+# guard page below the tape catches unchecked moves below zero.
+
+	.equ SYS_READ, 0
+	.equ SYS_WRITE, 1
+	.equ SYS_MMAP, 9
+	.equ SYS_MPROTECT, 10
+	.equ SYS_EXIT, 60
+	.equ PAGESIZE, 4096
+	.equ TAPESIZE, {{ .TapeSize }}
+	.equ OUTBUFSIZE, 65536
+
+	.section .rodata
+errlow:
+	.ascii "Error: Data pointer is out of bounds\n"
+	.equ ERRLOWLEN, . - errlow
+errhigh:
+	.ascii "Error: Tape is full\n"
+	.equ ERRHIGHLEN, . - errhigh
+errmmap:
+	.ascii "Error: Out of memory\n"
+	.equ ERRMMAPLEN, . - errmmap
+
+	.section .bss
+	.lcomm outbuf, OUTBUFSIZE
+
+	.section .text
+
+# flush writes the bytes in outbuf to standard output.
+flush:
+	xor %r15, %r15
+1:
+	cmp %r14, %r15
+	jae 2f
+	mov $SYS_WRITE, %eax
+	mov $1, %edi
+	lea outbuf(%rip), %rsi
+	add %r15, %rsi
+	mov %r14, %rdx
+	sub %r15, %rdx
+	syscall
+	test %rax, %rax
+	jle 2f
+	add %rax, %r15
+	jmp 1b
+2:
+	xor %r14, %r14
+	ret
+
+# writeb writes the current cell %rdi times.
+writeb:
+	test %rdi, %rdi
+	jz 2f
+	movb (%rbx), %al
+	lea outbuf(%rip), %rsi
+1:
+	cmp $OUTBUFSIZE, %r14
+	jb 3f
+	push %rdi
+	push %rax
+	call flush
+	pop %rax
+	pop %rdi
+	lea outbuf(%rip), %rsi
+3:
+	movb %al, (%rsi,%r14)
+	inc %r14
+	dec %rdi
+	jnz 1b
+{{- if .Unbuffered }}
+	call flush
+{{- end }}
+2:
+	ret
+
+# readb reads a byte into the current cell, which is left unchanged at
+# the end of input.
+readb:
+	# Make sure any prompt is visible before blocking on input
+	call flush
+	mov $SYS_READ, %eax
+	xor %edi, %edi
+	mov %rbx, %rsi
+	mov $1, %edx
+	syscall
+	ret
+
+# fail writes the message at %rsi with length %rdx to standard error
+# and exits with status 2.
+fail:
+	push %rsi
+	push %rdx
+	call flush
+	pop %rdx
+	pop %rsi
+	mov $SYS_WRITE, %eax
+	mov $2, %edi
+	syscall
+	mov $SYS_EXIT, %eax
+	mov $2, %edi
+	syscall
+
+oob_low:
+	lea errlow(%rip), %rsi
+	mov $ERRLOWLEN, %edx
+	jmp fail
+
+oob_high:
+	lea errhigh(%rip), %rsi
+	mov $ERRHIGHLEN, %edx
+	jmp fail
+
+	.globl _start
+_start:
+	# Reserve the tape and a guard page below it
+	mov $SYS_MMAP, %eax
+	xor %edi, %edi
+	mov $(TAPESIZE + PAGESIZE), %rsi
+	mov $3, %edx            # PROT_READ | PROT_WRITE
+	mov $0x4022, %r10d      # MAP_PRIVATE | MAP_ANONYMOUS | MAP_NORESERVE
+	mov $-1, %r8
+	xor %r9d, %r9d
+	syscall
+	cmp $-4096, %rax
+	jbe 1f
+	lea errmmap(%rip), %rsi
+	mov $ERRMMAPLEN, %edx
+	jmp fail
+1:
+	mov %rax, %r12
+	mov %rax, %rdi
+	mov $SYS_MPROTECT, %eax
+	mov $PAGESIZE, %esi
+	xor %edx, %edx          # PROT_NONE
+	syscall
+	add $PAGESIZE, %r12
+	mov %r12, %r13
+	add $TAPESIZE, %r13
+	mov %r12, %rbx
+	xor %r14, %r14
+
+{{ range .Body }}{{ . }}
+{{ end }}
+	call flush
+	mov $SYS_EXIT, %eax
+	xor %edi, %edi
+	syscall
+`
+
+const templateConstMainWat = `
+(module
+{{- if .WASI }}
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+{{- else }}
+  ;; read returns the next input byte, or -1 at the end of input
+  (import "env" "read" (func $read (result i32)))
+  ;; write outputs the low byte of its parameter
+  (import "env" "write" (func $write (param i32)))
+{{- end }}
+
+  ;; The tape starts at address {{ .TapeBase }}, after a scratch area
+  ;; used for I/O vectors
+  (memory (export "memory") {{ .MemoryPages }})
+
+  ;; grow makes sure memory holds the cell at address hi
+  (func $grow (param $hi i32)
+    (if (i32.ge_u (local.get $hi) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (if (i32.lt_s
+              (memory.grow (i32.sub
+                (i32.add (i32.shr_u (local.get $hi) (i32.const 15)) (i32.const 2))
+                (memory.size)))
+              (i32.const 0))
+          (then (unreachable))))))
+
+  ;; check traps if the data pointer moved below the tape, and grows
+  ;; memory to hold the cell at address p
+  (func $check (param $p i32)
+    (if (i32.lt_s (local.get $p) (i32.const {{ .TapeBase }}))
+      (then (unreachable)))
+    (call $grow (local.get $p)))
+
+  ;; readb reads a byte into the cell at address p, which is left
+  ;; unchanged at the end of input
+  (func $readb (param $p i32)
+{{- if .WASI }}
+    (i32.store (i32.const 0) (i32.const 8))
+    (i32.store (i32.const 4) (i32.const 1))
+    (drop (call $fd_read (i32.const 0) (i32.const 0) (i32.const 1) (i32.const 12)))
+    (if (i32.load (i32.const 12))
+      (then (i32.store8 (local.get $p) (i32.load8_u (i32.const 8))))))
+{{- else }}
+    (local $c i32)
+    (local.set $c (call $read))
+    (if (i32.ge_s (local.get $c) (i32.const 0))
+      (then (i32.store8 (local.get $p) (local.get $c)))))
+{{- end }}
+
+  ;; writeb writes the cell at address p n times
+  (func $writeb (param $p i32) (param $n i32)
+{{- if .WASI }}
+    (i32.store (i32.const 0) (i32.const 8))
+    (i32.store (i32.const 4) (i32.const 1))
+    (i32.store8 (i32.const 8) (i32.load8_u (local.get $p)))
+{{- end }}
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+{{- if .WASI }}
+        (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 12)))
+{{- else }}
+        (call $write (i32.load8_u (local.get $p)))
+{{- end }}
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next))))
+
+  (func $run (export "{{ if .WASI }}_start{{ else }}run{{ end }}")
+    (local $p i32)
+    (local $m i32)
+    (local.set $p (i32.const {{ .TapeBase }}))
+{{ range .Body }}{{ . }}
+{{ end }}  )
+)
+`
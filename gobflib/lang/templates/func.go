@@ -0,0 +1,158 @@
+package {{ .Package }}
+
+import (
+	"fmt"
+	"io"
+)
+{{ if not .Unbuffered }}
+import "bufio"
+{{ end }}
+
+// {{ .State }} is the tape of a single {{ .Func }} call.
+type {{ .State }} struct {
+	data  []byte
+	datap int
+	in    io.Reader
+	{{ if .Unbuffered }}
+	out io.Writer
+	{{ else }}
+	out *bufio.Writer
+	{{ end }}
+}
+
+func (s *{{ .State }}) writeb(repeat int) {
+	for i := 0; i < repeat; i++ {
+		{{ if .Unbuffered }}
+		if _, err := s.out.Write(s.data[s.datap : s.datap+1]); err != nil {
+			panic(err)
+		}
+		{{ else }}
+		if err := s.out.WriteByte(s.data[s.datap]); err != nil {
+			panic(err)
+		}
+		{{ end }}
+	}
+}
+
+func (s *{{ .State }}) readb() {
+	{{ if not .Unbuffered }}
+	// Make sure any prompt is visible before blocking on input
+	if err := s.out.Flush(); err != nil {
+		panic(err)
+	}
+	{{ end }}
+	// The cell is left unchanged at the end of input
+	if _, err := io.ReadFull(s.in, s.data[s.datap:s.datap+1]); err != nil && err != io.EOF {
+		panic(err)
+	}
+}
+
+func (s *{{ .State }}) datapadd(delta int) {
+	s.datap += delta
+	if s.datap < 0 {
+		panic("Data pointer is out of bounds")
+	}
+	if s.datap >= len(s.data) {
+		newdata := make([]byte, s.datap*2)
+		copy(newdata, s.data)
+		s.data = newdata
+	}
+}
+
+func (s *{{ .State }}) dataadd(delta byte) {
+	s.data[s.datap] += delta
+}
+
+func (s *{{ .State }}) dataset(value byte) {
+	s.data[s.datap] = value
+}
+
+func (s *{{ .State }}) dataaddvector(vec []byte) {
+	s.datagrow(len(vec) - 1)
+	s.dataaddvectoru(vec)
+}
+
+// dataaddlvector uses data[datap] as a linear multiplier for values of vec,
+// which are added to value of data starting at offset datap+offset.
+func (s *{{ .State }}) dataaddlvector(vec []byte, offset int) {
+	s.datagrow(offset + len(vec) - 1)
+	s.dataaddlvectoru(vec, offset)
+}
+
+// datagrow makes sure data holds the cell at offset hi from datap.
+func (s *{{ .State }}) datagrow(hi int) {
+	if l := s.datap + hi; l >= len(s.data) {
+		newdata := make([]byte, l*2)
+		copy(newdata, s.data)
+		s.data = newdata
+	}
+}
+
+// The following unchecked helpers are used where the data pointer was
+// proven to stay within data.
+
+func (s *{{ .State }}) datapaddu(delta int) {
+	s.datap += delta
+}
+
+func (s *{{ .State }}) dataaddvectoru(vec []byte) {
+	var d = s.data[s.datap : s.datap+len(vec)]
+	_ = d[len(vec)-1]
+	for i := range vec {
+		d[i] += vec[i]
+	}
+}
+
+func (s *{{ .State }}) dataaddlvectoru(vec []byte, offset int) {
+	var mult = s.data[s.datap]
+	if mult == 0 {
+		return
+	}
+
+	var d = s.data[s.datap+offset : s.datap+offset+len(vec)]
+	_ = d[len(vec)-1]
+
+	for i := range vec {
+		d[i] += vec[i] * mult
+	}
+}
+
+// {{ .Func }} runs the BF program, reading its input from in and writing its
+// output to out. It is safe to call concurrently, since every call has its
+// own tape. Runtime errors, like moving the data pointer below zero, and
+// read or write errors are returned.
+func {{ .Func }}(in io.Reader, out io.Writer) (err error) {
+	s := &{{ .State }}{
+		data: make([]byte, {{ .InitialDataSize }}),
+		in:   in,
+		{{ if .Unbuffered }}
+		out: out,
+		{{ else }}
+		out: bufio.NewWriterSize(out, 64*1024),
+		{{ end }}
+	}
+
+	_ = s // the body may be empty
+
+	defer func() {
+		if r := recover(); r != nil {
+			{{ if not .Unbuffered }}
+			s.out.Flush()
+			{{ end }}
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	{{ range .Body }}{{ . }}
+	{{ end }}
+
+	{{ if .Unbuffered }}
+	return nil
+	{{ else }}
+	return s.out.Flush()
+	{{ end }}
+}
@@ -0,0 +1,130 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static unsigned char *data;
+static long datalen;
+static long datap;
+
+static void fail(const char *msg)
+{
+	fflush(stdout);
+	fprintf(stderr, "Error: %s\n", msg);
+	exit(2);
+}
+
+/* datagrow makes sure data holds the cell at offset hi from datap. */
+static inline void datagrow(long hi)
+{
+	long l = datap + hi;
+	if (l >= datalen) {
+		unsigned char *newdata = realloc(data, l * 2);
+		if (newdata == NULL) {
+			fail("Out of memory");
+		}
+		memset(newdata + datalen, 0, l * 2 - datalen);
+		data = newdata;
+		datalen = l * 2;
+	}
+}
+
+static inline void writeb(int repeat)
+{
+	for (int i = 0; i < repeat; i++) {
+		putchar(data[datap]);
+	}
+}
+
+static inline void readb(void)
+{
+	/* Make sure any prompt is visible before blocking on input */
+	fflush(stdout);
+	/* The cell is left unchanged at the end of input */
+	int c = getchar();
+	if (c != EOF) {
+		data[datap] = (unsigned char)c;
+	}
+}
+
+static inline void datapadd(long delta)
+{
+	datap += delta;
+	if (datap < 0) {
+		fail("Data pointer is out of bounds");
+	}
+	datagrow(0);
+}
+
+static inline void dataaddvectoru(const unsigned char *vec, long n)
+{
+	unsigned char *d = data + datap;
+	for (long i = 0; i < n; i++) {
+		d[i] += vec[i];
+	}
+}
+
+static inline void dataaddvector(const unsigned char *vec, long n)
+{
+	datagrow(n - 1);
+	dataaddvectoru(vec, n);
+}
+
+/*
+ * dataaddlvectoru uses data[datap] as a linear multiplier for values of vec,
+ * which are added to value of data starting at offset datap+offset.
+ */
+static inline void dataaddlvectoru(const unsigned char *vec, long n, long offset)
+{
+	unsigned char mult = data[datap];
+	if (mult == 0) {
+		return;
+	}
+	unsigned char *d = data + datap + offset;
+	for (long i = 0; i < n; i++) {
+		d[i] += vec[i] * mult;
+	}
+}
+
+static inline void dataaddlvector(const unsigned char *vec, long n, long offset)
+{
+	if (data[datap] == 0) {
+		return;
+	}
+	if (datap + offset < 0) {
+		fail("Data pointer is out of bounds");
+	}
+	datagrow(offset + n - 1);
+	dataaddlvectoru(vec, n, offset);
+}
+
+/* scanright runs the loop [>] by searching for the next zero cell. */
+static inline void scanright(void)
+{
+	unsigned char *p = memchr(data + datap, 0, datalen - datap);
+	if (p != NULL) {
+		datap = p - data;
+	} else {
+		/* Cells past the end of data are zero */
+		datap = datalen;
+		datagrow(0);
+	}
+}
+
+int main(void)
+{
+	{{- if .Unbuffered }}
+	setvbuf(stdout, NULL, _IONBF, 0);
+	{{- else }}
+	setvbuf(stdout, NULL, _IOFBF, 64 * 1024);
+	{{- end }}
+	datalen = {{ .InitialDataSize }};
+	data = calloc(datalen, 1);
+	if (data == NULL) {
+		fail("Out of memory");
+	}
+
+{{ range .Body }}{{ . }}
+{{ end }}
+	fflush(stdout);
+	return 0;
+}
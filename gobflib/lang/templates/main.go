@@ -1,10 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 )
+{{ if .Unbuffered }}
+import "bytes"
+{{ else }}
+import "bufio"
+{{ end }}
 {{ if .ProfilingEnabled }}
 import (
 	"crypto/sha1"
@@ -17,6 +21,9 @@ import (
 
 var data []byte
 var datap int
+{{ if not .Unbuffered }}
+var out = bufio.NewWriterSize(os.Stdout, 64*1024)
+{{ end }}
 {{ if .ProfilingEnabled }}
 var datapMax int
 var dataExpansionCount int
@@ -47,10 +54,20 @@ func profProgramEnd() {
 {{ end }}
 
 func writeb(repeat int) {
+	{{ if .Unbuffered }}
 	os.Stdout.Write(bytes.Repeat(data[datap : datap+1], repeat))
+	{{ else }}
+	for i := 0; i < repeat; i++ {
+		out.WriteByte(data[datap])
+	}
+	{{ end }}
 }
 
 func readb() {
+	{{ if not .Unbuffered }}
+	// Make sure any prompt is visible before blocking on input
+	out.Flush()
+	{{ end }}
 	os.Stdin.Read(data[datap : datap+1])
 }
 
@@ -132,8 +149,66 @@ func dataaddlvector(vec []byte, offset int) {
 	{{ end }}
 }
 
+// datagrow makes sure data holds the cell at offset hi from datap.
+// It is used before loops whose cell accesses were proven to stay
+// within a known range, so that the loop body can skip the checks.
+func datagrow(hi int) {
+	if l := datap + hi; l >= len(data) {
+		newdata := make([]byte, l*2)
+		copy(newdata, data)
+		data = newdata
+		{{ if .ProfilingEnabled }}
+		dataExpansionCount++
+		{{ end }}
+	}
+}
+
+// The following unchecked helpers are used where the data pointer was
+// proven to stay within data.
+
+func datapaddu(delta int) {
+	datap += delta
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap)
+	{{ end }}
+}
+
+func dataaddvectoru(vec []byte) {
+	var d = data[datap : datap + len(vec)]
+	_ = d[len(vec)-1]
+	for i := range vec {
+		d[i] += vec[i]
+	}
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap + len(vec) - 1)
+	{{ end }}
+}
+
+func dataaddlvectoru(vec []byte, offset int) {
+	var mult = data[datap]
+	if mult == 0 {
+		return
+	}
+
+	var d = data[datap+offset : datap+offset+len(vec)]
+	_ = d[len(vec)-1]
+
+	for i := range vec {
+		d[i] += vec[i] * mult
+	}
+
+	{{ if .ProfilingEnabled }}
+	profUpdateDatapMax(datap + offset + len(vec) - 1)
+	{{ end }}
+}
+
 func errorHandler() {
 	if r := recover(); r != nil {
+		{{ if not .Unbuffered }}
+		out.Flush()
+		{{ end }}
 		fmt.Fprintln(os.Stderr, "Error:", r)
 		{{ if .ProfilingEnabled }}
 		profProgramEnd()
@@ -174,6 +249,10 @@ func main() {
 	{{ range .Body }}{{ . }}
 	{{ end }}
 
+	{{ if not .Unbuffered }}
+	out.Flush()
+	{{ end }}
+
 	{{ if .ProfilingEnabled }}
 	profProgramEnd()
 	{{ end }}
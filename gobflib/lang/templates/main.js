@@ -0,0 +1,106 @@
+// BF program, for Node and browsers.
+//
+// In Node, running this file runs the program with standard input and
+// output. Otherwise, call run(read, write), where read returns the next
+// input byte or -1 at the end of input, and write outputs a byte.
+"use strict";
+
+function run(read, write) {
+	let data = new Uint8Array({{ .InitialDataSize }});
+	let p = 0;
+	let m = 0;
+
+	// grow makes sure data holds the cell at offset hi from p.
+	function grow(hi) {
+		if (p + hi >= data.length) {
+			const grown = new Uint8Array((p + hi) * 2);
+			grown.set(data);
+			data = grown;
+		}
+	}
+
+	// check throws if the cell at offset lo from p is below the tape, and
+	// grows data to hold the cell at offset hi.
+	function check(lo, hi) {
+		if (p + lo < 0) {
+			throw new RangeError("Data pointer is out of bounds");
+		}
+		grow(hi);
+	}
+
+	// readb reads a byte into the current cell, which is left unchanged at
+	// the end of input.
+	function readb() {
+		const c = read();
+		if (c >= 0) {
+			data[p] = c;
+		}
+	}
+
+	function writeb(repeat) {
+		for (let i = 0; i < repeat; i++) {
+			write(data[p]);
+		}
+	}
+
+{{ range .Body }}{{ . }}
+{{ end -}}
+}
+
+function main() {
+	const fs = require("fs");
+	const out = new Uint8Array(64 * 1024);
+	const inbuf = new Uint8Array(1);
+	let n = 0;
+
+	function flush() {
+		for (let o = 0; o < n; ) {
+			o += fs.writeSync(1, out, o, n - o);
+		}
+		n = 0;
+	}
+
+	function read() {
+		// Make sure any prompt is visible before blocking on input
+		flush();
+		for (;;) {
+			try {
+				return fs.readSync(0, inbuf, 0, 1) === 1 ? inbuf[0] : -1;
+			} catch (e) {
+				if (e.code === "EAGAIN") {
+					continue;
+				}
+				if (e.code === "EOF") {
+					return -1;
+				}
+				throw e;
+			}
+		}
+	}
+
+	function write(b) {
+		if (n === out.length) {
+			flush();
+		}
+		out[n++] = b;
+{{- if .Unbuffered }}
+		flush();
+{{- end }}
+	}
+
+	try {
+		run(read, write);
+	} catch (e) {
+		flush();
+		process.stderr.write("Error: " + e.message + "\n");
+		process.exit(2);
+	}
+	flush();
+}
+
+if (typeof module !== "undefined") {
+	module.exports = { run };
+	if (require.main === module) {
+		main();
+	}
+}
@@ -0,0 +1,125 @@
+; BF program in LLVM IR, using libc for I/O and memory.
+;
+; The tape is the heap buffer @data of length @datalen, which grows as
+; needed, and the data pointer is the index %p.
+
+@data = internal global ptr null
+@datalen = internal global i64 0
+
+@errlow = private unnamed_addr constant [37 x i8] c"Error: Data pointer is out of bounds\0A"
+@errmem = private unnamed_addr constant [21 x i8] c"Error: Out of memory\0A"
+
+declare i32 @getchar()
+declare i32 @putchar(i32)
+declare i32 @fflush(ptr)
+declare i64 @write(i32, ptr, i64)
+declare ptr @calloc(i64, i64)
+declare ptr @realloc(ptr, i64)
+declare void @exit(i32) noreturn
+declare void @llvm.memset.p0.i64(ptr, i8, i64, i1)
+
+; fail writes the message msg of length n to standard error and exits
+; with status 2.
+define internal void @fail(ptr %msg, i64 %n) noreturn cold {
+  call i32 @fflush(ptr null)
+  call i64 @write(i32 2, ptr %msg, i64 %n)
+  call void @exit(i32 2)
+  unreachable
+}
+
+; datagrow makes sure @data holds the cell at offset hi from p.
+define internal void @datagrow(i64 %p, i64 %hi) alwaysinline {
+  %l = add i64 %p, %hi
+  %len = load i64, ptr @datalen
+  %full = icmp sge i64 %l, %len
+  br i1 %full, label %grow, label %done
+grow:
+  %newlen = mul i64 %l, 2
+  %old = load ptr, ptr @data
+  %new = call ptr @realloc(ptr %old, i64 %newlen)
+  %null = icmp eq ptr %new, null
+  br i1 %null, label %oom, label %clear
+oom:
+  call void @fail(ptr @errmem, i64 21)
+  unreachable
+clear:
+  %tail = getelementptr i8, ptr %new, i64 %len
+  %taillen = sub i64 %newlen, %len
+  call void @llvm.memset.p0.i64(ptr %tail, i8 0, i64 %taillen, i1 false)
+  store ptr %new, ptr @data
+  store i64 %newlen, ptr @datalen
+  br label %done
+done:
+  ret void
+}
+
+; datacheck fails if the cell at offset lo from p is below the tape, and
+; grows the tape to hold the cell at offset hi.
+define internal void @datacheck(i64 %p, i64 %lo, i64 %hi) alwaysinline {
+  %l = add i64 %p, %lo
+  %low = icmp slt i64 %l, 0
+  br i1 %low, label %fail, label %ok
+fail:
+  call void @fail(ptr @errlow, i64 37)
+  unreachable
+ok:
+  call void @datagrow(i64 %p, i64 %hi)
+  ret void
+}
+
+; writeb writes the cell at address a n times.
+define internal void @writeb(ptr %a, i64 %n) {
+entry:
+  %v = load i8, ptr %a
+  %c = zext i8 %v to i32
+  br label %loop
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %more = icmp slt i64 %i, %n
+  br i1 %more, label %body, label %done
+body:
+  call i32 @putchar(i32 %c)
+  %next = add i64 %i, 1
+  br label %loop
+done:
+{{- if .Unbuffered }}
+  call i32 @fflush(ptr null)
+{{- end }}
+  ret void
+}
+
+; readb reads a byte into the cell at address a, which is left unchanged
+; at the end of input.
+define internal void @readb(ptr %a) {
+  ; Make sure any prompt is visible before blocking on input
+  call i32 @fflush(ptr null)
+  %c = call i32 @getchar()
+  %eof = icmp slt i32 %c, 0
+  br i1 %eof, label %done, label %store
+store:
+  %v = trunc i32 %c to i8
+  store i8 %v, ptr %a
+  br label %done
+done:
+  ret void
+}
+
+define i32 @main() {
+entry:
+  %pp = alloca i64
+  store i64 0, ptr %pp
+  %data = call ptr @calloc(i64 {{ .InitialDataSize }}, i64 1)
+  %null = icmp eq ptr %data, null
+  br i1 %null, label %oom, label %start
+oom:
+  call void @fail(ptr @errmem, i64 21)
+  unreachable
+start:
+  store ptr %data, ptr @data
+  store i64 {{ .InitialDataSize }}, ptr @datalen
+
+{{ range .Body }}{{ . }}
+{{ end }}
+  call i32 @fflush(ptr null)
+  ret i32 0
+}
@@ -0,0 +1,71 @@
+#!/usr/bin/env python3
+"""BF program.
+
+Running this file runs the program with standard input and output.
+Otherwise, import it and call run(read, write), where read returns the
+next input byte or -1 at the end of input, and write outputs bytes.
+"""
+
+import sys
+
+
+class BFError(Exception):
+    pass
+
+
+def grow(data, hi):
+    """Make sure data holds the cell hi."""
+    if hi >= len(data):
+        data.extend(bytes(hi * 2 - len(data)))
+
+
+def check(data, lo, hi):
+    """Raise BFError if the cell lo is below the tape, and grow data to
+    hold the cell hi."""
+    if lo < 0:
+        raise BFError("Data pointer is out of bounds")
+    grow(data, hi)
+
+
+def readb(data, p, read):
+    """Read a byte into the cell p, which is left unchanged at the end of
+    input."""
+    c = read()
+    if c >= 0:
+        data[p] = c
+
+
+def run(read, write):
+    data = bytearray({{ .InitialDataSize }})
+    p = 0
+    m = 0
+{{ range .Body }}{{ . }}
+{{ end }}
+
+def main():
+    out = sys.stdout.buffer
+    inp = sys.stdin.buffer
+
+    def read():
+        # Make sure any prompt is visible before blocking on input
+        out.flush()
+        c = inp.read(1)
+        return c[0] if c else -1
+
+    def write(b):
+        out.write(b)
+{{- if .Unbuffered }}
+        out.flush()
+{{- end }}
+
+    try:
+        run(read, write)
+    except BFError as e:
+        out.flush()
+        sys.stderr.write("Error: %s\n" % e)
+        sys.exit(2)
+    out.flush()
+
+
+if __name__ == "__main__":
+    main()
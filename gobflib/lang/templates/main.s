@@ -0,0 +1,151 @@
+# BF program for Linux x86-64, without libc.
+#
+# %rbx is the data pointer, %r12 and %r13 are the start and end of the
+# tape, and %r14 is the number of bytes in outbuf. The tape is reserved
+# with mmap, so pages are only allocated when they are touched, and a
+# guard page below the tape catches unchecked moves below zero.
+
+	.equ SYS_READ, 0
+	.equ SYS_WRITE, 1
+	.equ SYS_MMAP, 9
+	.equ SYS_MPROTECT, 10
+	.equ SYS_EXIT, 60
+	.equ PAGESIZE, 4096
+	.equ TAPESIZE, {{ .TapeSize }}
+	.equ OUTBUFSIZE, 65536
+
+	.section .rodata
+errlow:
+	.ascii "Error: Data pointer is out of bounds\n"
+	.equ ERRLOWLEN, . - errlow
+errhigh:
+	.ascii "Error: Tape is full\n"
+	.equ ERRHIGHLEN, . - errhigh
+errmmap:
+	.ascii "Error: Out of memory\n"
+	.equ ERRMMAPLEN, . - errmmap
+
+	.section .bss
+	.lcomm outbuf, OUTBUFSIZE
+
+	.section .text
+
+# flush writes the bytes in outbuf to standard output.
+flush:
+	xor %r15, %r15
+1:
+	cmp %r14, %r15
+	jae 2f
+	mov $SYS_WRITE, %eax
+	mov $1, %edi
+	lea outbuf(%rip), %rsi
+	add %r15, %rsi
+	mov %r14, %rdx
+	sub %r15, %rdx
+	syscall
+	test %rax, %rax
+	jle 2f
+	add %rax, %r15
+	jmp 1b
+2:
+	xor %r14, %r14
+	ret
+
+# writeb writes the current cell %rdi times.
+writeb:
+	test %rdi, %rdi
+	jz 2f
+	movb (%rbx), %al
+	lea outbuf(%rip), %rsi
+1:
+	cmp $OUTBUFSIZE, %r14
+	jb 3f
+	push %rdi
+	push %rax
+	call flush
+	pop %rax
+	pop %rdi
+	lea outbuf(%rip), %rsi
+3:
+	movb %al, (%rsi,%r14)
+	inc %r14
+	dec %rdi
+	jnz 1b
+{{- if .Unbuffered }}
+	call flush
+{{- end }}
+2:
+	ret
+
+# readb reads a byte into the current cell, which is left unchanged at
+# the end of input.
+readb:
+	# Make sure any prompt is visible before blocking on input
+	call flush
+	mov $SYS_READ, %eax
+	xor %edi, %edi
+	mov %rbx, %rsi
+	mov $1, %edx
+	syscall
+	ret
+
+# fail writes the message at %rsi with length %rdx to standard error
+# and exits with status 2.
+fail:
+	push %rsi
+	push %rdx
+	call flush
+	pop %rdx
+	pop %rsi
+	mov $SYS_WRITE, %eax
+	mov $2, %edi
+	syscall
+	mov $SYS_EXIT, %eax
+	mov $2, %edi
+	syscall
+
+oob_low:
+	lea errlow(%rip), %rsi
+	mov $ERRLOWLEN, %edx
+	jmp fail
+
+oob_high:
+	lea errhigh(%rip), %rsi
+	mov $ERRHIGHLEN, %edx
+	jmp fail
+
+	.globl _start
+_start:
+	# Reserve the tape and a guard page below it
+	mov $SYS_MMAP, %eax
+	xor %edi, %edi
+	mov $(TAPESIZE + PAGESIZE), %rsi
+	mov $3, %edx            # PROT_READ | PROT_WRITE
+	mov $0x4022, %r10d      # MAP_PRIVATE | MAP_ANONYMOUS | MAP_NORESERVE
+	mov $-1, %r8
+	xor %r9d, %r9d
+	syscall
+	cmp $-4096, %rax
+	jbe 1f
+	lea errmmap(%rip), %rsi
+	mov $ERRMMAPLEN, %edx
+	jmp fail
+1:
+	mov %rax, %r12
+	mov %rax, %rdi
+	mov $SYS_MPROTECT, %eax
+	mov $PAGESIZE, %esi
+	xor %edx, %edx          # PROT_NONE
+	syscall
+	add $PAGESIZE, %r12
+	mov %r12, %r13
+	add $TAPESIZE, %r13
+	mov %r12, %rbx
+	xor %r14, %r14
+
+{{ range .Body }}{{ . }}
+{{ end }}
+	call flush
+	mov $SYS_EXIT, %eax
+	xor %edi, %edi
+	syscall
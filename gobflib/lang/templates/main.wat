@@ -0,0 +1,74 @@
+(module
+{{- if .WASI }}
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+{{- else }}
+  ;; read returns the next input byte, or -1 at the end of input
+  (import "env" "read" (func $read (result i32)))
+  ;; write outputs the low byte of its parameter
+  (import "env" "write" (func $write (param i32)))
+{{- end }}
+
+  ;; The tape starts at address {{ .TapeBase }}, after a scratch area
+  ;; used for I/O vectors
+  (memory (export "memory") {{ .MemoryPages }})
+
+  ;; grow makes sure memory holds the cell at address hi
+  (func $grow (param $hi i32)
+    (if (i32.ge_u (local.get $hi) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (if (i32.lt_s
+              (memory.grow (i32.sub
+                (i32.add (i32.shr_u (local.get $hi) (i32.const 15)) (i32.const 2))
+                (memory.size)))
+              (i32.const 0))
+          (then (unreachable))))))
+
+  ;; check traps if the data pointer moved below the tape, and grows
+  ;; memory to hold the cell at address p
+  (func $check (param $p i32)
+    (if (i32.lt_s (local.get $p) (i32.const {{ .TapeBase }}))
+      (then (unreachable)))
+    (call $grow (local.get $p)))
+
+  ;; readb reads a byte into the cell at address p, which is left
+  ;; unchanged at the end of input
+  (func $readb (param $p i32)
+{{- if .WASI }}
+    (i32.store (i32.const 0) (i32.const 8))
+    (i32.store (i32.const 4) (i32.const 1))
+    (drop (call $fd_read (i32.const 0) (i32.const 0) (i32.const 1) (i32.const 12)))
+    (if (i32.load (i32.const 12))
+      (then (i32.store8 (local.get $p) (i32.load8_u (i32.const 8))))))
+{{- else }}
+    (local $c i32)
+    (local.set $c (call $read))
+    (if (i32.ge_s (local.get $c) (i32.const 0))
+      (then (i32.store8 (local.get $p) (local.get $c)))))
+{{- end }}
+
+  ;; writeb writes the cell at address p n times
+  (func $writeb (param $p i32) (param $n i32)
+{{- if .WASI }}
+    (i32.store (i32.const 0) (i32.const 8))
+    (i32.store (i32.const 4) (i32.const 1))
+    (i32.store8 (i32.const 8) (i32.load8_u (local.get $p)))
+{{- end }}
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+{{- if .WASI }}
+        (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 12)))
+{{- else }}
+        (call $write (i32.load8_u (local.get $p)))
+{{- end }}
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next))))
+
+  (func $run (export "{{ if .WASI }}_start{{ else }}run{{ end }}")
+    (local $p i32)
+    (local $m i32)
+    (local.set $p (i32.const {{ .TapeBase }}))
+{{ range .Body }}{{ . }}
+{{ end }}  )
+)
@@ -0,0 +1,343 @@
+package lang
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// sexpr is a parsed WebAssembly text s-expression, which is either an
+// atom or a list.
+type sexpr struct {
+	atom string
+	list []*sexpr
+	line int
+}
+
+func (e *sexpr) isList() bool {
+	return e.list != nil
+}
+
+// head returns the first atom of a list, like "func" for (func ...).
+func (e *sexpr) head() string {
+	if len(e.list) == 0 || e.list[0].isList() {
+		return ""
+	}
+	return e.list[0].atom
+}
+
+// parseSexprs parses WebAssembly text into its top level s-expressions.
+// Line comments ;; and block comments (; ;) are skipped.
+func parseSexprs(src string) ([]*sexpr, error) {
+	var stack [][]*sexpr
+	var lines []int
+	var top []*sexpr
+	line := 1
+
+	add := func(e *sexpr) {
+		if len(stack) == 0 {
+			top = append(top, e)
+		} else {
+			stack[len(stack)-1] = append(stack[len(stack)-1], e)
+		}
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], ";;"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "(;"):
+			end := strings.Index(src[i:], ";)")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated block comment", line)
+			}
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + 2
+		case c == '(':
+			stack = append(stack, []*sexpr{})
+			lines = append(lines, line)
+			i++
+		case c == ')':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected )", line)
+			}
+			list := stack[len(stack)-1]
+			e := &sexpr{list: list, line: lines[len(lines)-1]}
+			stack, lines = stack[:len(stack)-1], lines[:len(lines)-1]
+			add(e)
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			add(&sexpr{atom: src[i : j+1], line: line})
+			i = j + 1
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\r\n()\";", rune(src[j])) {
+				j++
+			}
+			add(&sexpr{atom: src[i:j], line: line})
+			i = j
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("line %d: unclosed (", lines[len(lines)-1])
+	}
+	return top, nil
+}
+
+// watModuleFields are the fields allowed in a module.
+var watModuleFields = map[string]bool{
+	"type": true, "import": true, "func": true, "table": true, "memory": true,
+	"global": true, "export": true, "start": true, "elem": true, "data": true,
+}
+
+// watInstrs are the instructions accepted by validateWAT, with the kind of
+// name their immediate refers to, if any.
+var watInstrs = map[string]string{
+	"unreachable": "", "nop": "", "drop": "", "select": "", "return": "",
+	"block": "", "loop": "", "if": "", "br": "label", "br_if": "label", "call": "func",
+	"local.get": "local", "local.set": "local", "local.tee": "local",
+	"global.get": "", "global.set": "",
+	"memory.size": "", "memory.grow": "",
+	"i32.const": "", "i32.eqz": "", "i32.eq": "", "i32.ne": "",
+	"i32.lt_s": "", "i32.lt_u": "", "i32.gt_s": "", "i32.gt_u": "",
+	"i32.le_s": "", "i32.le_u": "", "i32.ge_s": "", "i32.ge_u": "",
+	"i32.add": "", "i32.sub": "", "i32.mul": "", "i32.and": "", "i32.or": "",
+	"i32.xor": "", "i32.shl": "", "i32.shr_s": "", "i32.shr_u": "",
+	"i32.load": "", "i32.load8_u": "", "i32.load8_s": "",
+	"i32.store": "", "i32.store8": "",
+}
+
+type watValidator struct {
+	funcs  map[string]bool
+	locals map[string]bool
+	labels []string
+}
+
+func (v *watValidator) hasLabel(name string) bool {
+	for _, l := range v.labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// name checks that the immediate name of kind is defined.
+func (v *watValidator) name(kind string, e *sexpr) error {
+	if e == nil || e.isList() || !strings.HasPrefix(e.atom, "$") {
+		return fmt.Errorf("missing %s name", kind)
+	}
+	switch kind {
+	case "func":
+		if !v.funcs[e.atom] {
+			return fmt.Errorf("line %d: call of undefined function %s", e.line, e.atom)
+		}
+	case "local":
+		if !v.locals[e.atom] {
+			return fmt.Errorf("line %d: use of undefined local %s", e.line, e.atom)
+		}
+	case "label":
+		if !v.hasLabel(e.atom) {
+			return fmt.Errorf("line %d: branch to undefined label %s", e.line, e.atom)
+		}
+	}
+	return nil
+}
+
+// immediate reports if the atom a is a valid instruction immediate.
+func watImmediate(a string) bool {
+	if strings.HasPrefix(a, "offset=") || strings.HasPrefix(a, "align=") {
+		a = a[strings.IndexByte(a, '=')+1:]
+	}
+	_, err := strconv.ParseInt(a, 0, 64)
+	return err == nil
+}
+
+// instrs checks a sequence of instructions, which may be folded lists
+// or plain atoms.
+func (v *watValidator) instrs(es []*sexpr) error {
+	for i := 0; i < len(es); i++ {
+		e := es[i]
+		if e.isList() {
+			if err := v.instr(e); err != nil {
+				return err
+			}
+			continue
+		}
+		kind, ok := watInstrs[e.atom]
+		if !ok {
+			if watImmediate(e.atom) {
+				continue
+			}
+			return fmt.Errorf("line %d: unknown instruction %s", e.line, e.atom)
+		}
+		if kind != "" {
+			i++
+			var n *sexpr
+			if i < len(es) {
+				n = es[i]
+			}
+			if err := v.name(kind, n); err != nil {
+				return fmt.Errorf("line %d: %s: %v", e.line, e.atom, err)
+			}
+		}
+	}
+	return nil
+}
+
+// instr checks a folded instruction, like (i32.add (...) (...)).
+func (v *watValidator) instr(e *sexpr) error {
+	h := e.head()
+	args := e.list[1:]
+	switch h {
+	case "block", "loop":
+		label := ""
+		if len(args) > 0 && !args[0].isList() && strings.HasPrefix(args[0].atom, "$") {
+			label, args = args[0].atom, args[1:]
+		}
+		v.labels = append(v.labels, label)
+		defer func() { v.labels = v.labels[:len(v.labels)-1] }()
+		return v.instrs(args)
+	case "if":
+		v.labels = append(v.labels, "")
+		defer func() { v.labels = v.labels[:len(v.labels)-1] }()
+		for _, a := range args {
+			if a.isList() && (a.head() == "then" || a.head() == "else") {
+				if err := v.instrs(a.list[1:]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := v.instrs([]*sexpr{a}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "result":
+		return nil
+	}
+	if _, ok := watInstrs[h]; !ok {
+		return fmt.Errorf("line %d: unknown instruction %s", e.line, h)
+	}
+	return v.instrs(e.list)
+}
+
+// funcSig collects the names of a function's params and locals, and
+// returns the rest of the function, which is its body.
+func (v *watValidator) funcSig(args []*sexpr) ([]*sexpr, error) {
+	v.locals = make(map[string]bool)
+	for len(args) > 0 && args[0].isList() {
+		switch args[0].head() {
+		case "param", "local":
+			for _, a := range args[0].list[1:] {
+				if strings.HasPrefix(a.atom, "$") {
+					v.locals[a.atom] = true
+				}
+			}
+		case "export", "result", "type":
+		default:
+			return args, nil
+		}
+		args = args[1:]
+	}
+	return args, nil
+}
+
+// validateWAT checks the structure of a WebAssembly text module, like the
+// ones written by ILBlockToWAT. It checks that parentheses are balanced,
+// the module only has known fields and instructions, it declares a memory,
+// and that every called function, local, and branch label is defined.
+// It does not type check the module.
+func validateWAT(input io.Reader) error {
+	src, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	top, err := parseSexprs(string(src))
+	if err != nil {
+		return err
+	}
+	if len(top) != 1 || top[0].head() != "module" {
+		return fmt.Errorf("expected a single (module ...)")
+	}
+	fields := top[0].list[1:]
+
+	// Function names can be used before their definition
+	v := &watValidator{funcs: make(map[string]bool)}
+	var memory bool
+	for _, f := range fields {
+		if !f.isList() || !watModuleFields[f.head()] {
+			return fmt.Errorf("line %d: unknown module field", f.line)
+		}
+		switch f.head() {
+		case "func":
+			if len(f.list) > 1 && strings.HasPrefix(f.list[1].atom, "$") {
+				v.funcs[f.list[1].atom] = true
+			}
+		case "import":
+			if len(f.list) != 4 || !f.list[3].isList() {
+				return fmt.Errorf("line %d: malformed import", f.line)
+			}
+			desc := f.list[3]
+			if desc.head() == "func" && len(desc.list) > 1 && strings.HasPrefix(desc.list[1].atom, "$") {
+				v.funcs[desc.list[1].atom] = true
+			}
+			if desc.head() == "memory" {
+				memory = true
+			}
+		case "memory":
+			memory = true
+		}
+	}
+	if !memory {
+		return fmt.Errorf("module does not declare a memory")
+	}
+
+	for _, f := range fields {
+		switch f.head() {
+		case "func":
+			args := f.list[1:]
+			if len(args) > 0 && !args[0].isList() {
+				args = args[1:]
+			}
+			body, err := v.funcSig(args)
+			if err != nil {
+				return err
+			}
+			if err := v.instrs(body); err != nil {
+				return err
+			}
+		case "export":
+			if len(f.list) != 3 || !f.list[2].isList() {
+				return fmt.Errorf("line %d: malformed export", f.line)
+			}
+			desc := f.list[2]
+			if desc.head() == "func" {
+				if err := v.name("func", desc.list[len(desc.list)-1]); err != nil {
+					return fmt.Errorf("line %d: export: %v", f.line, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package gobflib
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+	"github.com/linux4life798/gobf/gobflib/lang"
+)
+
+// minifyPipelines are the semantics preserving optimizations tried by
+// Minify. Each pipeline is run on a fresh IL tree.
+var minifyPipelines = []func(b *il.ILBlock){
+	func(b *il.ILBlock) {
+		b.Compress()
+		b.Prune()
+	},
+	func(b *il.ILBlock) {
+		b.Compress()
+		b.Prune()
+		b.Vectorize()
+		b.Prune()
+		b.Compress()
+		b.Prune()
+	},
+	func(b *il.ILBlock) {
+		b.Compress()
+		b.Prune()
+		b.Vectorize()
+		b.Prune()
+		b.Compress()
+		b.Prune()
+		b.PatternReplace(il.PatternReplaceZero)
+		b.Compress()
+		b.Prune()
+	},
+	func(b *il.ILBlock) {
+		b.Compress()
+		b.Prune()
+		b.Vectorize()
+		b.Prune()
+		b.Compress()
+		b.Prune()
+		b.PatternReplace(il.PatternReplaceLinearVector)
+		b.Compress()
+		b.Prune()
+		b.PatternReplace(il.PatternReplaceZero)
+		b.Compress()
+		b.Prune()
+	},
+}
+
+// Minify writes the shortest equivalent BF program it can find for p.
+// Comments are dropped and canceling commands, like +- and <>, are removed.
+func (p *BFProgram) Minify(output io.Writer) error {
+	var shortest *bytes.Buffer
+	var firstErr error
+	for _, pipeline := range minifyPipelines {
+		b := p.CreateILTree()
+		pipeline(b)
+
+		var out bytes.Buffer
+		if err := lang.ILBlockToBF(b, &out); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if shortest == nil || out.Len() < shortest.Len() {
+			shortest = &out
+		}
+	}
+	if shortest == nil {
+		return firstErr
+	}
+	_, err := shortest.WriteTo(output)
+	return err
+}
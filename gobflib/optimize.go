@@ -0,0 +1,160 @@
+package gobflib
+
+import "github.com/linux4life798/gobf/gobflib/il"
+
+// OptimizePass is one optimization pass over an IL tree, which returns
+// the number of changes it made.
+type OptimizePass struct {
+	Name string
+	Run  func(b *il.ILBlock) int
+}
+
+// The optimization passes, named like the passes gobf can highlight.
+var (
+	PassCompress  = OptimizePass{"compress", (*il.ILBlock).Compress}
+	PassPrune     = OptimizePass{"prune", (*il.ILBlock).Prune}
+	PassVectorize = OptimizePass{"vectorize", (*il.ILBlock).Vectorize}
+	PassBalance   = BalancePass(&il.DefaultCostModel)
+	PassLinVector = LinVectorPass(&il.DefaultCostModel)
+	PassZero      = ZeroPass(&il.DefaultCostModel)
+	PassHoist     = OptimizePass{"hoist", (*il.ILBlock).HoistLoopInvariants}
+	PassCollapse  = OptimizePass{"collapse", (*il.ILBlock).CollapseLoops}
+	PassUnroll    = OptimizePass{"unroll", (*il.ILBlock).UnrollLoops}
+)
+
+// BalancePass returns the balance pass with the costs of the model m.
+func BalancePass(m *il.CostModel) OptimizePass {
+	return OptimizePass{"balance", func(b *il.ILBlock) int {
+		return b.VectorBalanceWith(m)
+	}}
+}
+
+// LinVectorPass returns the lvec pass with the costs of the model m.
+func LinVectorPass(m *il.CostModel) OptimizePass {
+	return OptimizePass{"lvec", func(b *il.ILBlock) int {
+		return b.PatternReplaceWith(m, il.PatternReplaceLinearVector)
+	}}
+}
+
+// ZeroPass returns the zero pass with the costs of the model m.
+func ZeroPass(m *il.CostModel) OptimizePass {
+	return OptimizePass{"zero", func(b *il.ILBlock) int {
+		return b.PatternReplaceWith(m, il.PatternReplaceZero)
+	}}
+}
+
+// OptimizeLevel is a named pipeline of optimization passes, which matches
+// a combination of the optimization flags of gobf.
+type OptimizeLevel struct {
+	Name   string
+	Passes []OptimizePass
+}
+
+// WithCosts returns the level with the passes that weigh costs using the
+// model m, like gobf does with a cost profile.
+func (level OptimizeLevel) WithCosts(m *il.CostModel) OptimizeLevel {
+	passes := make([]OptimizePass, len(level.Passes))
+	for i, pass := range level.Passes {
+		switch pass.Name {
+		case PassBalance.Name:
+			pass = BalancePass(m)
+		case PassLinVector.Name:
+			pass = LinVectorPass(m)
+		case PassZero.Name:
+			pass = ZeroPass(m)
+		}
+		passes[i] = pass
+	}
+	return OptimizeLevel{level.Name, passes}
+}
+
+// Optimize runs the passes of the level on b.
+func (level OptimizeLevel) Optimize(b *il.ILBlock) {
+	for _, pass := range level.Passes {
+		pass.Run(b)
+	}
+}
+
+// compressPrune are the passes that end every pipeline.
+var compressPrune = []OptimizePass{PassPrune, PassCompress, PassPrune}
+
+// OptimizeFlags selects the optimization passes like the flags of gobf.
+type OptimizeFlags struct {
+	Compress bool
+	Prune    bool
+	// Vectorize vectorizes the adds, and FullVectorize also keeps the
+	// vectors that cost more than the adds they replace
+	Vectorize     bool
+	FullVectorize bool
+	// Optimizations names the optional optimizations, of loops, lvec and
+	// zero. Other names are ignored.
+	Optimizations []string
+}
+
+// Passes returns the passes that the flags select, in the order gobf runs
+// them.
+func (f OptimizeFlags) Passes() []OptimizePass {
+	enabled := func(name string) bool {
+		for _, opt := range f.Optimizations {
+			if opt == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var passes []OptimizePass
+	if f.Compress {
+		passes = append(passes, PassCompress)
+	}
+	if f.Prune {
+		passes = append(passes, PassPrune)
+	}
+	if enabled("loops") {
+		passes = append(passes, PassHoist, PassCompress, PassPrune, PassCollapse, PassUnroll)
+		passes = append(passes, compressPrune...)
+	}
+	if f.Vectorize || f.FullVectorize {
+		passes = append(passes, PassVectorize)
+		if !f.FullVectorize {
+			passes = append(passes, PassBalance)
+		}
+		// Prune the data pointer adds of 0 that vectors leave
+		passes = append(passes, compressPrune...)
+	}
+	if enabled("lvec") {
+		passes = append(passes, PassVectorize)
+		passes = append(passes, compressPrune...)
+		passes = append(passes, PassLinVector, PassCompress, PassPrune)
+		if !f.FullVectorize {
+			passes = append(passes, PassBalance)
+			passes = append(passes, compressPrune...)
+		}
+	}
+	if enabled("zero") {
+		passes = append(passes, PassZero, PassCompress, PassPrune)
+	}
+	return passes
+}
+
+// OptimizeLevels are the optimization levels that every program must give
+// the same output for, from no passes to all of them.
+var OptimizeLevels = []OptimizeLevel{
+	{"none", nil},
+	{"default", OptimizeFlags{Compress: true, Prune: true}.Passes()},
+	{"vectorize", OptimizeFlags{Compress: true, Prune: true, Vectorize: true}.Passes()},
+	{"full", OptimizeFlags{Compress: true, Prune: true, FullVectorize: true}.Passes()},
+	{"lvec", OptimizeFlags{Compress: true, Prune: true, Optimizations: []string{"lvec"}}.Passes()},
+	{"loops", OptimizeFlags{Compress: true, Prune: true, Optimizations: []string{"loops"}}.Passes()},
+	{"all", OptimizeFlags{Compress: true, Prune: true, Optimizations: []string{"loops", "lvec", "zero"}}.Passes()},
+}
+
+// LookupOptimizeLevel returns the optimization level named name.
+func LookupOptimizeLevel(name string) (OptimizeLevel, bool) {
+	for _, level := range OptimizeLevels {
+		if level.Name == name {
+			return level, true
+		}
+	}
+	return OptimizeLevel{}, false
+}
@@ -0,0 +1,208 @@
+package gobflib
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// DefaultMaxSteps is the default step limit of Diverges. Programs that run
+// longer are assumed to never end.
+const DefaultMaxSteps = 10000000
+
+// ilStepsFactor multiplies the step limit of the interpreter for running
+// the IL, since the steps of a tree count blocks instead of commands, and
+// the optimizations may make a program take more of them.
+const ilStepsFactor = 1000
+
+// Diverges returns an error describing how the IL tree of the BF program
+// src, optimized with level, behaves differently from the interpreter, or
+// nil if they agree.
+// They are only compared if the interpreter runs the program within
+// maxSteps steps, without moving the data pointer below zero, since the
+// optimizations may drop moves that cancel out, and if its loops are
+// balanced. The IL gets ilStepsFactor times as many steps, so that
+// it only fails with il.ErrStepLimit if it doesn't end.
+func Diverges(src, input []byte, level OptimizeLevel, maxSteps int64) error {
+	var expected bytes.Buffer
+	prgm := NewIOBFProgram(uint64(len(src)), 0, bytes.NewReader(input), &expected)
+	if err := prgm.ReadCommands(bytes.NewReader(src)); err != nil {
+		return nil
+	}
+	for steps := int64(0); ; steps++ {
+		done, err := prgm.RunStep()
+		if err != nil || steps > maxSteps {
+			return nil
+		}
+		if done {
+			break
+		}
+	}
+
+	b := prgm.CreateILTree()
+	level.Optimize(b)
+	r := il.Runner{MaxSteps: maxSteps * ilStepsFactor}
+	var out bytes.Buffer
+	if err := r.Run(b, bytes.NewReader(input), &out); err != nil {
+		return fmt.Errorf("with level %s, the program failed: %v", level.Name, err)
+	}
+	if !bytes.Equal(out.Bytes(), expected.Bytes()) {
+		return fmt.Errorf("with level %s, the output is %q, expected %q", level.Name, out.Bytes(), expected.Bytes())
+	}
+	tape := bytes.TrimRight(prgm.data, "\x00")
+	if !bytes.Equal(bytes.TrimRight(r.Data, "\x00"), tape) || r.Ptr != int64(prgm.dataptr) {
+		return fmt.Errorf("with level %s, the program ends with tape %v at cell %d, expected %v at cell %d",
+			level.Name, bytes.TrimRight(r.Data, "\x00"), r.Ptr, tape, prgm.dataptr)
+	}
+	return nil
+}
+
+// DivergentPass returns the number of passes of level that the IL tree of
+// the BF program src must be optimized with to diverge from the
+// interpreter, see Diverges. The last of those passes is the culprit,
+// unless it is 0, when the unoptimized tree diverges already.
+// It returns false if the program doesn't diverge with level.
+func DivergentPass(src, input []byte, level OptimizeLevel, maxSteps int64) (int, bool) {
+	for n := 0; n <= len(level.Passes); n++ {
+		prefix := OptimizeLevel{Name: level.Name, Passes: level.Passes[:n]}
+		if Diverges(src, input, prefix, maxSteps) != nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// ReduceStats counts the work done by Reduce.
+type ReduceStats struct {
+	// Tests is the number of candidate programs that interesting checked
+	Tests int
+}
+
+// Reduce shrinks the BF program src while interesting holds, in the style
+// of delta debugging, and returns the smallest program found. It removes
+// chunks of commands, halving their size when none can go, and then the
+// brackets of single loops, until nothing more can be removed.
+// Characters other than BF commands are dropped first, so comments should
+// already be removed. interesting is only called with programs with
+// balanced loops, and must hold for src.
+func Reduce(src []byte, interesting func(src []byte) bool) ([]byte, ReduceStats) {
+	var stats ReduceStats
+	tried := make(map[string]bool)
+	test := func(cand []byte) bool {
+		if !balanced(cand) {
+			return false
+		}
+		if ok, found := tried[string(cand)]; found {
+			return ok
+		}
+		stats.Tests++
+		ok := interesting(cand)
+		tried[string(cand)] = ok
+		return ok
+	}
+
+	cmds := bytes.Map(func(r rune) rune {
+		if bytes.ContainsRune([]byte("+-<>.,[]"), r) {
+			return r
+		}
+		return -1
+	}, src)
+
+	for {
+		size := len(cmds)
+		cmds = reduceChunks(cmds, test)
+		cmds = reduceLoops(cmds, test)
+		if len(cmds) == size {
+			return cmds, stats
+		}
+	}
+}
+
+// reduceChunks removes chunks of cmds while test holds, starting with
+// halves, and splitting the chunks further when none can be removed.
+func reduceChunks(cmds []byte, test func([]byte) bool) []byte {
+	parts := 2
+	for len(cmds) > 0 {
+		if parts > len(cmds) {
+			parts = len(cmds)
+		}
+		chunk := (len(cmds) + parts - 1) / parts
+		removed := false
+		for start := 0; start < len(cmds); start += chunk {
+			end := start + chunk
+			if end > len(cmds) {
+				end = len(cmds)
+			}
+			cand := append(append([]byte(nil), cmds[:start]...), cmds[end:]...)
+			if test(cand) {
+				cmds = cand
+				removed = true
+				break
+			}
+		}
+		if removed {
+			if parts > 2 {
+				parts--
+			}
+			continue
+		}
+		if chunk == 1 {
+			break
+		}
+		parts *= 2
+	}
+	return cmds
+}
+
+// reduceLoops removes the brackets of each loop in cmds, keeping its body,
+// while test holds.
+func reduceLoops(cmds []byte, test func([]byte) bool) []byte {
+	for open := 0; open < len(cmds); open++ {
+		if cmds[open] != '[' {
+			continue
+		}
+		end := matchingBracket(cmds, open)
+		cand := append(append(append([]byte(nil), cmds[:open]...), cmds[open+1:end]...), cmds[end+1:]...)
+		if test(cand) {
+			cmds = cand
+			open--
+		}
+	}
+	return cmds
+}
+
+// matchingBracket returns the index of the ] that closes the [ at open in
+// the balanced cmds.
+func matchingBracket(cmds []byte, open int) int {
+	depth := 0
+	for i := open; i < len(cmds); i++ {
+		switch cmds[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(cmds)
+}
+
+// balanced reports if every loop in cmds is closed.
+func balanced(cmds []byte) bool {
+	depth := 0
+	for _, c := range cmds {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return false
+			}
+			depth--
+		}
+	}
+	return depth == 0
+}
@@ -0,0 +1,58 @@
+package gobflib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+// passTripleAdd is a broken pass that doubles the adds of 3.
+var passTripleAdd = OptimizePass{"triple", func(b *il.ILBlock) int {
+	var count int
+	for _, ib := range b.GetInner() {
+		if ib.GetType() == il.ILDataAdd && ib.GetParam() == 3 {
+			ib.SetParam(6)
+			count++
+		}
+	}
+	return count
+}}
+
+func TestReduce(t *testing.T) {
+	broken := OptimizeLevel{"broken", []OptimizePass{PassCompress, passTripleAdd, PassPrune}}
+	src := []byte("++>+[-<+>]<.>>+++<<[->>+<<]>>.[-]++++.")
+	interesting := func(src []byte) bool {
+		return Diverges(src, nil, broken, DefaultMaxSteps) != nil
+	}
+	if !interesting(src) {
+		t.Fatal("the broken level does not diverge")
+	}
+
+	reduced, stats := Reduce(src, interesting)
+	if string(reduced) != "+++" {
+		t.Errorf("Reduce returned %q, expected %q", reduced, "+++")
+	}
+	if stats.Tests == 0 {
+		t.Error("Reduce did not count its tests")
+	}
+
+	n, ok := DivergentPass(reduced, nil, broken, DefaultMaxSteps)
+	if !ok || n != 2 {
+		t.Errorf("DivergentPass returned %d, %v, expected pass 2", n, ok)
+	}
+	if _, ok := DivergentPass(reduced, nil, OptimizeLevels[len(OptimizeLevels)-1], DefaultMaxSteps); ok {
+		t.Error("DivergentPass found a divergence with a working level")
+	}
+}
+
+func TestReduceLoops(t *testing.T) {
+	// The loop around ++. can only go on its own
+	interesting := func(src []byte) bool {
+		return strings.Contains(string(src), "++.")
+	}
+	reduced, _ := Reduce([]byte("+[-]>,[>+<-]\n[++.]"), interesting)
+	if string(reduced) != "++." {
+		t.Errorf("Reduce returned %q, expected %q", reduced, "++.")
+	}
+}
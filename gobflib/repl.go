@@ -0,0 +1,281 @@
+package gobflib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/linux4life798/gobf/gobflib/il"
+)
+
+const (
+	// replDataSize is the initial tape size of the REPL, which grows
+	// as needed. It is small to keep the undo snapshots cheap.
+	replDataSize = 16
+	// replUndoLimit is the number of lines that can be undone.
+	replUndoLimit = 100
+	// replTapeWidth is the number of cells shown by :tape.
+	replTapeWidth = 32
+)
+
+const replHelp = `Lines of BF commands run on one tape, and a line with an unclosed [
+continues on the next line. Input for , is read from the following lines.
+Meta-commands:
+  :tape       show the tape around the data pointer
+  :reset      clear the program and the tape
+  :il         show the optimized IL of the last line
+  :load file  run the BF file
+  :undo       undo the last line
+  :help       show this help
+  :quit       exit
+`
+
+// REPL runs BF lines interactively, keeping one program and tape between
+// lines.
+type REPL struct {
+	in  *bufio.Reader
+	out *lastByteWriter
+
+	prgm *BFProgram
+	// undo holds the program before each of the last lines
+	undo []*BFProgram
+	// pending holds the lines of an unclosed loop
+	pending string
+	// last is the source of the last line that ran
+	last string
+
+	// Optimize returns the optimized IL tree shown by :il for the BF
+	// source src. By default, it compresses and prunes the tree.
+	Optimize func(src string) (*il.ILBlock, error)
+}
+
+// lastByteWriter remembers the last byte written, to end the program's
+// output with a newline before the next prompt.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+	return w.w.Write(p)
+}
+
+// NewREPL returns a REPL that reads lines and program input from in, and
+// writes prompts and program output to out.
+func NewREPL(in io.Reader, out io.Writer) *REPL {
+	r := &REPL{
+		in:  bufio.NewReader(in),
+		out: &lastByteWriter{w: out, last: '\n'},
+	}
+	r.Optimize = func(src string) (*il.ILBlock, error) {
+		prgm := NewBFProgram(0, 0)
+		if err := prgm.ReadCommands(strings.NewReader(src)); err != nil {
+			return nil, err
+		}
+		b := prgm.CreateILTree()
+		b.Compress()
+		b.Prune()
+		return b, nil
+	}
+	r.reset()
+	return r
+}
+
+func (r *REPL) reset() {
+	r.prgm = NewIOBFProgram(0, replDataSize, r.in, r.out)
+	r.undo = nil
+	r.pending = ""
+	r.last = ""
+}
+
+// Run reads and runs lines until the end of input or :quit.
+func (r *REPL) Run() error {
+	for {
+		if r.out.last != '\n' {
+			fmt.Fprintln(r.out)
+		}
+		if r.pending != "" {
+			fmt.Fprint(r.out, "... ")
+		} else {
+			fmt.Fprint(r.out, "bf> ")
+		}
+
+		line, err := r.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" && err == io.EOF {
+			fmt.Fprintln(r.out)
+			return nil
+		}
+		// The line ends with the newline that was typed
+		r.out.last = '\n'
+		if r.Eval(strings.TrimRight(line, "\r\n")) {
+			return nil
+		}
+	}
+}
+
+// Eval runs one line, which is BF commands or a meta-command.
+// It returns true for :quit.
+func (r *REPL) Eval(line string) bool {
+	if r.pending != "" || !strings.HasPrefix(line, ":") {
+		r.runSource(line + "\n")
+		return false
+	}
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":quit", ":q":
+		return true
+	case ":help", ":h":
+		fmt.Fprint(r.out, replHelp)
+	case ":tape":
+		r.printTape()
+	case ":reset":
+		r.reset()
+	case ":il":
+		if r.last == "" {
+			r.errorf("No line has run yet")
+			break
+		}
+		b, err := r.Optimize(r.last)
+		if err != nil {
+			r.printError(err)
+			break
+		}
+		b.Dump(r.out, 0)
+	case ":load":
+		if len(fields) != 2 {
+			r.errorf("Usage: :load file")
+			break
+		}
+		src, err := ioutil.ReadFile(fields[1])
+		if err != nil {
+			r.errorf("%v", err)
+			break
+		}
+		r.runSource(string(src))
+		if r.pending != "" {
+			r.errorf("Unclosed [ in %s", fields[1])
+			r.pending = ""
+		}
+	case ":undo":
+		if len(r.undo) == 0 {
+			r.errorf("Nothing to undo")
+			break
+		}
+		r.prgm = r.undo[len(r.undo)-1]
+		r.undo = r.undo[:len(r.undo)-1]
+		r.last = ""
+	default:
+		r.errorf("Unknown meta-command %s, see :help", fields[0])
+	}
+	return false
+}
+
+// printError prints err on its own line.
+func (r *REPL) printError(err error) {
+	if r.out.last != '\n' {
+		fmt.Fprintln(r.out)
+	}
+	fmt.Fprintln(r.out, err)
+}
+
+func (r *REPL) errorf(format string, a ...interface{}) {
+	r.printError(fmt.Errorf("Error: "+format, a...))
+}
+
+// runSource appends src to the program and runs it, once its loops are
+// closed. If the program fails, the tape is restored.
+func (r *REPL) runSource(src string) {
+	src = r.pending + src
+	depth, err := loopDepth(src)
+	if err != nil {
+		r.printError(err)
+		r.pending = ""
+		return
+	}
+	if depth > 0 {
+		r.pending = src
+		return
+	}
+	r.pending = ""
+
+	snapshot := r.prgm.Clone()
+	if err := r.prgm.ReadCommands(strings.NewReader(src)); err != nil {
+		r.printError(err)
+		r.prgm = snapshot
+		return
+	}
+	if err := r.prgm.Run(); err != nil {
+		r.printError(err)
+		fmt.Fprintln(r.out, "The line is undone")
+		r.prgm = snapshot
+		return
+	}
+	r.undo = append(r.undo, snapshot)
+	if len(r.undo) > replUndoLimit {
+		r.undo = r.undo[1:]
+	}
+	r.last = src
+}
+
+// loopDepth returns the number of unclosed loops in the BF source src,
+// or ErrUnbalancedLoopEnd.
+func loopDepth(src string) (int, error) {
+	depth := 0
+	for _, line := range strings.Split(src, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, c := range line {
+			switch c {
+			case '[':
+				depth++
+			case ']':
+				if depth == 0 {
+					return 0, ErrUnbalancedLoopEnd
+				}
+				depth--
+			}
+		}
+	}
+	return depth, nil
+}
+
+// printTape prints the cells around the data pointer, up to the last
+// nonzero cell, with the current cell in brackets.
+func (r *REPL) printTape() {
+	p := r.prgm
+	end := p.dataptr + 1
+	for i := uint64(len(p.data)); i > end; i-- {
+		if p.data[i-1] != 0 {
+			end = i
+			break
+		}
+	}
+	start := uint64(0)
+	if end-start > replTapeWidth {
+		start = end - replTapeWidth
+		if start > p.dataptr {
+			start = p.dataptr
+			end = start + replTapeWidth
+		}
+	}
+
+	fmt.Fprintf(r.out, "#%d:", start)
+	for i := start; i < end; i++ {
+		if i == p.dataptr {
+			fmt.Fprintf(r.out, " [%d]", p.data[i])
+		} else {
+			fmt.Fprintf(r.out, " %d", p.data[i])
+		}
+	}
+	fmt.Fprintln(r.out)
+}
@@ -0,0 +1,62 @@
+package gobflib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runREPL runs the REPL with the lines of script, and returns its output.
+func runREPL(t *testing.T, script string) string {
+	t.Helper()
+	out := new(bytes.Buffer)
+	if err := NewREPL(strings.NewReader(script), out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestREPL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bang.bf")
+	if err := ioutil.WriteFile(file, []byte("+++[\n>+++++++++++<-]>.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		output string
+	}{
+		{"tape is kept", "+++\n:tape\n>++\n:tape\n", "bf> bf> #0: [3]\nbf> bf> #0: 3 [2]\nbf> \n"},
+		{"unclosed loop", "++++++[>+++++++\n<-]>+.\n", "bf> ... +\nbf> \n"},
+		{"input", ",+.\nA\n", "bf> B\nbf> bf> \n"},
+		{"undo", "+\n++\n:undo\n:tape\n:undo\n:undo\n:tape\n",
+			"bf> bf> bf> bf> #0: [1]\nbf> bf> Error: Nothing to undo\nbf> #0: [0]\nbf> \n"},
+		{"failed line is undone", "+>+\n<<\n:tape\n",
+			"bf> bf> " + ErrDataPtr.Error() + "\nThe line is undone\nbf> #0: 1 [1]\nbf> \n"},
+		{"unbalanced", "]\n+\n:tape\n", "bf> " + ErrUnbalancedLoopEnd.Error() + "\nbf> bf> #0: [1]\nbf> \n"},
+		{"reset", "+>+\n:reset\n:tape\n:undo\n", "bf> bf> bf> #0: [0]\nbf> Error: Nothing to undo\nbf> \n"},
+		{"load", ":load " + file + "\n", "bf> !\nbf> \n"},
+		{"quit", "+\n:quit\n+\n", "bf> bf> "},
+		{"comment", "+ # a [ in a comment\n:tape\n", "bf> bf> #0: [1]\nbf> \n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if output := runREPL(t, test.script); output != test.output {
+				t.Errorf("REPL output is %q, expected %q", output, test.output)
+			}
+		})
+	}
+}
+
+func TestREPLIL(t *testing.T) {
+	output := runREPL(t, ":il\n++>+<\n:il\n")
+	if !strings.Contains(output, "Error: No line has run yet") {
+		t.Errorf("REPL output %q has no error for :il before a line", output)
+	}
+	if !strings.Contains(output, "ILDataAdd    | param=2") {
+		t.Errorf("REPL output %q does not show the compressed IL", output)
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/linux4life798/gobf/gobflib/il"
+	"github.com/linux4life798/gobf/gobflib/jit"
 
 	"github.com/linux4life798/gobf/gobflib/lang"
 
@@ -27,60 +34,118 @@ func dprintf(format string, a ...interface{}) {
 	}
 }
 
-func prepareIL(cmd *cobra.Command, bfinput io.Reader, bfinputsize int64) (*il.ILBlock, error) {
+// optimizationPasses lists the pass names that prepareIL can report changes for.
+var optimizationPasses = []string{"compress", "prune", "vectorize", "balance", "lvec", "zero", "hoist", "collapse", "unroll"}
+
+// costBackend returns the name of the backend that cmd optimizes the IL
+// for, which selects its cost model, or "" if it is unknown.
+func costBackend(cmd *cobra.Command) string {
+	if backend, ok := cmd.Annotations["backend"]; ok {
+		return backend
+	}
+	if f := cmd.Flags().Lookup("backend"); f != nil {
+		return f.Value.String()
+	}
+	if flagJIT, _ := cmd.Flags().GetBool("jit"); flagJIT {
+		return "jit"
+	}
+	return ""
+}
+
+// costModel returns the cost model of the backend of cmd, from the cost
+// profile selected by --cost-profile, or the default profile if it exists.
+// Without a model for the backend, the default model is used.
+func costModel(cmd *cobra.Command) *il.CostModel {
+	return backendCostModel(cmd, costBackend(cmd))
+}
+
+// backendCostModel returns the cost model of backend, from the cost
+// profile selected by the flags of cmd, like costModel.
+func backendCostModel(cmd *cobra.Command, backend string) *il.CostModel {
+	flagCostProfile, _ := cmd.Flags().GetString("cost-profile")
+	filename := flagCostProfile
+	if filename == "" {
+		var err error
+		if filename, err = il.DefaultCostProfile(); err != nil {
+			return &il.DefaultCostModel
+		}
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) && flagCostProfile == "" {
+		return &il.DefaultCostModel
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open cost profile: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	profile, err := il.ReadCostProfile(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read cost profile \"%s\": %v\n", filename, err)
+		os.Exit(1)
+	}
+	costs, ok := profile.Lookup(backend)
+	if !ok {
+		dprintf("No cost model for backend \"%s\" in %s, using the default", backend, filename)
+	}
+	return costs
+}
+
+// prepareIL reads the BF program and runs the optimization passes selected
+// by the command flags, which are the passes of the matching optimization
+// level. If the highlight flag names a pass, the set of blocks that pass
+// changed is also returned.
+func prepareIL(cmd *cobra.Command, bfinput io.Reader, bfinputsize int64) (*il.ILBlock, map[*il.ILBlock]bool, error) {
 	flagCompress, _ := cmd.Flags().GetBool("compress")
 	flagPrune, _ := cmd.Flags().GetBool("prune")
 	flagVectorize, _ := cmd.Flags().GetBool("vectorize")
 	flagFullVectorize, _ := cmd.Flags().GetBool("full-vectorize")
-	if flagFullVectorize {
-		flagVectorize = true
-	}
 	flagOpts, _ := cmd.Flags().GetStringSlice("optimize")
-	var optimization = make(map[string]bool)
-	for _, opt := range flagOpts {
-		optimization[opt] = true
+	flagHighlight, _ := cmd.Flags().GetString("highlight")
+	if flagHighlight != "" {
+		var known bool
+		for _, name := range optimizationPasses {
+			known = known || name == flagHighlight
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("unknown pass \"%s\" to highlight, must be one of %v", flagHighlight, optimizationPasses)
+		}
 	}
 
+	flags := OptimizeFlags{
+		Compress:      flagCompress,
+		Prune:         flagPrune,
+		Vectorize:     flagVectorize,
+		FullVectorize: flagFullVectorize,
+		Optimizations: flagOpts,
+	}
+	level := OptimizeLevel{Name: "flags", Passes: flags.Passes()}.WithCosts(costModel(cmd))
+
 	dprintf("Reading BF Program")
 	prgm := NewBFProgram(uint64(bfinputsize), defaultDataSize)
-	prgm.ReadCommands(bfinput)
-
-	var compressCount int
-	var pruneCount int
-	var vectorizeCount int
-	var vectorBalanceCount int
-	var optimizationCount int
+	if err := prgm.ReadCommands(bfinput); err != nil {
+		return nil, nil, err
+	}
 
 	dprintf("Generating IL Representation")
 	iltree := prgm.CreateILTree()
-	if flagCompress {
-		dprintf("Compressing IL")
-		compressCount += iltree.Compress()
-	}
-	if flagPrune {
-		dprintf("Pruning IL")
-		pruneCount += iltree.Prune()
-	}
-	if flagVectorize {
-		dprintf("Vectoring IL")
-		vectorizeCount = iltree.Vectorize()
-		if !flagFullVectorize {
-			dprintf("Rebalancing Vectorized IL")
-			vectorBalanceCount = iltree.VectorBalance()
-		}
-
-		// ILDataAdd    -1
-		// ILDataPtrAdd  0
-		// ILDataAdd     1
-
-		// prune possible datapadd(0) after vector replace
-		dprintf("Pruning IL")
-		pruneCount += iltree.Prune()
-		dprintf("Compressing IL")
-		compressCount += iltree.Compress()
-		dprintf("Pruning IL")
-		pruneCount += iltree.Prune()
 
+	var changed = make(map[*il.ILBlock]bool)
+	var counts = make(map[string]int)
+	for _, pass := range level.Passes {
+		dprintf("Running the %s pass", pass.Name)
+		if pass.Name != flagHighlight {
+			counts[pass.Name] += pass.Run(iltree)
+			continue
+		}
+		snap := iltree.Snapshot()
+		counts[pass.Name] += pass.Run(iltree)
+		for b := range snap.Changed(iltree) {
+			changed[b] = true
+		}
+	}
+
+	if flagVectorize || flagFullVectorize {
 		if count := iltree.Compress(); count > 0 {
 			fmt.Println("# Error", count, "Additional Compresses Were Necessary!")
 		}
@@ -89,82 +154,228 @@ func prepareIL(cmd *cobra.Command, bfinput io.Reader, bfinputsize int64) (*il.IL
 		}
 	}
 
-	if optimization["lvec"] {
-		dprintf("Vectorizing IL")
-		iltree.Vectorize()
-		pruneCount += iltree.Prune()
-		compressCount += iltree.Compress()
-		pruneCount += iltree.Prune()
+	if *debugEnabled {
+		for _, name := range optimizationPasses {
+			if count, ok := counts[name]; ok {
+				fmt.Printf("%-23s%d\n", name+" count:", count)
+			}
+		}
+		fmt.Println("Final Block Count:     ", iltree.BlockCount())
+	}
 
-		dprintf("Pattern Linear Vectorizing IL")
-		optimizationCount = iltree.PatternReplace(il.PatternReplaceLinearVector)
-		optimizationCount += iltree.Compress()
-		optimizationCount += iltree.Prune()
+	return iltree, changed, nil
+}
 
-		if !flagFullVectorize {
-			dprintf("Rebalancing Vectorized IL")
-			vectorBalanceCount = iltree.VectorBalance()
-			dprintf("Pruning IL")
-			pruneCount += iltree.Prune()
-			dprintf("Compressing IL")
-			compressCount += iltree.Compress()
-			dprintf("Pruning IL")
-			pruneCount += iltree.Prune()
+// genOptions collects the generated program options from the flags.
+func genOptions(cmd *cobra.Command) lang.GenOptions {
+	flagProfile, _ := cmd.Flags().GetBool("profile")
+	flagUnbuffered, _ := cmd.Flags().GetBool("unbuffered")
+	flagPackage, _ := cmd.Flags().GetString("package")
+	flagFunc, _ := cmd.Flags().GetString("func")
+	flagWASI, _ := cmd.Flags().GetBool("wasi")
+	flagTemplate, _ := cmd.Flags().GetString("template")
+	opts := lang.GenOptions{
+		Profile:    flagProfile,
+		Unbuffered: flagUnbuffered,
+		Package:    flagPackage,
+		Func:       flagFunc,
+		WASI:       flagWASI,
+	}
+	if flagTemplate != "" {
+		text, err := ioutil.ReadFile(flagTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read template \"%s\": %v\n", flagTemplate, err)
+			os.Exit(1)
 		}
+		opts.Template = string(text)
 	}
+	return opts
+}
 
-	if optimization["zero"] {
-		// TODO: Implement dataset vectoring for situations where lots
-		//       of consecutive cells are set to 0
-		dprintf("Pattern Zero Replacing IL")
-		optimizationCount = iltree.PatternReplace(il.PatternReplaceZero)
-		dprintf("Compressing IL")
-		optimizationCount += iltree.Compress()
-		dprintf("Pruning IL")
-		optimizationCount += iltree.Prune()
+func compileOptions(cmd *cobra.Command) lang.CompileOptions {
+	flagGOOS, _ := cmd.Flags().GetString("goos")
+	flagGOARCH, _ := cmd.Flags().GetString("goarch")
+	flagGccGo, _ := cmd.Flags().GetBool("gccgo")
+	flagTrimPath, _ := cmd.Flags().GetBool("trimpath")
+	flagStrip, _ := cmd.Flags().GetBool("strip")
+	flagStatic, _ := cmd.Flags().GetBool("static")
+	flagKeepSource, _ := cmd.Flags().GetBool("keep-source")
+	flagNoCache, _ := cmd.Flags().GetBool("no-cache")
+	opts := lang.CompileOptions{
+		Debug:      *debugEnabled,
+		KeepSource: flagKeepSource,
+		GOOS:       flagGOOS,
+		GOARCH:     flagGOARCH,
+		GccGo:      flagGccGo,
+		TrimPath:   flagTrimPath,
+		Strip:      flagStrip,
+		Static:     flagStatic,
 	}
+	if !flagNoCache {
+		// Without a cache directory, just build every time
+		opts.Cache, _ = lang.DefaultCache()
+	}
+	return opts
+}
 
-	if *debugEnabled {
-		if flagCompress {
-			fmt.Println("Compress Count:        ", compressCount)
-		}
-		if flagPrune {
-			fmt.Println("Prune Count:           ", pruneCount)
-		}
-		if flagVectorize {
-			fmt.Println("Vectorized Count:      ", vectorizeCount)
-			if !flagFullVectorize {
-				fmt.Println("Vectors Balance Count: ", vectorBalanceCount,
-					"(", vectorizeCount-vectorBalanceCount, "remain )")
-			}
+// defaultCache returns the compile cache, or exits.
+func defaultCache() *lang.Cache {
+	cache, err := lang.DefaultCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find the cache directory: %v\n", err)
+		os.Exit(1)
+	}
+	return cache
+}
+
+// stdioName is the file name that selects standard input or output.
+const stdioName = "-"
+
+// outputHelp describes the arguments of commands that use sourcesAndOutput.
+const outputHelp = `The bf files are concatenated into one program, and "-" is standard input or output.
+With --output, every argument is a bf file, otherwise the second argument is the output file.`
+
+// readSources reads the BF source files, where "-" is standard input, and
+// concatenates them into one program.
+func readSources(filenames []string) []byte {
+	var src []byte
+	for _, filename := range filenames {
+		var text []byte
+		var err error
+		if filename == stdioName {
+			text, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			text, err = ioutil.ReadFile(filename)
 		}
-		if len(flagOpts) > 0 {
-			fmt.Println("Optimization Count:    ", optimizationCount)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read file \"%s\": %v\n", filename, err)
+			os.Exit(1)
 		}
-		fmt.Println("Final Block Count:     ", iltree.BlockCount())
+		src = append(src, text...)
 	}
+	return src
+}
 
-	return iltree, nil
+// readCommands reads the commands of the BF source src into prgm, or
+// exits.
+func readCommands(prgm *BFProgram, src []byte) {
+	if err := prgm.ReadCommands(bytes.NewReader(src)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 }
 
-func BFRun(cmd *cobra.Command, args []string) {
-	filename := args[0]
-	f, err := os.Open(filename)
+// prepareSources reads the BF source files and optimizes them with
+// prepareIL, or exits.
+func prepareSources(cmd *cobra.Command, filenames []string) (*il.ILBlock, map[*il.ILBlock]bool) {
+	src := readSources(filenames)
+	il, changed, err := prepareIL(cmd, bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read BF and/or optimize: %v\n", err)
+		os.Exit(1)
+	}
+	return il, changed
+}
+
+// sourcesAndOutput splits args into the source files and the output file,
+// which is empty if not given. With --output, every argument is a source
+// file. Otherwise, a second argument is the output file.
+func sourcesAndOutput(cmd *cobra.Command, args []string) ([]string, string) {
+	if flagOutput, _ := cmd.Flags().GetString("output"); flagOutput != "" {
+		return args, flagOutput
+	}
+	if len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "Use --output to give more than one source file\n")
+		os.Exit(1)
+	}
+	if len(args) == 2 {
+		return args[:1], args[1]
+	}
+	return args, ""
+}
+
+// outputFile buffers the writes to an output of createOutput, so that
+// the first write error is reported when it is closed.
+type outputFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// createOutput creates the output file, or returns standard output if
+// filename is empty or "-". The output must be closed with finish.
+func createOutput(filename string) *outputFile {
+	if filename == "" || filename == stdioName {
+		return &outputFile{bufio.NewWriter(os.Stdout), os.Stdout}
+	}
+	output, err := os.Create(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", filename, err)
 		os.Exit(1)
 	}
-	defer f.Close()
+	return &outputFile{bufio.NewWriter(output), output}
+}
+
+// Close flushes the output, and closes it unless it is standard output.
+// It returns the first error of writing or closing the output.
+func (o *outputFile) Close() error {
+	err := o.Flush()
+	if o.file != os.Stdout {
+		if cerr := o.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// finish closes the output, or exits if writing it failed.
+func (o *outputFile) finish() {
+	if err := o.Close(); err != nil {
+		name := o.file.Name()
+		if o.file == os.Stdout {
+			name = "<standard output>"
+		}
+		fmt.Fprintf(os.Stderr, "Failed to write file \"%s\": %v\n", name, err)
+		os.Exit(1)
+	}
+}
 
-	finfo, err := f.Stat()
+// openInput opens the input of the BF program, selected by --input.
+func openInput(cmd *cobra.Command) *os.File {
+	flagInput, _ := cmd.Flags().GetString("input")
+	if flagInput == "" || flagInput == stdioName {
+		return os.Stdin
+	}
+	input, err := os.Open(flagInput)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to stat file \"%s\": %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", flagInput, err)
 		os.Exit(1)
 	}
+	return input
+}
 
-	fsize := finfo.Size()
-	prgm := NewBFProgram(uint64(fsize), defaultDataSize)
-	prgm.ReadCommands(f)
+func BFRun(cmd *cobra.Command, args []string) {
+	flagUnbuffered, _ := cmd.Flags().GetBool("unbuffered")
+	flagEOFError, _ := cmd.Flags().GetBool("eof-error")
+	input := openInput(cmd)
+	defer input.Close()
+
+	if flagJIT, _ := cmd.Flags().GetBool("jit"); flagJIT {
+		if flagEOFError {
+			fmt.Fprintf(os.Stderr, "--eof-error is only supported by the interpreter\n")
+			os.Exit(1)
+		}
+		if jit.Supported() {
+			bfRunJIT(cmd, args, input, flagUnbuffered)
+			return
+		}
+		dprintf("The JIT is not supported on this platform, using the interpreter")
+	}
+
+	src := readSources(args)
+	prgm := NewIOBFProgram(uint64(len(src)), defaultDataSize, input, os.Stdout)
+	readCommands(prgm, src)
+	prgm.SetBuffered(!flagUnbuffered)
+	prgm.SetEOFError(flagEOFError)
 	if err := prgm.Run(); err != nil {
 		fmt.Println(err)
 	}
@@ -173,173 +384,1173 @@ func BFRun(cmd *cobra.Command, args []string) {
 	}
 }
 
+// bfRunJIT runs the optimized IL of the BF source files with the JIT.
+func bfRunJIT(cmd *cobra.Command, filenames []string, input io.Reader, unbuffered bool) {
+	il, _ := prepareSources(cmd, filenames)
+	prgm, err := jit.Compile(il)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compile program: %v\n", err)
+		os.Exit(1)
+	}
+	defer prgm.Close()
+	if err := prgm.Run(input, os.Stdout, unbuffered); err != nil {
+		fmt.Println(err)
+	}
+	if *debugEnabled {
+		fmt.Fprintln(os.Stderr, "Program terminated")
+	}
+}
+
+func bfGen(cmd *cobra.Command, args []string, language string, gen func(*il.ILBlock, io.Writer, lang.GenOptions) error) {
+	sources, outputfilename := sourcesAndOutput(cmd, args)
+	il, _ := prepareSources(cmd, sources)
+	output := createOutput(outputfilename)
+
+	err := gen(il, output, genOptions(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate %s: %v\n", language, err)
+		os.Exit(1)
+	}
+	output.finish()
+}
+
 func BFGenGo(cmd *cobra.Command, args []string) {
-	flagProfile, _ := cmd.Flags().GetBool("profile")
-	filename := args[0]
-	f, err := os.Open(filename)
+	bfGen(cmd, args, "Go", lang.ILBlockToGo)
+}
+
+func BFGenC(cmd *cobra.Command, args []string) {
+	bfGen(cmd, args, "C", lang.ILBlockToC)
+}
+
+func BFGenLLVM(cmd *cobra.Command, args []string) {
+	bfGen(cmd, args, "LLVM IR", lang.ILBlockToLLVM)
+}
+
+func BFGenJS(cmd *cobra.Command, args []string) {
+	bfGen(cmd, args, "JavaScript", lang.ILBlockToJS)
+}
+
+func BFGenPython(cmd *cobra.Command, args []string) {
+	bfGen(cmd, args, "Python", lang.ILBlockToPython)
+}
+
+func BFGenWasm(cmd *cobra.Command, args []string) {
+	bfGen(cmd, args, "WebAssembly", lang.ILBlockToWAT)
+}
+
+// lookupBackend returns the backend selected by the --backend flag.
+func lookupBackend(cmd *cobra.Command) lang.BackendInfo {
+	flagBackend, _ := cmd.Flags().GetString("backend")
+	info, ok := lang.LookupBackend(flagBackend)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown backend \"%s\"\n", flagBackend)
+		os.Exit(1)
+	}
+	return info
+}
+
+func BFGen(cmd *cobra.Command, args []string) {
+	info := lookupBackend(cmd)
+	bfGen(cmd, args, "with the "+info.Name+" backend", func(b *il.ILBlock, output io.Writer, opts lang.GenOptions) error {
+		return lang.Generate(b, output, info.New(), opts)
+	})
+}
+
+// backendsHelp lists the registered backends for help messages.
+func backendsHelp() string {
+	var help strings.Builder
+	for _, info := range lang.Backends() {
+		fmt.Fprintf(&help, "\n  %-8s %s", info.Name, info.Description)
+	}
+	return help.String()
+}
+
+func BFDumpIL(cmd *cobra.Command, args []string) {
+	flagFormat, _ := cmd.Flags().GetString("format")
+	if flagFormat != "text" && flagFormat != "dot" {
+		fmt.Fprintf(os.Stderr, "Unknown dump format \"%s\"\n", flagFormat)
+		os.Exit(1)
+	}
+
+	sources, outputfilename := sourcesAndOutput(cmd, args)
+	il, changed := prepareSources(cmd, sources)
+	output := createOutput(outputfilename)
+
+	switch flagFormat {
+	case "text":
+		il.Dump(output, 0)
+	case "dot":
+		il.DumpDot(output, changed)
+	}
+	output.finish()
+}
+
+func BFMinify(cmd *cobra.Command, args []string) {
+	sources, outputfilename := sourcesAndOutput(cmd, args)
+	src := readSources(sources)
+	prgm := NewBFProgram(uint64(len(src)), defaultDataSize)
+	readCommands(prgm, src)
+	output := createOutput(outputfilename)
+
+	if err := prgm.Minify(output); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to minify: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(output)
+	output.finish()
+}
+
+// diffBF returns the unified diff between the original and formatted
+// source of filename, using the system diff tool.
+func diffBF(filename string, original, formatted []byte) ([]byte, error) {
+	f1, err := ioutil.TempFile("", "gobffmt")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", filename, err)
+		return nil, err
+	}
+	defer os.Remove(f1.Name())
+	defer f1.Close()
+	f2, err := ioutil.TempFile("", "gobffmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2.Name())
+	defer f2.Close()
+
+	if _, err := f1.Write(original); err != nil {
+		return nil, err
+	}
+	if _, err := f2.Write(formatted); err != nil {
+		return nil, err
+	}
+
+	diff := exec.Command("diff", "-u",
+		"--label", filename+".orig", "--label", filename,
+		f1.Name(), f2.Name())
+	out, err := diff.Output()
+	if len(out) > 0 {
+		// diff exits with status 1 when the files differ
+		err = nil
+	}
+	return out, err
+}
+
+func BFFmt(cmd *cobra.Command, args []string) {
+	flagWrite, _ := cmd.Flags().GetBool("write")
+	flagDiff, _ := cmd.Flags().GetBool("diff")
+	flagWidth, _ := cmd.Flags().GetInt("width")
+	opts := lang.FormatOptions{Width: flagWidth}
+
+	if len(args) == 0 {
+		args = []string{stdioName}
+	}
+
+	var failed bool
+	for _, filename := range args {
+		if filename == stdioName {
+			if err := lang.FormatBF(os.Stdin, os.Stdout, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to format: %v\n", err)
+				failed = true
+			}
+			continue
+		}
+		original, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read file \"%s\": %v\n", filename, err)
+			failed = true
+			continue
+		}
+
+		formatted := bytes.NewBuffer([]byte{})
+		if err := lang.FormatBF(bytes.NewReader(original), formatted, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to format \"%s\": %v\n", filename, err)
+			failed = true
+			continue
+		}
+
+		if !flagWrite && !flagDiff {
+			os.Stdout.Write(formatted.Bytes())
+			continue
+		}
+		if bytes.Equal(original, formatted.Bytes()) {
+			continue
+		}
+
+		if flagDiff {
+			diff, err := diffBF(filename, original, formatted.Bytes())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to diff \"%s\": %v\n", filename, err)
+				failed = true
+				continue
+			}
+			os.Stdout.Write(diff)
+		}
+		if flagWrite {
+			if err := ioutil.WriteFile(filename, formatted.Bytes(), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write file \"%s\": %v\n", filename, err)
+				failed = true
+				continue
+			}
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
-	defer f.Close()
+}
+
+func BFVet(cmd *cobra.Command, args []string) {
+	var found bool
+	for _, filename := range args {
+		src := readSources([]string{filename})
+		if filename == stdioName {
+			filename = "<standard input>"
+		}
 
-	finfo, err := f.Stat()
+		prgm := NewBFProgram(uint64(len(src)), defaultDataSize)
+		if err := prgm.ReadCommands(bytes.NewReader(src)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			found = true
+			continue
+		}
+
+		// The unoptimized tree keeps the exact position of every command
+		for _, d := range prgm.CreateILTree().Vet() {
+			fmt.Fprintf(os.Stderr, "%s:%v\n", filename, d)
+			found = true
+		}
+	}
+	if found {
+		os.Exit(1)
+	}
+}
+
+func BFREPL(cmd *cobra.Command, args []string) {
+	repl := NewREPL(os.Stdin, os.Stdout)
+	// Show :il with the optimizations selected by the flags
+	repl.Optimize = func(src string) (*il.ILBlock, error) {
+		il, _, err := prepareIL(cmd, strings.NewReader(src), int64(len(src)))
+		return il, err
+	}
+	if err := repl.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func BFCacheStats(cmd *cobra.Command, args []string) {
+	cache := defaultCache()
+	stats, err := cache.Stats()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to stat file \"%s\": %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Failed to read cache \"%s\": %v\n", cache.Dir, err)
 		os.Exit(1)
 	}
+	fmt.Printf("Dir:     %s\n", cache.Dir)
+	fmt.Printf("Entries: %d\n", stats.Entries)
+	fmt.Printf("Size:    %d bytes\n", stats.Size)
+}
 
-	il, err := prepareIL(cmd, f, finfo.Size())
+func BFCacheClean(cmd *cobra.Command, args []string) {
+	cache := defaultCache()
+	removed, err := cache.Clean()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read BF and/or optimize: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to clean cache \"%s\": %v\n", cache.Dir, err)
 		os.Exit(1)
 	}
+	fmt.Printf("Removed %d entries\n", removed)
+}
 
-	output := os.Stdout
-	if len(args) > 1 {
-		outputfilename := args[1]
-		output, err = os.Create(outputfilename)
+func BFCompile(cmd *cobra.Command, args []string) {
+	info := lookupBackend(cmd)
+	sources, outputfilename := sourcesAndOutput(cmd, args)
+	il, _ := prepareSources(cmd, sources)
+
+	// If no output file specified, use the first source's base name
+	// without extension as the program binary.
+	if outputfilename == "" {
+		filename := sources[0]
+		if filename == stdioName {
+			fmt.Fprintf(os.Stderr, "Error - Need an output file for a program from standard input\n")
+			os.Exit(1)
+		}
+		outputfilename = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+		// Sanity check
+		if filepath.Clean(outputfilename) == filepath.Clean(filename) {
+			fmt.Fprintf(os.Stderr, "Error - Asked to overwrite original input file\n")
+			os.Exit(1)
+		}
+	}
+
+	// Build a binary for standard output in a temp file
+	binfilename := outputfilename
+	if outputfilename == stdioName {
+		binfile, err := ioutil.TempFile("", "gobfbin")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", outputfilename, err)
+			fmt.Fprintf(os.Stderr, "Failed to create temp file: %v\n", err)
 			os.Exit(1)
 		}
+		binfile.Close()
+		defer os.Remove(binfile.Name())
+		binfilename = binfile.Name()
 	}
 
-	err = lang.ILBlockToGo(il, output, flagProfile)
+	dprintf("Compiling IL")
+	copts := compileOptions(cmd)
+	err, tempdir := lang.CompileBackend(info.Name, il, binfilename, copts, genOptions(cmd))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to generate Go: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error - %v\n", err)
+		os.Exit(2)
+	}
+	if copts.Debug || copts.KeepSource {
+		fmt.Fprintln(os.Stderr, "TempDir:", tempdir)
+	}
+
+	if outputfilename == stdioName {
+		bin, err := ioutil.ReadFile(binfilename)
+		if err == nil {
+			_, err = os.Stdout.Write(bin)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write binary: %v\n", err)
+			os.Exit(1)
+		}
 	}
 }
 
-func BFDumpIL(cmd *cobra.Command, args []string) {
-	filename := args[0]
-	f, err := os.Open(filename)
+// lookupLevels returns the optimization levels with the given names, or
+// exits.
+func lookupLevels(names []string) []OptimizeLevel {
+	var levels []OptimizeLevel
+	for _, name := range names {
+		level, ok := LookupOptimizeLevel(name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown optimization level \"%s\"\n", name)
+			os.Exit(1)
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// testPath is one way of running the programs of the conformance tests.
+type testPath struct {
+	name string
+	// run runs the program, and returns its output and error
+	run func(t *ConformanceTest) ([]byte, error)
+}
+
+// testPaths returns the execution paths selected by the flags of the test
+// command. Backends that can't run on this host are skipped.
+func testPaths(cmd *cobra.Command, tempdir string) []testPath {
+	flagLevels, _ := cmd.Flags().GetStringSlice("level")
+	flagBackends, _ := cmd.Flags().GetStringSlice("backend")
+
+	levels := lookupLevels(flagLevels)
+
+	paths := []testPath{{"interp", func(t *ConformanceTest) ([]byte, error) {
+		return t.Run()
+	}}}
+	ilCosts := backendCostModel(cmd, "il")
+	for _, level := range levels {
+		level := level.WithCosts(ilCosts)
+		paths = append(paths, testPath{"il/" + level.Name, func(t *ConformanceTest) ([]byte, error) {
+			b, err := t.IL(level)
+			if err != nil {
+				return nil, err
+			}
+			var out bytes.Buffer
+			err = b.Run(bytes.NewReader(t.Input), &out)
+			return out.Bytes(), err
+		}})
+	}
+	if jit.Supported() {
+		jitCosts := backendCostModel(cmd, "jit")
+		for _, level := range levels {
+			level := level.WithCosts(jitCosts)
+			paths = append(paths, testPath{"jit/" + level.Name, func(t *ConformanceTest) ([]byte, error) {
+				b, err := t.IL(level)
+				if err != nil {
+					return nil, err
+				}
+				prgm, err := jit.Compile(b)
+				if err != nil {
+					return nil, err
+				}
+				defer prgm.Close()
+				var out bytes.Buffer
+				err = prgm.Run(bytes.NewReader(t.Input), &out, false)
+				return out.Bytes(), err
+			}})
+		}
+	}
+	if len(levels) == 0 {
+		return paths
+	}
+
+	// Backends are slow to build, so they only run the last level
+	level := levels[len(levels)-1]
+	infos := lang.Backends()
+	if len(flagBackends) > 0 {
+		infos = nil
+		for _, name := range flagBackends {
+			info, ok := lang.LookupBackend(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unknown backend \"%s\", must be one of:\n%s", name, backendsHelp())
+				os.Exit(1)
+			}
+			infos = append(infos, info)
+		}
+	}
+	copts := compileOptions(cmd)
+	for _, info := range infos {
+		info := info
+		if err := info.Runnable(); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping the %s backend: %v\n", info.Name, err)
+			continue
+		}
+		level := level.WithCosts(backendCostModel(cmd, info.Name))
+		paths = append(paths, testPath{info.Name, func(t *ConformanceTest) ([]byte, error) {
+			b, err := t.IL(level)
+			if err != nil {
+				return nil, err
+			}
+			return runBackend(info, b, t.Input, tempdir, copts)
+		}})
+	}
+	return paths
+}
+
+// buildBackend builds b with the backend info in tempdir, or generates
+// the source for its interpreter, and returns the file it wrote and the
+// command that runs it.
+func buildBackend(info lang.BackendInfo, b *il.ILBlock, tempdir string, copts lang.CompileOptions) (string, *exec.Cmd, error) {
+	if info.Interpreter != "" {
+		var src bytes.Buffer
+		if err := lang.Generate(b, &src, info.New(), lang.GenOptions{}); err != nil {
+			return "", nil, err
+		}
+		srcfile := filepath.Join(tempdir, info.SourceName)
+		if err := ioutil.WriteFile(srcfile, src.Bytes(), 0644); err != nil {
+			return "", nil, err
+		}
+		return srcfile, exec.Command(info.Interpreter, srcfile), nil
+	}
+	binfile := filepath.Join(tempdir, "prog")
+	err, srcdir := lang.CompileBackend(info.Name, b, binfile, copts, lang.GenOptions{})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", filename, err)
-		os.Exit(1)
+		return "", nil, fmt.Errorf("%v (source in %s)", err, srcdir)
 	}
-	defer f.Close()
+	if copts.Debug || copts.KeepSource {
+		fmt.Fprintln(os.Stderr, "TempDir:", srcdir)
+	}
+	return binfile, exec.Command(binfile), nil
+}
+
+// runBackend builds b with the backend info in tempdir, and runs it with
+// input.
+func runBackend(info lang.BackendInfo, b *il.ILBlock, input []byte, tempdir string, copts lang.CompileOptions) ([]byte, error) {
+	_, run, err := buildBackend(info, b, tempdir, copts)
+	if err != nil {
+		return nil, err
+	}
+	run.Stdin = bytes.NewReader(input)
+	return run.Output()
+}
 
-	finfo, err := f.Stat()
+func BFTest(cmd *cobra.Command, args []string) {
+	flagVerbose, _ := cmd.Flags().GetBool("verbose")
+	tests, err := LoadConformanceTests(args...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to stat file \"%s\": %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Failed to load tests: %v\n", err)
+		os.Exit(1)
+	}
+	if len(tests) == 0 {
+		fmt.Fprintf(os.Stderr, "No tests found, a test is a bf file with a .out file of its expected output\n")
 		os.Exit(1)
 	}
 
-	il, err := prepareIL(cmd, f, finfo.Size())
+	tempdir, err := ioutil.TempDir("", "gobftest")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read BF and/or optimize: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
 		os.Exit(1)
 	}
+	defer os.RemoveAll(tempdir)
 
-	output := os.Stdout
-	if len(args) > 1 {
-		outputfilename := args[1]
-		output, err = os.Create(outputfilename)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", outputfilename, err)
+	paths := testPaths(cmd, tempdir)
+	var failed int
+	for i := range tests {
+		t := &tests[i]
+		for _, path := range paths {
+			dprintf("Running %s with %s", t.Name, path.name)
+			output, err := path.run(t)
+			if err := t.Check(output, err); err != nil {
+				fmt.Printf("FAIL %s (%s): %v\n", t.Name, path.name, err)
+				failed++
+			} else if flagVerbose {
+				fmt.Printf("ok   %s (%s)\n", t.Name, path.name)
+			}
+		}
+	}
+
+	runs := len(tests) * len(paths)
+	if failed > 0 {
+		fmt.Printf("FAIL %d of %d runs of %d tests with %d paths\n", failed, runs, len(tests), len(paths))
+		os.RemoveAll(tempdir)
+		os.Exit(1)
+	}
+	fmt.Printf("ok   %d tests with %d paths\n", len(tests), len(paths))
+}
+
+// reducePredicate returns the predicate of the reduce command: the user
+// script, or the IL optimized with level diverging from the interpreter.
+func reducePredicate(cmd *cobra.Command, level OptimizeLevel, input []byte, maxSteps int64, tempdir string) func(src []byte) bool {
+	flagScript, _ := cmd.Flags().GetString("script")
+	if flagScript == "" {
+		return func(src []byte) bool {
+			return Diverges(src, input, level, maxSteps) != nil
+		}
+	}
+	candidate := filepath.Join(tempdir, "candidate.b")
+	return func(src []byte) bool {
+		if err := ioutil.WriteFile(candidate, src, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write candidate: %v\n", err)
 			os.Exit(1)
 		}
+		return exec.Command(flagScript, candidate).Run() == nil
+	}
+}
+
+func BFReduce(cmd *cobra.Command, args []string) {
+	flagLevel, _ := cmd.Flags().GetString("level")
+	flagInput, _ := cmd.Flags().GetString("input")
+	flagMaxSteps, _ := cmd.Flags().GetInt64("max-steps")
+	flagOutput, _ := cmd.Flags().GetString("output")
+
+	level, ok := LookupOptimizeLevel(flagLevel)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown optimization level \"%s\"\n", flagLevel)
+		os.Exit(1)
+	}
+	var input []byte
+	if flagInput != "" {
+		input = readSources([]string{flagInput})
+	}
+
+	// Drop the comments, which could hide commands once their line is cut
+	src := readSources(args)
+	prgm := NewBFProgram(uint64(len(src)), 0)
+	readCommands(prgm, src)
+	var cmds bytes.Buffer
+	prgm.PrintProgram(&cmds)
+
+	tempdir, err := ioutil.TempDir("", "gobfreduce")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tempdir)
+
+	interesting := reducePredicate(cmd, level, input, flagMaxSteps, tempdir)
+	if !interesting(cmds.Bytes()) {
+		fmt.Fprintf(os.Stderr, "The program is not interesting to begin with\n")
+		os.RemoveAll(tempdir)
+		os.Exit(1)
+	}
+	reduced, stats := Reduce(cmds.Bytes(), interesting)
+	fmt.Fprintf(os.Stderr, "Reduced %d commands to %d with %d tests\n", cmds.Len(), len(reduced), stats.Tests)
+
+	if n, ok := DivergentPass(reduced, input, level, flagMaxSteps); ok {
+		if n == 0 {
+			fmt.Fprintf(os.Stderr, "The unoptimized IL diverges from the interpreter\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "The IL diverges from the interpreter after pass %d of %d of level %s: %s\n",
+				n, len(level.Passes), level.Name, level.Passes[n-1].Name)
+		}
 	}
 
-	il.Dump(output, 0)
+	output := createOutput(flagOutput)
+	fmt.Fprintln(output, string(reduced))
+	output.finish()
 }
 
-func BFCompile(cmd *cobra.Command, args []string) {
-	flagProfile, _ := cmd.Flags().GetBool("profile")
+// benchBackend is a way of building and running programs for the bench
+// command.
+type benchBackend struct {
+	name string
+	// build builds b in tempdir, and returns the size of what it built and
+	// a function that runs it once with input, discarding the output
+	build func(b *il.ILBlock, tempdir string) (int64, func(input []byte) error, error)
+}
 
-	filename := args[0]
-	f, err := os.Open(filename)
+// benchBackends returns the backends selected by the flags of the bench
+// command. Backends that can't run on this host are skipped.
+func benchBackends(cmd *cobra.Command) []benchBackend {
+	flagBackends, _ := cmd.Flags().GetStringSlice("backend")
+
+	// Build every time, so that the compile times are real
+	copts := compileOptions(cmd)
+	copts.Cache = nil
+
+	var backends []benchBackend
+	for _, name := range flagBackends {
+		switch name {
+		case "il":
+			backends = append(backends, benchBackend{name, func(b *il.ILBlock, tempdir string) (int64, func([]byte) error, error) {
+				return 0, func(input []byte) error {
+					return b.Run(bytes.NewReader(input), ioutil.Discard)
+				}, nil
+			}})
+		case "jit":
+			if !jit.Supported() {
+				fmt.Fprintf(os.Stderr, "Skipping the jit: %v\n", jit.ErrUnsupported)
+				continue
+			}
+			backends = append(backends, benchBackend{name, func(b *il.ILBlock, tempdir string) (int64, func([]byte) error, error) {
+				prgm, err := jit.Compile(b)
+				if err != nil {
+					return 0, nil, err
+				}
+				return int64(prgm.Size()), func(input []byte) error {
+					defer prgm.Close()
+					return prgm.Run(bytes.NewReader(input), ioutil.Discard, false)
+				}, nil
+			}})
+		default:
+			info, ok := lang.LookupBackend(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unknown backend \"%s\", must be il, jit, or one of:\n%s", name, backendsHelp())
+				os.Exit(1)
+			}
+			if err := info.Runnable(); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping the %s backend: %v\n", info.Name, err)
+				continue
+			}
+			backends = append(backends, benchBackend{name, func(b *il.ILBlock, tempdir string) (int64, func([]byte) error, error) {
+				file, run, err := buildBackend(info, b, tempdir, copts)
+				if err != nil {
+					return 0, nil, err
+				}
+				stat, err := os.Stat(file)
+				if err != nil {
+					return 0, nil, err
+				}
+				return stat.Size(), func(input []byte) error {
+					run.Stdin = bytes.NewReader(input)
+					return run.Run()
+				}, nil
+			}})
+		}
+	}
+	return backends
+}
+
+// benchTime formats the mean and standard deviation of times in seconds.
+func benchTime(times []float64) string {
+	mean, stddev := MeanStdDev(times)
+	round := func(seconds float64) time.Duration {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Microsecond)
+	}
+	return fmt.Sprintf("%v ±%v", round(mean), round(stddev))
+}
+
+// writeBenchReport writes report to the file filename with write, unless
+// filename is empty.
+func writeBenchReport(filename string, write func(io.Writer) error) {
+	if filename == "" {
+		return
+	}
+	output := createOutput(filename)
+	err := write(output)
+	// Standard output stays open for the table
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open file \"%s\": %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Failed to write report \"%s\": %v\n", filename, err)
 		os.Exit(1)
 	}
-	defer f.Close()
+}
 
-	finfo, err := f.Stat()
+func BFBench(cmd *cobra.Command, args []string) {
+	flagLevels, _ := cmd.Flags().GetStringSlice("level")
+	flagReps, _ := cmd.Flags().GetInt("reps")
+	flagJSON, _ := cmd.Flags().GetString("json")
+	flagCSV, _ := cmd.Flags().GetString("csv")
+	flagBaseline, _ := cmd.Flags().GetString("baseline")
+	flagAlpha, _ := cmd.Flags().GetFloat64("alpha")
+
+	if flagReps < 1 {
+		fmt.Fprintf(os.Stderr, "Need at least one repetition\n")
+		os.Exit(1)
+	}
+	levels := lookupLevels(flagLevels)
+	prgms, err := LoadBenchPrograms(args...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to stat file \"%s\": %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Failed to load programs: %v\n", err)
 		os.Exit(1)
 	}
+	if len(prgms) == 0 {
+		fmt.Fprintf(os.Stderr, "No bf programs found\n")
+		os.Exit(1)
+	}
+	var base *BenchReport
+	if flagBaseline != "" {
+		f, err := os.Open(flagBaseline)
+		if err == nil {
+			base, err = ReadBenchReport(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read baseline \"%s\": %v\n", flagBaseline, err)
+			os.Exit(1)
+		}
+	}
 
-	il, err := prepareIL(cmd, f, finfo.Size())
+	tempdir, err := ioutil.TempDir("", "gobfbench")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read BF and/or optimize: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
 		os.Exit(1)
 	}
+	defer os.RemoveAll(tempdir)
 
-	var outputfilename string
-	// If no output file specified, use basename (without extension)
-	// as the program binary.
-	if len(args) > 1 {
-		outputfilename = args[1]
-	} else {
-		basename := path.Base(filename)
-		basenameparts := strings.Split(basename, ".")
-		basename = basenameparts[0]
+	// Keep standard output for a report written there
+	table := os.Stdout
+	if flagJSON == stdioName || flagCSV == stdioName {
+		table = os.Stderr
+	}
 
-		// Sanity check
-		if basename == filename {
-			fmt.Fprintf(os.Stderr, "Error - Asked to overwrite original input file\n")
-			os.Exit(1)
+	report := &BenchReport{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Reps:      flagReps,
+	}
+	backends := benchBackends(cmd)
+	costs := make(map[string]*il.CostModel)
+	for _, backend := range backends {
+		costs[backend.name] = backendCostModel(cmd, backend.name)
+	}
+	fmt.Fprintf(table, "%-32s %-10s %-8s %8s %10s %22s %22s\n", "PROGRAM", "LEVEL", "BACKEND", "BLOCKS", "SIZE", "COMPILE", "RUN")
+	for _, p := range prgms {
+		for _, level := range levels {
+			for _, backend := range backends {
+				r := BenchResult{Program: p.Name, Level: level.Name, Backend: backend.name}
+				for rep := 0; rep < flagReps; rep++ {
+					dprintf("Running %s with %s/%s, repetition %d", p.Name, level.Name, backend.name, rep+1)
+					start := time.Now()
+					b, err := p.IL(level.WithCosts(costs[backend.name]))
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", p.Name, err)
+						os.RemoveAll(tempdir)
+						os.Exit(1)
+					}
+					size, run, err := backend.build(b, tempdir)
+					compileTime := time.Since(start)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to build %s with %s/%s: %v\n", p.Name, level.Name, backend.name, err)
+						os.RemoveAll(tempdir)
+						os.Exit(1)
+					}
+					start = time.Now()
+					err = run(p.Input)
+					runTime := time.Since(start)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to run %s with %s/%s: %v\n", p.Name, level.Name, backend.name, err)
+						os.RemoveAll(tempdir)
+						os.Exit(1)
+					}
+					r.Blocks, r.Size = b.BlockCount(), size
+					r.CompileTimes = append(r.CompileTimes, compileTime.Seconds())
+					r.RunTimes = append(r.RunTimes, runTime.Seconds())
+				}
+				fmt.Fprintf(table, "%-32s %-10s %-8s %8d %10d %22s %22s\n", r.Program, r.Level, r.Backend,
+					r.Blocks, r.Size, benchTime(r.CompileTimes), benchTime(r.RunTimes))
+				report.Results = append(report.Results, r)
+			}
 		}
+	}
+
+	writeBenchReport(flagJSON, report.WriteJSON)
+	writeBenchReport(flagCSV, report.WriteCSV)
 
-		outputfilename = basename
+	if base == nil {
+		return
+	}
+	fmt.Fprintf(table, "\nCompared with %s at significance level %v:\n", flagBaseline, flagAlpha)
+	comps := CompareBench(base, report, flagAlpha)
+	if len(comps) == 0 {
+		fmt.Fprintf(table, "No results in common\n")
 	}
+	for _, c := range comps {
+		verdict := ""
+		if c.Significant {
+			verdict = "  faster"
+			if c.Current > c.Base {
+				verdict = "  slower"
+			}
+		}
+		change := "n/a"
+		if rel, ok := c.Change(); ok {
+			change = fmt.Sprintf("%+.1f%%", 100*rel)
+		}
+		fmt.Fprintf(table, "%-54s %-8s %12v -> %-12v %8s  p=%.3f%s\n", c.Key, c.Metric,
+			time.Duration(c.Base*float64(time.Second)).Round(time.Microsecond),
+			time.Duration(c.Current*float64(time.Second)).Round(time.Microsecond),
+			change, c.P, verdict)
+	}
+}
 
-	dprintf("Compiling IL")
-	err, tempdir := lang.CompileIL(il, outputfilename, *debugEnabled, flagProfile)
+func BFCalibrate(cmd *cobra.Command, args []string) {
+	flagOutput, _ := cmd.Flags().GetString("output")
+	flagMinTime, _ := cmd.Flags().GetDuration("min-time")
+
+	filename := flagOutput
+	if filename == "" {
+		var err error
+		if filename, err = il.DefaultCostProfile(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to find the cost profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Keep the models of the other backends
+	profile := new(il.CostProfile)
+	if f, err := os.Open(filename); err == nil {
+		profile, err = il.ReadCostProfile(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read cost profile \"%s\": %v\n", filename, err)
+			os.Exit(1)
+		}
+	}
+
+	tempdir, err := ioutil.TempDir("", "gobfcalibrate")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error - %v", err)
-		os.Exit(2)
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
+		os.Exit(1)
 	}
-	if *debugEnabled {
-		fmt.Println("TempDir:", tempdir)
+	defer os.RemoveAll(tempdir)
+
+	for _, backend := range benchBackends(cmd) {
+		backend := backend
+		fmt.Printf("Calibrating %s\n", backend.name)
+		costs, err := il.Calibrate(backend.name, func(b *il.ILBlock) (time.Duration, error) {
+			_, run, err := backend.build(b, tempdir)
+			if err != nil {
+				return 0, err
+			}
+			start := time.Now()
+			err = run(nil)
+			return time.Since(start), err
+		}, flagMinTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to calibrate %s: %v\n", backend.name, err)
+			os.RemoveAll(tempdir)
+			os.Exit(1)
+		}
+		fmt.Printf("  loop %.3gns, dataptradd %.3gns, dataadd %.3gns, dataset %.3gns\n",
+			costs.Loop, costs.DataPtrAdd, costs.DataAdd, costs.DataSet)
+		fmt.Printf("  dataaddvector %.3gns + %.3gns per element, dataaddlinvector %.3gns + %.3gns per element\n",
+			costs.DataAddVector, costs.DataAddVectorElem, costs.DataAddLinVector, costs.DataAddLinVectorElem)
+		profile.Set(*costs)
 	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create directory for \"%s\": %v\n", filename, err)
+		os.Exit(1)
+	}
+	output := createOutput(filename)
+	err = profile.WriteJSON(output)
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write cost profile \"%s\": %v\n", filename, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", filename)
 }
 
 func main() {
 	var cmdRun = &cobra.Command{
-		Use:   "run <bf file>",
+		Use:   "run <bf file>...",
 		Short: "Run the given bf file",
-		Long:  `This will evoke the interpreter for a specified bf text file`,
-		Args:  cobra.MinimumNArgs(1),
-		Run:   BFRun,
+		Long: `This will evoke the interpreter for a specified bf text file.
+The bf files are concatenated into one program, and "-" is standard input.`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFRun,
 	}
+	cmdRun.Flags().String("input", "", "File to use as the program's input instead of standard input")
+	cmdRun.Flags().Bool("jit", false, "Run the optimized program as machine code (linux/amd64 only, otherwise the interpreter is used)")
+	cmdRun.Flags().Bool("eof-error", false, "Fail when the program reads at the end of input, instead of leaving the cell unchanged")
+	var cmdGen = &cobra.Command{
+		Use:   "gen <bf file> [output file]\n  gobf gen -o <output file> <bf file>...",
+		Short: "Generate the given bf file in another language",
+		Long: `This will parse a given bf text file and generate an equivalent program with the backend selected by --backend.
+` + outputHelp + `
+The backends are:` + backendsHelp(),
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFGen,
+	}
+	cmdGen.Flags().StringP("output", "o", "", "Output file, \"-\" for standard output")
+	cmdGen.Flags().String("backend", "go", "Backend to generate with")
+	cmdGen.Flags().String("package", "", "Generate a function in the given package instead of a main package (go)")
+	cmdGen.Flags().String("func", "", "Name of the generated function (go, default \""+lang.DefaultFuncName+"\")")
+	cmdGen.Flags().Bool("wasi", false, "Use WASI fd_read and fd_write for I/O (wasm)")
+	cmdGen.Flags().String("template", "", "Use the text/template in the given file instead of the backend's builtin program template")
 	var cmdGenGo = &cobra.Command{
-		Use:   "gengo <bf file> [output go file]",
+		Use:   "gengo <bf file> [output go file]\n  gobf gengo -o <output go file> <bf file>...",
 		Short: "Generate a Go representation of the given bf file",
-		Long:  `This will parse a given bf text file and generate equivalent Go code`,
-		Args:  cobra.MinimumNArgs(1),
-		Run:   BFGenGo,
+		Long: `This will parse a given bf text file and generate equivalent Go code.
+With --package or --func, it generates a reusable function func Run(in io.Reader, out io.Writer) error, instead of a main package.`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenGo,
+		Annotations: map[string]string{"backend": "go"},
+		Deprecated:  "use gen --backend go",
+	}
+	cmdGenGo.Flags().String("package", "", "Generate a function in the given package instead of a main package")
+	cmdGenGo.Flags().String("func", "", "Name of the generated function (default \""+lang.DefaultFuncName+"\")")
+	cmdGenGo.Flags().String("template", "", "Use the text/template in the given file instead of the builtin program template")
+	var cmdGenC = &cobra.Command{
+		Use:         "genc <bf file> [output c file]\n  gobf genc -o <output c file> <bf file>...",
+		Short:       "Generate a C representation of the given bf file",
+		Long:        `This will parse a given bf text file and generate equivalent C99 code`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenC,
+		Annotations: map[string]string{"backend": "c"},
+		Deprecated:  "use gen --backend c",
+	}
+	var cmdGenWasm = &cobra.Command{
+		Use:   "genwasm <bf file> [output wat file]\n  gobf genwasm -o <output wat file> <bf file>...",
+		Short: "Generate a WebAssembly text representation of the given bf file",
+		Long: `This will parse a given bf text file and generate an equivalent WebAssembly text module.
+The module imports env.read and env.write and exports run, or with --wasi, it uses WASI and exports _start.
+The tape is the exported linear memory.`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenWasm,
+		Annotations: map[string]string{"backend": "wasm"},
+		Deprecated:  "use gen --backend wasm",
+	}
+	var cmdGenLLVM = &cobra.Command{
+		Use:         "genllvm <bf file> [output ll file]\n  gobf genllvm -o <output ll file> <bf file>...",
+		Short:       "Generate an LLVM IR representation of the given bf file",
+		Long:        `This will parse a given bf text file and generate an equivalent LLVM IR module, which uses libc for I/O`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenLLVM,
+		Annotations: map[string]string{"backend": "llvm"},
+		Deprecated:  "use gen --backend llvm",
+	}
+	var cmdGenJS = &cobra.Command{
+		Use:   "genjs <bf file> [output js file]\n  gobf genjs -o <output js file> <bf file>...",
+		Short: "Generate a JavaScript representation of the given bf file",
+		Long: `This will parse a given bf text file and generate an equivalent JavaScript program.
+The program runs with standard input and output in Node, and exports run(read, write) for browsers.`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenJS,
+		Annotations: map[string]string{"backend": "js"},
+		Deprecated:  "use gen --backend js",
+	}
+	var cmdGenPython = &cobra.Command{
+		Use:   "genpy <bf file> [output py file]\n  gobf genpy -o <output py file> <bf file>...",
+		Short: "Generate a Python representation of the given bf file",
+		Long: `This will parse a given bf text file and generate an equivalent Python 3 program.
+The program runs with standard input and output as a script, and has run(read, write) for use as a module.`,
+		Args:        cobra.MinimumNArgs(1),
+		Run:         BFGenPython,
+		Annotations: map[string]string{"backend": "python"},
+		Deprecated:  "use gen --backend python",
+	}
+	cmdGenWasm.Flags().Bool("wasi", false, "Use WASI fd_read and fd_write for I/O")
+	for _, c := range []*cobra.Command{cmdGenGo, cmdGenC, cmdGenWasm, cmdGenLLVM, cmdGenJS, cmdGenPython} {
+		c.Flags().StringP("output", "o", "", "Output file, \"-\" for standard output")
 	}
 	var cmdDumpIL = &cobra.Command{
-		Use:   "dumpil <bf file> [output go file]",
+		Use:   "dumpil <bf file> [output file]\n  gobf dumpil -o <output file> <bf file>...",
 		Short: "Dumps a text representation of the Intermediate Language Tree",
-		Long:  `This will parse the bf file, generate the intermediate tree, run the specified optimizations, and print the tree.`,
-		Args:  cobra.MinimumNArgs(1),
-		Run:   BFDumpIL,
+		Long: `This will parse the bf file, generate the intermediate tree, run the specified optimizations, and print the tree.
+` + outputHelp,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFDumpIL,
 	}
+	cmdDumpIL.Flags().StringP("output", "o", "", "Output file, \"-\" for standard output")
+	cmdDumpIL.Flags().StringP("format", "f", "text", "Output format of the dump, either text or dot (Graphviz)")
+	cmdDumpIL.Flags().String("highlight", "", fmt.Sprintf("Highlight the blocks changed by an optimization pass in the dot output, one of %v", optimizationPasses))
 	var cmdCompile = &cobra.Command{
-		Use:   "compile <bf file> [output go file]",
+		Use:   "compile <bf file> [output binary]\n  gobf compile -o <output binary> <bf file>...",
 		Short: "Compile the given bf file to a binary",
-		Long:  `This will parse a given bf text file and generate equivalent binary program`,
+		Long: `This will parse a given bf text file and generate equivalent binary program.
+Without an output binary, it is named after the first bf file without its extension.
+` + outputHelp,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFCompile,
+	}
+	cmdCompile.Flags().StringP("output", "o", "", "Output file, \"-\" for standard output")
+	cmdCompile.Flags().String("backend", "go", "Backend to compile through, see gen --help")
+	cmdCompile.Flags().String("goos", "", "Target operating system, like GOOS (go backend)")
+	cmdCompile.Flags().String("goarch", "", "Target architecture, like GOARCH (go backend)")
+	cmdCompile.Flags().Bool("gccgo", false, "Build with gccgo instead of the gc compiler (go backend)")
+	cmdCompile.Flags().Bool("trimpath", false, "Remove the paths of the build from the binary")
+	cmdCompile.Flags().Bool("strip", false, "Leave the symbol table and debug information out of the binary")
+	cmdCompile.Flags().Bool("static", false, "Link the binary statically")
+	cmdCompile.Flags().Bool("keep-source", false, "Keep the temp directory with the generated source, and print its path")
+	cmdCompile.Flags().Bool("no-cache", false, "Build the binary even if it is in the compile cache")
+	cmdCompile.Flags().String("template", "", "Use the text/template in the given file instead of the backend's builtin program template")
+
+	var cmdMinify = &cobra.Command{
+		Use:   "minify <bf file> [output bf file]\n  gobf minify -o <output bf file> <bf file>...",
+		Short: "Print the shortest equivalent bf program",
+		Long: `This will parse the bf file, drop comments and canceling commands, and print the shortest equivalent program found by the semantics preserving optimizations.
+` + outputHelp,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFMinify,
+	}
+	cmdMinify.Flags().StringP("output", "o", "", "Output file, \"-\" for standard output")
+
+	var cmdFmt = &cobra.Command{
+		Use:   "fmt [bf file...]",
+		Short: "Format the given bf files",
+		Long: `This will indent the bf files by loop depth and wrap long runs of commands, preserving comments. Without files, or for "-", it formats standard input.
+
+The diff mode is only --diff, since -d is the shorthand of the global --debug flag.`,
+		Run: BFFmt,
+	}
+	cmdFmt.Flags().BoolP("write", "w", false, "Write the result to the source file instead of standard output")
+	// -d is taken by the persistent --debug flag, which cobra won't redefine
+	cmdFmt.Flags().Bool("diff", false, "Print a diff of the changes instead of the formatted source (no -d, which is --debug)")
+	cmdFmt.Flags().Int("width", lang.DefaultFormatWidth, "Line width to wrap runs of commands at")
+
+	var cmdVet = &cobra.Command{
+		Use:   "vet <bf file>...",
+		Short: "Report likely mistakes in the given bf files",
+		Long:  `This will statically analyze the bf files and report loops that are never entered or never terminate, unreachable code, data pointer moves below zero, and loops that move the data pointer. "-" is standard input.`,
 		Args:  cobra.MinimumNArgs(1),
-		Run:   BFCompile,
+		Run:   BFVet,
+	}
+
+	var cmdREPL = &cobra.Command{
+		Use:   "repl",
+		Short: "Run bf commands interactively",
+		Long: `This will run each line of bf commands as it is entered, on one tape that is kept between lines.
+A line with an unclosed [ continues on the next line. Enter :help for the meta-commands.`,
+		Args: cobra.NoArgs,
+		Run:  BFREPL,
+	}
+
+	var cmdCache = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the compile cache",
+		Long: `The compile command keeps the binaries it builds in a cache, and copies them from the cache when the same program is compiled with the same backend, template, and options again.
+The cache is in gobf under the user's cache directory, or in the directory named by the GOBFCACHE environment variable.`,
+	}
+	cmdCache.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Print the number of binaries in the compile cache and their size",
+		Args:  cobra.NoArgs,
+		Run:   BFCacheStats,
+	})
+	cmdCache.AddCommand(&cobra.Command{
+		Use:   "clean",
+		Short: "Remove every binary from the compile cache",
+		Args:  cobra.NoArgs,
+		Run:   BFCacheClean,
+	})
+
+	var levelNames []string
+	for _, level := range OptimizeLevels {
+		levelNames = append(levelNames, level.Name)
+	}
+	var cmdTest = &cobra.Command{
+		Use:   "test <dir or bf file>...",
+		Short: "Check that bf programs give their expected output with every execution path",
+		Long: `This will run the conformance tests in the given directories and bf files through the interpreter, the IL of each optimization level, the JIT, and each backend, and report the runs whose output differs from the expected output.
+A test is a bf file prog.b or prog.bf next to prog.out, which holds its expected output. The optional prog.in holds its input. If prog.err exists, the program must fail after writing the expected output.
+Backends run the program with the last optimization level, and are skipped when their tools are missing.
+The bundled tests are in testprograms/conformance.`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFTest,
+	}
+	cmdTest.Flags().StringSlice("level", levelNames, "Optimization levels to run the IL and JIT with")
+	cmdTest.Flags().StringSlice("backend", nil, "Backends to run, all of them by default, see gen --help")
+	cmdTest.Flags().Bool("no-cache", false, "Build the backend binaries even if they are in the compile cache")
+	cmdTest.Flags().BoolP("verbose", "v", false, "Print the runs that pass too")
+
+	var cmdReduce = &cobra.Command{
+		Use:   "reduce <bf file>...",
+		Short: "Shrink a bf program while the optimized IL diverges from the interpreter",
+		Long: `This will remove commands and loops from the bf program, in the style of delta debugging, as long as it stays interesting, and write the smallest program found.
+By default, a program is interesting if the IL optimized with --level gives a different output or final tape than the interpreter. Then the pass of the level that causes the divergence is also reported.
+With --script, a program is interesting if the script exits with status 0 when given the file of the program as its argument.
+The bf files are concatenated into one program, and "-" is standard input.`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFReduce,
+	}
+	cmdReduce.Flags().String("level", "all", fmt.Sprintf("Optimization level to compare with the interpreter, one of %v", levelNames))
+	cmdReduce.Flags().String("input", "", "File to use as the program's input")
+	cmdReduce.Flags().Int64("max-steps", DefaultMaxSteps, "Steps after which the interpreter gives up on a program")
+	cmdReduce.Flags().String("script", "", "Command that decides if a program is interesting, instead of the optimized IL diverging")
+	cmdReduce.Flags().StringP("output", "o", "", "Output file, standard output by default")
+
+	defaultBenchBackend := "il"
+	if jit.Supported() {
+		defaultBenchBackend = "jit"
+	}
+	var cmdBench = &cobra.Command{
+		Use:   "bench <dir or bf file>...",
+		Short: "Measure the compile and run times of bf programs with each optimization level and backend",
+		Long: `This will build and run the given bf programs, and the ones in the given directories, with each optimization level and backend for a number of repetitions, and report the IL block count, the binary size, and the mean and standard deviation of the compile and run times.
+The input of prog.b is read from prog.in, if it exists. The backend il runs the IL with its interpreter, and jit runs it with the JIT. The compile time covers reading the program to having something to run, and the compile cache is not used.
+The report can be written as JSON, to be used as a baseline later, and as CSV with one record per repetition. With --baseline, each compile and run time is compared with the one of the same program, level and backend in the baseline, using Welch's t-test.`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  BFBench,
+	}
+	cmdBench.Flags().StringSlice("level", levelNames, "Optimization levels to build the programs with")
+	cmdBench.Flags().StringSlice("backend", []string{defaultBenchBackend}, "Backends to run, il, jit, or the ones of gen --help")
+	cmdBench.Flags().IntP("reps", "n", 5, "Number of times to build and run each program")
+	cmdBench.Flags().String("json", "", "File to write the report to as JSON")
+	cmdBench.Flags().String("csv", "", "File to write the report to as CSV")
+	cmdBench.Flags().String("baseline", "", "JSON report to compare the times with")
+	cmdBench.Flags().Float64("alpha", 0.05, "Significance level of the comparison with the baseline")
+
+	var cmdCalibrate = &cobra.Command{
+		Use:   "calibrate",
+		Short: "Measure the cost of the IL operations on this machine for the optimizer",
+		Long: `This will run microbenchmarks of each type of IL block with the selected backends, and write their costs to a cost profile, which the vector balance and pattern optimizations use to decide if a rewrite pays off.
+By default, the profile is costs.json in gobf under the user's config directory, or the file named by the GOBFCOSTS environment variable. Models of other backends in the profile are kept.
+Other commands use the model of the backend they build the program with, or the default model if the profile has none.`,
+		Args: cobra.NoArgs,
+		Run:  BFCalibrate,
 	}
+	cmdCalibrate.Flags().StringSlice("backend", []string{defaultBenchBackend}, "Backends to calibrate, il, jit, or the ones of gen --help")
+	cmdCalibrate.Flags().StringP("output", "o", "", "Cost profile to write, the default profile by default")
+	cmdCalibrate.Flags().Duration("min-time", 100*time.Millisecond, "Time that the loop of a microbenchmark runs for at least")
 
 	var rootCmd = &cobra.Command{Use: "gobf"}
 	debugEnabled = rootCmd.PersistentFlags().BoolP("debug", "d", false, "Enable debug mode")
 	rootCmd.PersistentFlags().BoolP("profile", "p", false, "Enable output program self profiling. This will slow down runtime.")
+	rootCmd.PersistentFlags().Bool("unbuffered", false, "Write program output immediately instead of buffering it until input is read or the program exits")
 	rootCmd.PersistentFlags().BoolP("compress", "C", true, "Enable collapsing of repeat commands")
 	rootCmd.PersistentFlags().BoolP("prune", "P", true, "Enable pruning of dead commands")
 	rootCmd.PersistentFlags().BoolP("vectorize", "V", false, "Enable vectorizing of commands in a block")
 	rootCmd.PersistentFlags().BoolP("full-vectorize", "F", false, "Force full vectorization without deciding cost tradeoff")
 	rootCmd.PersistentFlags().StringSliceP("optimize", "O", []string{}, "Enables particular optimizations")
+	rootCmd.PersistentFlags().String("cost-profile", "", "Cost profile written by calibrate for the optimizations, the default profile if it exists by default")
 	rootCmd.AddCommand(cmdRun)
+	rootCmd.AddCommand(cmdGen)
 	rootCmd.AddCommand(cmdGenGo)
+	rootCmd.AddCommand(cmdGenC)
+	rootCmd.AddCommand(cmdGenWasm)
+	rootCmd.AddCommand(cmdGenLLVM)
+	rootCmd.AddCommand(cmdGenJS)
+	rootCmd.AddCommand(cmdGenPython)
 	rootCmd.AddCommand(cmdDumpIL)
 	rootCmd.AddCommand(cmdCompile)
+	rootCmd.AddCommand(cmdREPL)
+	rootCmd.AddCommand(cmdCache)
+	rootCmd.AddCommand(cmdMinify)
+	rootCmd.AddCommand(cmdFmt)
+	rootCmd.AddCommand(cmdVet)
+	rootCmd.AddCommand(cmdTest)
+	rootCmd.AddCommand(cmdReduce)
+	rootCmd.AddCommand(cmdBench)
+	rootCmd.AddCommand(cmdCalibrate)
 	rootCmd.Execute()
 }
@@ -0,0 +1,2 @@
+# Echo the input up to a zero byte or the end of input
+,[.[-],]
@@ -0,0 +1,3 @@
+# Reading at the end of input leaves the cell unchanged
+,.,.
+>+++++++++++++++++++++++++++++++++++++++++++++++++,.
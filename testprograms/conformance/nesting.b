@@ -0,0 +1,4 @@
+# Three nested loops add 2*3*4 = 24 to cell 3
+++[>+++[>++++[>+<-]<-]<-]
+# Print that many stars
+>>>>++++++++++++++++++++++++++++++++++++++++++<[>.<-]
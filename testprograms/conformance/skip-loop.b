@@ -0,0 +1,4 @@
+# A loop is skipped when the cell is zero, even with commands
+# that would fail inside
+[<<<.]+++++++++++++++++++++++++++++++++.
+[-]>[[[-]<<<<]]<.
@@ -0,0 +1,2 @@
+# Moving the data pointer below zero fails after the output so far
++.<.